@@ -34,6 +34,15 @@ lxy
             viz
             all
 
+    links
+            normalize
+            merge
+            subset
+            threshold
+            split
+            diff
+            query
+
     vars
             ...
 
@@ -62,6 +71,7 @@ func Control() {
         //ReproduceCommand(),
         util.VarsCommand(),
         util.SeqCommand(),
+        util.LinksCommand(),
     }
     app.Run(os.Args)
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/codegangsta/cli"
 	"os"
+	"runtime"
 	util "sequtil"
 
 	"github.com/golang/glog"
@@ -86,11 +87,16 @@ func PhaseCommand() cli.Command {
 					cli.Command{
 						Name:  "varlinks",
 						Usage: "Generate a variant links object from a set of aligned Hi-C reads.",
-						Flags: []cli.Flag{
+						Flags: append([]cli.Flag{
 							cli.StringFlag{
 								Name:  "sam",
 								Value: "",
-								Usage: "Path to sam file.",
+								Usage: "Path to SAM or BAM file of aligned reads.",
+							},
+							cli.StringFlag{
+								Name:  "pairs",
+								Value: "",
+								Usage: "Path to 4DN .pairs file with cigar and seq columns, e.g. from lxy seq sam2pairs --withSeq, in place of --sam.",
 							},
 							cli.StringFlag{
 								Name:  "vcf",
@@ -100,9 +106,14 @@ func PhaseCommand() cli.Command {
 							cli.StringFlag{
 								Name:  "output",
 								Value: "",
-								Usage: "Output path for links file.",
+								Usage: "Output path for links file, written in the binary links format if it has the extension .lxyb.",
 							},
-						},
+							cli.IntFlag{
+								Name:  "threads",
+								Value: runtime.NumCPU(),
+								Usage: "Number of threads with which to tabulate links from read pairs.",
+							},
+						}, util.PairFilterFlags()...),
 						Action: prepPhasingCommand,
 					},
 				},
@@ -226,13 +237,19 @@ func prepPhasingCommand(c *cli.Context) {
 		return
 	}
 
-	if len(c.String("sam")) == 0 {
-		glog.Errorf("error: must provide a path to a sam file with --sam\n")
+	// The link builders read .pairs files in place of SAM or BAM.
+	input := c.String("sam")
+	if len(c.String("pairs")) > 0 {
+		input = c.String("pairs")
+	}
+
+	if len(input) == 0 {
+		glog.Errorf("error: must provide a path to a sam file with --sam or a pairs file with --pairs\n")
 		return
 	}
 
-	if _, err := os.Stat(c.String("sam")); os.IsNotExist(err) {
-		glog.Errorf("error: the specified sam file does not exist: %s\n", c.String("sam"))
+	if _, err := os.Stat(input); os.IsNotExist(err) {
+		glog.Errorf("error: the specified sam or pairs file does not exist: %s\n", input)
 		return
 	}
 
@@ -241,6 +258,12 @@ func prepPhasingCommand(c *cli.Context) {
 		return
 	}
 
+	filter, ef := util.PairFilterFromContext(c)
+	if ef != nil {
+		glog.Errorf("error: %s\n", ef)
+		return
+	}
+
 	fmt.Println("Reading variants...")
 	vcf, num, _ := util.ReadVariants(c.String("vcf"))
 
@@ -250,7 +273,10 @@ func prepPhasingCommand(c *cli.Context) {
 	}
 
 	fmt.Println("Parsing sam to variant links...")
-	//util.VariantLinksFromSam(c.String("sam"), c.String("output"), vcf)
-	util.BlockLinksFromSam(c.String("sam"), c.String("output"), vcf)
+	//util.VariantLinksFromSam(input, c.String("output"), vcf, filter, c.Int("threads"))
+	if err := util.BlockLinksFromSam(input, c.String("output"), vcf, filter, c.Int("threads")); err != nil {
+		glog.Errorf("error: %s\n", err)
+		return
+	}
 
 }
@@ -21,7 +21,8 @@ type GAFixedBitstringGenome struct {
 	score    float64
 	hasscore bool
 	sfunc    func(ga *GAFixedBitstringGenome) float64
-	data *util.Links	// lxy modification
+	data *util.FrozenLinks	// lxy modification
+	ids []int	// lxy modification, the entity id of each position of the gene
 }
 
 func NewFixedBitstringGenome(i []bool, sfunc func(ga *GAFixedBitstringGenome) float64) *GAFixedBitstringGenome {
@@ -29,7 +30,7 @@ func NewFixedBitstringGenome(i []bool, sfunc func(ga *GAFixedBitstringGenome) fl
 	g.Gene = i
 	g.sfunc = sfunc
 	g.Reset()
-	g.data = &util.Links{} // lxy modification
+	g.data = &util.FrozenLinks{} // lxy modification
 	return g
 }
 
@@ -79,6 +80,7 @@ func (g *GAFixedBitstringGenome) Copy() ga.GAGenome {
 	n.score = g.score
 	n.hasscore = g.hasscore
 	n.data = g.data // lxy modification
+	n.ids = g.ids   // lxy modification
 	return n
 }
 
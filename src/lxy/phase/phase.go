@@ -7,6 +7,7 @@ import (
 	"math"
 	"math/rand"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -58,7 +59,13 @@ func Phase(links *util.Links, outPath string, iterations int, optReportFreq floa
 	fmt.Println((*links).Size())
 	genome := NewFixedBitstringGenome(make([]bool, (*links).Size()), score)
 
-	(*genome).data = links
+	(*genome).data = links.Freeze()
+
+	// The ids of entities, such as those of links subset from a larger set, need not run
+	// from zero to the size of the links, so each position of the gene is mapped to an id
+	// in ascending order, as DecodePhasing expects.
+	(*genome).ids = links.IntIDs()
+	sort.Ints((*genome).ids)
 
 	gao.Init(10, genome)
 
@@ -99,7 +106,10 @@ func Phase(links *util.Links, outPath string, iterations int, optReportFreq floa
 	best := gao.Best().(*GAFixedBitstringGenome)
 	//fmt.Println(best)
 
-	dec, _ := (*links).DecodePhasing(best.Gene)
+	dec, err := (*links).DecodePhasing(best.Gene)
+	if err != nil {
+		glog.Fatalf("error: couldn't decode phasing: %s", err)
+	}
 	fmt.Println(dec)
 
 	if e := writePhasing(dec, outPath); e != nil {
@@ -179,23 +189,23 @@ func score(g *GAFixedBitstringGenome) float64 {
 
 		for _, v := range steps {
 
-			if (i + v) < (*g.data).Size() {
+			if (i + v) < g.data.Size() {
 				c2 := g.Gene[i+v]
 				if c != c2 {
-					val, _ := (*g.data).Get(i, (i + v))
+					val := g.data.Get(g.ids[i], g.ids[i+v])
 					total -= val
 				} else {
-					val, _ := (*g.data).Get(i, (i + v))
+					val := g.data.Get(g.ids[i], g.ids[i+v])
 					total += val
 				}
 			}
 			if (i - v) > 0 {
 				c2 := g.Gene[i-v]
 				if c != c2 {
-					val, _ := (*g.data).Get(i, (i - v))
+					val := g.data.Get(g.ids[i], g.ids[i-v])
 					total -= val
 				} else {
-					val, _ := (*g.data).Get(i, (i - v))
+					val := g.data.Get(g.ids[i], g.ids[i-v])
 					total += val
 				}
 			}
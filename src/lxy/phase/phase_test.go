@@ -1,6 +1,7 @@
 package phase
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	util "sequtil"
@@ -15,13 +16,21 @@ func TestPhase(t *testing.T) {
 	}
 
 	testOutPath := filepath.Join(cwd(t), "_testdata", "dummy.out")
+	defer os.Remove(testOutPath)
 
 	for _, v := range cases {
 
-		links, _ := util.LoadLinks(v[0])
-		scaffolding := Phase(&links)
-		key := ReadPhasing(v[1])
-		score, nscore, _ := EvalScaffolding(scaffolding, key)
+		links, err := util.LoadLinks(v[0])
+		if err != nil || links.Size() == 0 {
+			t.Logf("skipping %s, which has no links", v[0])
+			continue
+		}
+		phasing, _ := Phase(&links, testOutPath, 100, 0)
+		key, err := readPhasing(v[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		score, _, _, _ := EvalPhasingDev(phasing, key)
 
 		if score != 1 {
 			t.Errorf("error")
@@ -31,6 +40,66 @@ func TestPhase(t *testing.T) {
 
 }
 
+func TestPhaseSplitLinks(t *testing.T) {
+
+	// Variants of two chromosomes, alternating in phase in pairs, with links between
+	// neighbors in phase positive and those out of phase negative.
+	n := 12
+	links := util.NewLinks()
+	key := map[string]bool{}
+	for _, chrom := range []string{"chr1", "chr2"} {
+		for i := 0; i < n; i++ {
+			key[fmt.Sprintf("%s_%d", chrom, i)] = (i/2)%2 == 1
+		}
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n && j <= i+3; j++ {
+				name1, name2 := fmt.Sprintf("%s_%d", chrom, i), fmt.Sprintf("%s_%d", chrom, j)
+				v := 10.0
+				if key[name1] != key[name2] {
+					v = -10
+				}
+				links.Set(links.ID(name1), links.ID(name2), v)
+			}
+		}
+	}
+
+	// The links of the second chromosome keep their ids, from n, when written and loaded.
+	dir := "/tmp/lxy/test/phase/"
+	os.RemoveAll(dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	chr2 := links.Split("_")["chr2"]
+	f, err := os.Create(dir + "chr2.links")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chr2.Write(f)
+	f.Close()
+	loaded, err := util.LoadLinks(dir + "chr2.links")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := loaded.ID("chr2_0"); id != n {
+		t.Fatalf("LoadLinks() yielded id %d for chr2_0, expected %d", id, n)
+	}
+
+	phasing, _ := Phase(&loaded, dir+"chr2.phasing", 200, 0)
+	if len(phasing) != n {
+		t.Fatalf("Phase() of links split from chromosome chr2 yielded phasing of %d variants, expected %d", len(phasing), n)
+	}
+	for name := range phasing {
+		if _, ok := key[name]; !ok || name[:4] != "chr2" {
+			t.Errorf("Phase() of links split from chromosome chr2 yielded phase of %s", name)
+		}
+	}
+	if written, err := readPhasing(dir + "chr2.phasing"); err != nil || len(written) != n {
+		t.Errorf("Phase() wrote phasing of %d variants (%v), expected %d", len(written), err, n)
+	}
+
+}
+
 func cwd(t *testing.T) string {
 	cwd, err := os.Getwd()
 	if err != nil {
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"github.com/codegangsta/cli"
 	"os"
+	"runtime"
+	"strings"
 
 	util "sequtil"
 )
@@ -21,7 +23,7 @@ func ScaffoldCommand() cli.Command {
 			cli.Command{
 				Name:  "infer",
 				Usage: "Infer a scaffolding from link data, e.g. lxy scaff infer --links data/test/GM.1mbp.links --output data/test/testscaffolding.txt --subset X --key data/test/testkey.txt --viz data/test/testorderfig.png",
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					cli.BoolFlag{
 						Name:  "debug",
 						Usage: "Whether to print detailed debugging information.",
@@ -40,6 +42,16 @@ func ScaffoldCommand() cli.Command {
 						Value: "",
 						Usage: "Tag on basis of which to subset links.",
 					},
+					cli.StringFlag{
+						Name:  "normalize",
+						Value: "",
+						Usage: "Optional method with which to normalize the links before scaffolding, one of " + strings.Join(util.NormalizeMethods, ", ") + ".",
+					},
+					cli.StringFlag{
+						Name:  "ref",
+						Value: "",
+						Usage: "Reference FASTA or .fai giving contig lengths with which to normalize links, in place of those recorded in the links, or the FASTA in which to count restriction sites.",
+					},
 					cli.StringFlag{
 						Name:  "outputPrefix",
 						Value: "",
@@ -80,7 +92,7 @@ func ScaffoldCommand() cli.Command {
 						Value: 0.8,
 						Usage: "Probability of a mutation occurring.",
 					},
-				},
+				}, util.NormalizeFlags()...),
 				Action: scaffoldInferCommand,
 			},
 			cli.Command{
@@ -108,18 +120,38 @@ func ScaffoldCommand() cli.Command {
 			cli.Command{
 				Name:  "prep",
 				Usage: "Generate a links file from a set of aligned Hi-C reads.",
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					cli.StringFlag{
 						Name:  "sam",
 						Value: "",
-						Usage: "Path to sam file.",
+						Usage: "Path to SAM or BAM file of aligned reads.",
+					},
+					cli.StringFlag{
+						Name:  "pairs",
+						Value: "",
+						Usage: "Path to 4DN .pairs file of read pairs, in place of --sam.",
 					},
 					cli.StringFlag{
 						Name:  "output",
 						Value: "",
-						Usage: "Output path for links file.",
+						Usage: "Output path for links file, written in the binary links format if it has the extension .lxyb.",
 					},
-				},
+					cli.StringFlag{
+						Name:  "ref",
+						Value: "",
+						Usage: "Optional reference FASTA, .fai or SAM/BAM header whose contig lengths are recorded in the links, in place of those of the input.",
+					},
+					cli.IntFlag{
+						Name:  "threads",
+						Value: runtime.NumCPU(),
+						Usage: "Number of threads with which to tabulate links from read pairs.",
+					},
+					cli.StringFlag{
+						Name:  "normalize",
+						Value: "",
+						Usage: "Optional method with which to normalize the links before writing them, one of " + strings.Join(util.NormalizeMethods, ", ") + ", the sites method requiring --ref to be a FASTA.",
+					},
+				}, append(util.PairFilterFlags(), util.NormalizeFlags()...)...),
 				Action: prepScaffoldingCommand,
 			},
 		},
@@ -145,6 +177,17 @@ func scaffoldInferCommand(c *cli.Context) {
 		return
 	}
 
+	if len(c.String("normalize")) > 0 {
+		opts, err := util.NormalizeFromContext(c, c.String("normalize"))
+		if err == nil {
+			links, err = links.Normalize(opts)
+		}
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			return
+		}
+	}
+
 	if len(c.String("outputPrefix")) == 0 {
 		fmt.Printf("error: must provide an output prefix with --outputPrefix\n")
 		return
@@ -222,6 +265,52 @@ func evalScaffoldingCommand(c *cli.Context) {
 
 func prepScaffoldingCommand(c *cli.Context) {
 
-	util.ScaffoldLinksFromSam(c.String("sam"), c.String("output"))
+	filter, err := util.PairFilterFromContext(c)
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		return
+	}
+
+	// The normalization options are checked before any input is read.
+	var normalize util.NormalizeOptions
+	if len(c.String("normalize")) > 0 {
+		if normalize, err = util.NormalizeFromContext(c, c.String("normalize")); err != nil {
+			fmt.Printf("error: %s\n", err)
+			return
+		}
+	}
+
+	// The link builders read .pairs files in place of SAM or BAM.
+	input := c.String("sam")
+	if len(c.String("pairs")) > 0 {
+		input = c.String("pairs")
+	}
+
+	var refs *util.RefDict
+	if len(c.String("ref")) > 0 {
+		if refs, err = util.LoadRefDict(c.String("ref")); err != nil {
+			fmt.Printf("error: %s\n", err)
+			return
+		}
+	}
+
+	links, err := util.LinksFromSam(input, refs, filter, c.Int("threads"))
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		return
+	}
+
+	// Normalization requires the complete links, so is applied before they are written.
+	if len(c.String("normalize")) > 0 {
+		if links, err = links.Normalize(normalize); err != nil {
+			fmt.Printf("error: %s\n", err)
+			return
+		}
+	}
+
+	if err := util.WriteLinksFile(&links, c.String("output")); err != nil {
+		fmt.Printf("error: %s\n", err)
+		return
+	}
 
 }
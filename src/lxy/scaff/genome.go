@@ -22,14 +22,14 @@ type GAOrderedIntGenome struct {
 	score    float64
 	hasscore bool
 	sfunc    func(ga *GAOrderedIntGenome) float64
-	data *util.Links	// lxy modification
+	data *util.FrozenLinks	// lxy modification
 }
 
 func NewOrderedIntGenome(i []int, sfunc func(ga *GAOrderedIntGenome) float64) *GAOrderedIntGenome {
 	g := new(GAOrderedIntGenome)
 	g.Gene = i
 	g.sfunc = sfunc
-	g.data = &util.Links{} // lxy modification
+	g.data = &util.FrozenLinks{} // lxy modification
 	return g
 }
 
@@ -3,10 +3,11 @@ package scaff
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"math"
 	"math/rand"
-	"os"
 	"os/exec"
+	"strings"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -21,35 +22,53 @@ var scores int
 e.g. lxy scaff infer --links data/test/GM.1mbp.X.links --output data/test/scaff.real.longrun.out --key data/test/testkey.txt --viz data/test/GM.1mbp.X.png
 */
 
-func WriteScaffolding(scaffolding []string, path string) error {
+// WriteScaffolding writes a contig ordering to disk, one contig name per line, gzip
+// compressing the output if the path ends in ".gz". If the reference is known, which
+// refs is nil if it is not, each name is followed by a tab and the length of the contig
+// and the source of the reference is recorded on a leading "#reference" line.
+func WriteScaffolding(scaffolding []string, refs *util.RefDict, path string) error {
 
-	out, err := os.Create(path)
+	out, err := util.CreateOutput(path)
 	if err != nil {
 		fmt.Printf("Couldn't open output file (%s) for writing: %s\n", path, err)
+		return err
 	}
-	defer out.Close()
 
+	if refs != nil && len(refs.Source) > 0 {
+		io.WriteString(out, "#reference\t"+refs.Source+"\n")
+	}
 	for _, v := range scaffolding {
 		//fmt.Println(v)
-		out.WriteString(v + "\n")
+		line := v
+		if refs != nil {
+			if length, ok := refs.Length(v); ok {
+				line = fmt.Sprintf("%s\t%d", v, length)
+			}
+		}
+		io.WriteString(out, line+"\n")
 	}
 
-	return err
+	return out.Close()
 
 }
 
+// ReadScaffolding reads a contig ordering written by WriteScaffolding, which may be
+// gzip compressed, returning the contig names and disregarding their lengths.
 func ReadScaffolding(path string) []string {
 
-	in, err := os.Open(path)
+	in, err := util.OpenInput(path)
 	if err != nil {
-		fmt.Printf("Couldn't open input file (%s) for reading: %s\n", path, err)
+		fmt.Printf("%s\n", err)
+		return []string{}
 	}
 	defer in.Close()
 
 	scaff := []string{}
 	s := bufio.NewScanner(in)
 	for s.Scan() {
-		scaff = append(scaff, s.Text())
+		if fields := strings.Fields(s.Text()); len(fields) > 0 && !strings.HasPrefix(fields[0], "#") {
+			scaff = append(scaff, fields[0])
+		}
 	}
 
 	return scaff
@@ -89,7 +108,7 @@ func Scaffold(links *util.Links, outPath string, iterations int, optReportFreq f
 	init := (*links).IntIDs()
 	genome := NewOrderedIntGenome(init, score)
 
-	(*genome).data = links
+	(*genome).data = links.Freeze()
 
 	gao.Init(40, genome)
 
@@ -127,9 +146,9 @@ func Scaffold(links *util.Links, outPath string, iterations int, optReportFreq f
 
 	best := gao.Best().(*GAOrderedIntGenome)
 	scaffolding, _ := (*links).Decode(best.Gene)
-	err := WriteScaffolding(scaffolding, outPath)
+	err := WriteScaffolding(scaffolding, (*links).Reference(), outPath)
 	if err != nil {
-		fmt.Printf("Error writing scaffolding: ", err)
+		fmt.Printf("Error writing scaffolding: %s\n", err)
 	}
 	fmt.Println(scaffolding)
 
@@ -218,12 +237,12 @@ func scoreExp(g *GAOrderedIntGenome) float64 {
 
 			//fmt.Println(offset)
 
-			if (i + offset) < (*g.data).Size() {
-				val, _ := (*g.data).Get(c, g.Gene[i+offset])
+			if (i + offset) < g.data.Size() {
+				val := g.data.Get(c, g.Gene[i+offset])
 				total += val
 			}
 			if (i - offset) > 0 {
-				val, _ := (*g.data).Get(c, g.Gene[i-offset])
+				val := g.data.Get(c, g.Gene[i-offset])
 				total += val
 			}
 
@@ -249,55 +268,55 @@ func score(g *GAOrderedIntGenome) float64 {
 
 	for i, c := range g.Gene {
 
-		if (i + 1) < (*g.data).Size() {
-			val, _ := (*g.data).Get(c, g.Gene[i+1])
+		if (i + 1) < g.data.Size() {
+			val := g.data.Get(c, g.Gene[i+1])
 			total += val
 		}
 		if (i - 1) > 0 {
-			val, _ := (*g.data).Get(c, g.Gene[i-1])
+			val := g.data.Get(c, g.Gene[i-1])
 			total += val
 		}
-		if (i + 2) < (*g.data).Size() {
-			val, _ := (*g.data).Get(c, g.Gene[i+2])
+		if (i + 2) < g.data.Size() {
+			val := g.data.Get(c, g.Gene[i+2])
 			total += 0.5 * val
 		}
 		if (i - 2) > 0 {
-			val, _ := (*g.data).Get(c, g.Gene[i-2])
+			val := g.data.Get(c, g.Gene[i-2])
 			total += 0.5 * val
 		}
-		if (i + 3) < (*g.data).Size() {
-			val, _ := (*g.data).Get(c, g.Gene[i+3])
+		if (i + 3) < g.data.Size() {
+			val := g.data.Get(c, g.Gene[i+3])
 			total += 0.33 * val
 		}
 		if (i - 3) > 0 {
-			val, _ := (*g.data).Get(c, g.Gene[i-3])
+			val := g.data.Get(c, g.Gene[i-3])
 			total += 0.33 * val
 		}
 
-		if (i + 5) < (*g.data).Size() {
-			val, _ := (*g.data).Get(c, g.Gene[i+5])
+		if (i + 5) < g.data.Size() {
+			val := g.data.Get(c, g.Gene[i+5])
 			total += 0.2 * val
 		}
 		if (i - 5) > 0 {
-			val, _ := (*g.data).Get(c, g.Gene[i-5])
+			val := g.data.Get(c, g.Gene[i-5])
 			total += 0.2 * val
 		}
 
-		if (i + 11) < (*g.data).Size() {
-			val, _ := (*g.data).Get(c, g.Gene[i+11])
+		if (i + 11) < g.data.Size() {
+			val := g.data.Get(c, g.Gene[i+11])
 			total += 0.1 * val
 		}
 		if (i - 11) > 0 {
-			val, _ := (*g.data).Get(c, g.Gene[i-11])
+			val := g.data.Get(c, g.Gene[i-11])
 			total += 0.1 * val
 		}
 
-		if (i + 20) < (*g.data).Size() {
-			val, _ := (*g.data).Get(c, g.Gene[i+20])
+		if (i + 20) < g.data.Size() {
+			val := g.data.Get(c, g.Gene[i+20])
 			total += 0.05 * val
 		}
 		if (i - 20) > 0 {
-			val, _ := (*g.data).Get(c, g.Gene[i-20])
+			val := g.data.Get(c, g.Gene[i-20])
 			total += 0.05 * val
 		}
 
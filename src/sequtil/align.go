@@ -0,0 +1,358 @@
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// The ways in which the mates of Hi-C read pairs may be aligned, see AlignOptions.
+const (
+	// Each mate is aligned independently as a single-end read, as the pair-aware
+	// heuristics of aligners, e.g. mate rescue, assume inserts much shorter than those of
+	// Hi-C, and the records of the mates then merged into pairs, see pairMates.
+	AlignMates = "mates"
+
+	// The mates are aligned together as pairs, with mate rescue and pairing disabled
+	// where the aligner allows, e.g. bwa mem -5SP.
+	AlignPaired = "paired"
+)
+
+// AlignOptions configures the alignment of Hi-C reads, see AlignHiC.
+type AlignOptions struct {
+
+	// The aligner, bwa if nil, see NewAligner
+	Aligner Aligner
+
+	// Path to the reference FASTA, which is indexed if it has not been
+	Ref string
+
+	// Paths to the R1 and R2 FASTQ files, or to an interleaved FASTQ file as R1 with R2
+	// empty, see InterleaveFastq
+	R1 string
+	R2 string
+
+	// The total number of threads used by the aligner
+	Threads int
+
+	// How the mates are aligned, AlignMates or AlignPaired
+	Mode string
+}
+
+// alignerReader is an AlignmentReader over the output of one or more running aligners.
+// Closing it waits for the aligners to exit and removes any temporary directory, and
+// returns an error if an aligner failed.
+type alignerReader struct {
+	AlignmentReader
+	header  string
+	readers []AlignmentReader
+	tmpDir  string
+}
+
+// Header returns the SAM header of the alignments, see AlignHiC.
+func (ar *alignerReader) Header() string {
+	return ar.header
+}
+
+// Close closes the output of the aligners and waits for them to exit.
+func (ar *alignerReader) Close() error {
+
+	var err error
+	for _, r := range ar.readers {
+		if e := r.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	if len(ar.tmpDir) > 0 {
+		os.RemoveAll(ar.tmpDir)
+	}
+
+	return err
+
+}
+
+// AlignHiC aligns Hi-C read pairs to a reference, checking first that the aligner is
+// available and indexing the reference if required, and returns a reader streaming the
+// alignments as they are written by the aligner, the records of each read pair being
+// adjacent. The header of the alignments has a @PG line recording the lxy command line
+// appended.
+//
+// The reader must be closed, which returns an error if the aligner failed, such that
+// alignments should not be relied upon until it has been closed without error.
+func AlignHiC(opts AlignOptions) (AlignmentReader, error) {
+
+	aligner := opts.Aligner
+	if aligner == nil {
+		aligner, _ = NewAligner("bwa")
+	}
+	if opts.Mode != AlignMates && opts.Mode != AlignPaired {
+		return nil, fmt.Errorf("Unknown alignment mode %s, expected %s or %s", opts.Mode, AlignMates, AlignPaired)
+	}
+	if opts.Threads < 1 {
+		opts.Threads = 1
+	}
+
+	version, err := aligner.Version()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("aligning with %s %s\n", aligner.Name(), version)
+	if err := aligner.Index(opts.Ref); err != nil {
+		return nil, err
+	}
+
+	ar := &alignerReader{}
+	fail := func(err error) (AlignmentReader, error) {
+		ar.Close()
+		return nil, err
+	}
+
+	// Interleaved reads are split into a file for each mate, such that aligners read
+	// their input independently of one another.
+	r1, r2 := opts.R1, opts.R2
+	if len(r2) == 0 {
+		dir, err := ioutil.TempDir("", "lxyalign")
+		if err != nil {
+			return fail(err)
+		}
+		ar.tmpDir = dir
+		r1, r2 = filepath.Join(dir, "R1.fq"), filepath.Join(dir, "R2.fq")
+		if err := DeinterleaveFastq(opts.R1, r1, r2); err != nil {
+			return fail(err)
+		}
+	}
+
+	if opts.Mode == AlignPaired {
+		r, err := aligner.Align(opts.Ref, []string{r1, r2}, opts.Threads)
+		if err != nil {
+			return fail(err)
+		}
+		ar.readers = []AlignmentReader{r}
+		ar.AlignmentReader = r
+	} else {
+		threads := opts.Threads / 2
+		if threads < 1 {
+			threads = 1
+		}
+		for _, reads := range []string{r1, r2} {
+			r, err := aligner.Align(opts.Ref, []string{reads}, threads)
+			if err != nil {
+				return fail(err)
+			}
+			ar.readers = append(ar.readers, r)
+		}
+		ar.AlignmentReader = newMateMerger(ar.readers[0], ar.readers[1])
+	}
+
+	ar.header = addProgramLine(ar.AlignmentReader.Header())
+	return ar, nil
+
+}
+
+// recordGroups iterates over the groups of adjacent records sharing a qname of an
+// AlignmentReader, such as the records of a single read output by an aligner.
+type recordGroups struct {
+	in      AlignmentReader
+	next    Alignment
+	pending bool
+	err     error
+}
+
+// Group returns the next group of records, which is empty at the end of the input or
+// on encountering a record that could not be parsed, see Err.
+func (g *recordGroups) Group() []Alignment {
+
+	group := []Alignment{}
+	if g.pending {
+		group = append(group, g.next)
+		g.pending = false
+	}
+
+	for g.err == nil && g.in.Scan() {
+		a, err := g.in.Alignment()
+		if err != nil && err != ErrUnaligned {
+			g.err = err
+			return []Alignment{}
+		}
+		if len(group) > 0 && a.qname != group[0].qname {
+			g.next, g.pending = a, true
+			break
+		}
+		group = append(group, a)
+	}
+
+	return group
+
+}
+
+// Err returns the first read or parse error encountered.
+func (g *recordGroups) Err() error {
+	if g.err != nil {
+		return g.err
+	}
+	return g.in.Err()
+}
+
+// mateMerger is an AlignmentReader merging the alignments of the R1 and R2 reads of a
+// set of read pairs, aligned independently as single-end reads in the same order, into
+// the records of read pairs, see pairMates.
+type mateMerger struct {
+	r1, r2  *recordGroups
+	records []Alignment
+	current Alignment
+	pairs   int
+	err     error
+}
+
+// newMateMerger constructs a mateMerger reading the alignments of R1 and R2 reads.
+func newMateMerger(r1, r2 AlignmentReader) *mateMerger {
+	return &mateMerger{r1: &recordGroups{in: r1}, r2: &recordGroups{in: r2}}
+}
+
+// Scan advances to the next record, returning false at the end of the input or if the
+// reads of the two inputs do not form pairs, see Err.
+func (m *mateMerger) Scan() bool {
+
+	if m.err != nil {
+		return false
+	}
+
+	if len(m.records) == 0 {
+		g1, g2 := m.r1.Group(), m.r2.Group()
+		switch {
+		case m.r1.Err() != nil:
+			m.err = m.r1.Err()
+		case m.r2.Err() != nil:
+			m.err = m.r2.Err()
+		case len(g1) == 0 && len(g2) == 0:
+			return false
+		case len(g1) == 0 || len(g2) == 0:
+			m.err = fmt.Errorf("Couldn't pair the alignments of R1 and R2 reads, which have different numbers of reads")
+		case trimMateSuffix(g1[0].qname) != trimMateSuffix(g2[0].qname):
+			m.err = fmt.Errorf("Couldn't pair the alignments of R1 and R2 reads, read %d is named %s and %s", m.pairs+1, g1[0].qname, g2[0].qname)
+		}
+		if m.err != nil {
+			return false
+		}
+		m.records = pairMates(g1, g2)
+		m.pairs += 1
+	}
+
+	m.current, m.records = m.records[0], m.records[1:]
+	return true
+
+}
+
+// Alignment returns the current record, with ErrUnaligned for the records of unaligned
+// reads, see parseSAMLine.
+func (m *mateMerger) Alignment() (Alignment, error) {
+	if m.current.rname == "*" {
+		return m.current, ErrUnaligned
+	}
+	return m.current, nil
+}
+
+// Header returns the header of the R1 alignments, the two sharing a reference.
+func (m *mateMerger) Header() string {
+	return m.r1.in.Header()
+}
+
+// Err returns the first read error, or an error if the reads of the inputs do not form
+// pairs.
+func (m *mateMerger) Err() error {
+	return m.err
+}
+
+// Close closes both inputs.
+func (m *mateMerger) Close() error {
+	err1, err2 := m.r1.in.Close(), m.r2.in.Close()
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
+
+// primaryRecord returns the primary record of a read, or its first record if it has
+// none.
+func primaryRecord(records []Alignment) Alignment {
+	for _, a := range records {
+		if !a.flag.secondary && !a.flag.supplementary {
+			return a
+		}
+	}
+	return records[0]
+}
+
+// pairMates returns the records of the R1 and R2 reads of a read pair, each aligned as
+// a single-end read, as the records of a pair, setting their flags and mate fields as
+// an aligner would from the primary record of the other mate. The template length is
+// left at zero, being of no use for Hi-C, and mate suffixes ("/1", "/2") are removed
+// from the read names.
+func pairMates(r1, r2 []Alignment) []Alignment {
+
+	mates := [2][]Alignment{r1, r2}
+	primaries := [2]Alignment{primaryRecord(r1), primaryRecord(r2)}
+
+	records := make([]Alignment, 0, len(r1)+len(r2))
+	for i, group := range mates {
+		mate := primaries[1-i]
+		for _, a := range group {
+			code := a.flag.code&^(0x2|0x8|0x20|0x40|0x80) | 0x1 | (0x40 << uint(i))
+			if mate.flag.unmapped || mate.rname == "*" {
+				code |= 0x8
+			}
+			if mate.flag.reversecomp {
+				code |= 0x20
+			}
+			a.flag = newSAMFlag(code)
+			a.qname = trimMateSuffix(a.qname)
+			a.rnext, a.pnext, a.tlen = mate.rname, mate.pos, 0
+			if a.rnext == a.rname && a.rname != "*" {
+				a.rnext = "="
+			}
+			records = append(records, a)
+		}
+	}
+
+	return records
+
+}
+
+// teeAlignmentReader is an AlignmentReader which writes each record that it reads to
+// an AlignmentWriter, such that the alignments streamed from an aligner can be both
+// written and consumed in a single pass.
+type teeAlignmentReader struct {
+	AlignmentReader
+	w   AlignmentWriter
+	err error
+}
+
+// TeeAlignments returns an AlignmentReader over the records of in which also writes
+// them to w. Write errors are returned by Err and the reader stops at the first.
+// Records which cannot be parsed, and so cannot be written by an AlignmentWriter, are
+// dropped from the output, which is therefore not a complete copy of such input.
+func TeeAlignments(in AlignmentReader, w AlignmentWriter) AlignmentReader {
+	return &teeAlignmentReader{AlignmentReader: in, w: w}
+}
+
+// Scan advances to the next record, writing it.
+func (t *teeAlignmentReader) Scan() bool {
+	if t.err != nil || !t.AlignmentReader.Scan() {
+		return false
+	}
+	a, err := t.AlignmentReader.Alignment()
+	if err != nil && err != ErrUnaligned {
+		return true
+	}
+	t.err = t.w.Write(a)
+	return t.err == nil
+}
+
+// Err returns the first read or write error.
+func (t *teeAlignmentReader) Err() error {
+	if t.err != nil {
+		return t.err
+	}
+	return t.AlignmentReader.Err()
+}
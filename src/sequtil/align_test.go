@@ -0,0 +1,198 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// fakeBWA is a stand-in for bwa which prints its version when run without arguments,
+// writes empty index files for index, and for mem logs its arguments and outputs the
+// alignments in the file named by its last argument with ".sam" appended, failing if
+// there is none.
+const fakeBWA = `#!/bin/sh
+if [ $# -eq 0 ]; then
+	echo "Program: bwa" >&2
+	echo "Version: 0.7.17-fake" >&2
+	exit 1
+fi
+if [ "$1" = index ]; then
+	for ext in amb ann bwt pac sa; do touch "$2.$ext"; done
+	exit 0
+fi
+echo "$@" >> "$0.log"
+for last; do :; done
+cat "$last.sam"
+`
+
+const fakeHeader = "@SQ\tSN:chr1\tLN:1000\n@SQ\tSN:chr2\tLN:1000\n@PG\tID:bwa\tPN:bwa\n"
+
+// writeCannedAlignments writes a reference and the canned single-end alignments of the
+// R1 and R2 reads of three read pairs to a directory, as replayed by the test aligner.
+func writeCannedAlignments(t *testing.T, dir string) {
+	writeTestFile(t, dir+"ref.fa", ">chr1\nACGT\n>chr2\nACGT\n")
+	writeTestFile(t, dir+"R1.fq.sam", fakeHeader+strings.Join([]string{
+		"read1/1\t0\tchr1\t100\t60\t10M\t*\t0\t0\tACGTACGTAC\tIIIIIIIIII",
+		"read2/1\t0\tchr1\t500\t60\t5M5S\t*\t0\t0\tACGTACGTAC\tIIIIIIIIII\tSA:Z:chr2,300,+,5S5M,60,0;",
+		"read2/1\t2048\tchr2\t300\t60\t5H5M\t*\t0\t0\tCGTAC\tIIIII\tSA:Z:chr1,500,+,5M5S,60,0;",
+		"read3/1\t0\tchr2\t50\t60\t10M\t*\t0\t0\tACGTACGTAC\tIIIIIIIIII",
+	}, "\n")+"\n")
+	writeTestFile(t, dir+"R2.fq.sam", fakeHeader+strings.Join([]string{
+		"read1/2\t16\tchr2\t200\t60\t10M\t*\t0\t0\tACGTACGTAC\tIIIIIIIIII",
+		"read2/2\t0\tchr1\t900\t60\t10M\t*\t0\t0\tACGTACGTAC\tIIIIIIIIII",
+		"read3/2\t4\t*\t0\t0\t*\t*\t0\t0\tACGTACGTAC\tIIIIIIIIII",
+	}, "\n")+"\n")
+}
+
+func TestAlignHiC(t *testing.T) {
+
+	dir := "/tmp/lxy/test/align/"
+	os.RemoveAll(dir)
+	writeCannedAlignments(t, dir)
+
+	opts := AlignOptions{Aligner: testAligner{}, Ref: dir + "ref.fa", R1: dir + "R1.fq", R2: dir + "R2.fq", Threads: 2, Mode: AlignMates}
+	in, err := AlignHiC(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(in.Header(), "@PG\tID:lxy\tPN:lxy\tPP:bwa") {
+		t.Errorf("Expected a @PG line chained to that of bwa, got header:\n%s", in.Header())
+	}
+
+	// The records of each mate are merged into pairs, with the mate fields set from the
+	// primary record of the other mate.
+	expected := []struct {
+		qname string
+		flag  int
+		rnext string
+		pnext int
+	}{
+		{"read1", 0x1 | 0x40 | 0x20, "chr2", 200},
+		{"read1", 0x1 | 0x80 | 0x10, "chr1", 100},
+		{"read2", 0x1 | 0x40, "=", 900},
+		{"read2", 0x1 | 0x40 | 0x800, "chr1", 900},
+		{"read2", 0x1 | 0x80, "=", 500},
+		{"read3", 0x1 | 0x40 | 0x8, "*", 0},
+		{"read3", 0x1 | 0x80 | 0x4, "chr2", 50},
+	}
+	out, err := CreateAlignments(dir+"aligned.sam", in.Header())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tee := TeeAlignments(in, out)
+	for i := 0; tee.Scan(); i++ {
+		a, _ := tee.Alignment()
+		if i >= len(expected) {
+			t.Errorf("Unexpected record %s", a.String())
+			continue
+		}
+		e := expected[i]
+		if a.qname != e.qname || a.flag.code != e.flag || a.rnext != e.rnext || a.pnext != e.pnext {
+			t.Errorf("Expected record %d to be %s %d %s %d, got %s", i, e.qname, e.flag, e.rnext, e.pnext, a.String())
+		}
+	}
+	if err := tee.Err(); err != nil {
+		t.Error(err)
+	}
+	out.Close()
+	if err := in.Close(); err != nil {
+		t.Error(err)
+	}
+
+	// The written alignments are read as pairs by the link builder.
+	written, err := OpenAlignments(dir + "aligned.sam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ScaffoldLinksFromAlignments(written, dir+"aligned.links", nil, nil, 2); err != nil {
+		t.Fatal(err)
+	}
+	written.Close()
+	links, err := LoadLinks(dir + "aligned.links")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := links.Get(links.ID("chr1"), links.ID("chr2")); v != 1 {
+		t.Errorf("Expected 1 chr1-chr2 link, got %f", v)
+	}
+	if v, _ := links.Get(links.ID("chr1"), links.ID("chr1")); v != 1 {
+		t.Errorf("Expected 1 chr1-chr1 link, got %f", v)
+	}
+
+	// Reads of the two mates that don't form pairs are an error.
+	writeTestFile(t, dir+"R3.fq.sam", fakeHeader+"read9/2\t0\tchr1\t900\t60\t10M\t*\t0\t0\tACGTACGTAC\tIIIIIIIIII\n")
+	opts.R2 = dir + "R3.fq"
+	in, err = AlignHiC(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for in.Scan() {
+	}
+	if in.Err() == nil {
+		t.Errorf("Expected an error pairing reads of different names")
+	}
+	in.Close()
+
+}
+
+func TestBWAAligner(t *testing.T) {
+
+	dir := "/tmp/lxy/test/alignbwa/"
+	os.RemoveAll(dir)
+	writeCannedAlignments(t, dir)
+	writeTestFile(t, dir+"bwa", fakeBWA)
+	os.Chmod(dir+"bwa", 0755)
+	aligner := &bwaAligner{dir + "bwa"}
+
+	if version, err := aligner.Version(); err != nil || version != "0.7.17-fake" {
+		t.Errorf("Expected version 0.7.17-fake, got %s (%v)", version, err)
+	}
+	if _, err := (&bwaAligner{dir + "missing"}).Version(); err == nil {
+		t.Errorf("Expected an error for a missing aligner")
+	}
+
+	// The reference is indexed, and each mate aligned as single-end reads.
+	opts := AlignOptions{Aligner: aligner, Ref: dir + "ref.fa", R1: dir + "R1.fq", R2: dir + "R2.fq", Threads: 4, Mode: AlignMates}
+	in, err := AlignHiC(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir + "ref.fa.bwt"); err != nil {
+		t.Errorf("Expected the reference to be indexed: %s", err)
+	}
+	n := 0
+	for in.Scan() {
+		n += 1
+	}
+	if err := in.Close(); err != nil || n != 7 {
+		t.Errorf("Expected 7 records, got %d (%v)", n, err)
+	}
+	// The two mates are aligned concurrently, so may be logged in either order.
+	b, _ := ioutil.ReadFile(dir + "bwa.log")
+	runs := strings.Split(strings.TrimSpace(string(b)), "\n")
+	sort.Strings(runs)
+	expected := []string{"mem -5 -t 2 " + dir + "ref.fa " + dir + "R1.fq", "mem -5 -t 2 " + dir + "ref.fa " + dir + "R2.fq"}
+	if !reflect.DeepEqual(runs, expected) {
+		t.Errorf("Expected bwa to be run as %v, got %v", expected, runs)
+	}
+
+	// The failure of the aligner is returned on closing.
+	opts.Mode, opts.R2 = AlignPaired, dir+"missing.fq"
+	in, err = AlignHiC(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for in.Scan() {
+	}
+	if err := in.Close(); err == nil {
+		t.Errorf("Expected an error from the failed aligner")
+	}
+
+	if _, err := NewAligner("novoalign"); err == nil {
+		t.Errorf("Expected an error for an unknown aligner")
+	}
+
+}
@@ -0,0 +1,272 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Aligner is a read aligner with which Hi-C reads are aligned to a reference, see
+// AlignHiC. Backends are provided for bwa mem, minimap2 and bowtie2, along with a test
+// backend replaying canned alignments, see NewAligner.
+type Aligner interface {
+
+	// Name returns the name of the aligner, as accepted by NewAligner.
+	Name() string
+
+	// Version checks that the aligner is available, returning its version.
+	Version() (string, error)
+
+	// Index indexes a reference FASTA for alignment, unless each of its index files
+	// exists and is no older than the FASTA file.
+	Index(ref string) error
+
+	// Align starts aligning reads to an indexed reference with the specified number of
+	// threads, returning a reader streaming the alignments in the order of the reads.
+	// Given a single FASTQ file the reads are aligned as single-end reads, and given
+	// two the reads are aligned as pairs, as far as possible without the mate rescue
+	// and pairing heuristics that assume short inserts. Closing the reader waits for
+	// the aligner to exit, returning an error if it failed.
+	Align(ref string, reads []string, threads int) (AlignmentReader, error)
+}
+
+// AlignerNames are the names of the aligners accepted by NewAligner.
+var AlignerNames = []string{"bwa", "minimap2", "bowtie2", "test"}
+
+// NewAligner returns the Aligner with the specified name, one of AlignerNames, whose
+// executables are found on the PATH.
+func NewAligner(name string) (Aligner, error) {
+	switch strings.ToLower(name) {
+	case "bwa":
+		return &bwaAligner{"bwa"}, nil
+	case "minimap2":
+		return &minimap2Aligner{"minimap2"}, nil
+	case "bowtie2":
+		return &bowtie2Aligner{"bowtie2", "bowtie2-build"}, nil
+	case "test":
+		return testAligner{}, nil
+	}
+	return nil, fmt.Errorf("Unknown aligner %s, expected one of %s", name, strings.Join(AlignerNames, ", "))
+}
+
+// indexed determines whether each of the index files of a reference, its path with one
+// of the extensions appended, exists and is no older than the reference.
+func indexed(ref string, extensions []string) (bool, error) {
+
+	fi, err := os.Stat(ref)
+	if err != nil {
+		return false, fmt.Errorf("Couldn't open reference (%s): %s", ref, err)
+	}
+	for _, ext := range extensions {
+		if ii, err := os.Stat(ref + ext); err != nil || ii.ModTime().Before(fi.ModTime()) {
+			return false, nil
+		}
+	}
+	return true, nil
+
+}
+
+// indexWith indexes a reference by running a command, unless it is already indexed, see
+// indexed.
+func indexWith(ref string, extensions []string, name string, args ...string) error {
+
+	done, err := indexed(ref, extensions)
+	if err != nil || done {
+		return err
+	}
+
+	fmt.Printf("indexing reference %s with %s...\n", ref, name)
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Couldn't index reference (%s) with %s: %s", ref, name, err)
+	}
+
+	return nil
+
+}
+
+// toolVersion runs a command to report the version of a tool, returning the first line
+// of its combined output which contains prefix, with prefix and any surrounding
+// whitespace removed. The exit status of the command is disregarded, as some tools exit
+// with an error when printing their usage.
+func toolVersion(prefix, name string, args ...string) (string, error) {
+
+	if _, err := exec.LookPath(name); err != nil {
+		return "", fmt.Errorf("Couldn't find %s, which must be installed and on the PATH: %s", name, err)
+	}
+
+	b, _ := exec.Command(name, args...).CombinedOutput()
+	for _, line := range strings.Split(string(b), "\n") {
+		if i := strings.Index(line, prefix); i >= 0 {
+			return strings.TrimSpace(line[i+len(prefix):]), nil
+		}
+	}
+
+	return "", fmt.Errorf("Couldn't determine the version of %s", name)
+
+}
+
+// processReader is an AlignmentReader over the SAM output of a running aligner.
+type processReader struct {
+	*samReader
+	cmd *exec.Cmd
+}
+
+// Close closes the output of the aligner and waits for it to exit.
+func (pr *processReader) Close() error {
+	pr.samReader.Close()
+	if err := pr.cmd.Wait(); err != nil {
+		return fmt.Errorf("An error occurred during sequence alignment with %s: %s", pr.cmd.Path, err)
+	}
+	return nil
+}
+
+// startAligner starts an aligner writing SAM to its standard output, returning a reader
+// of its output, see processReader.
+func startAligner(name string, args ...string) (AlignmentReader, error) {
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		r.Close()
+		w.Close()
+		return nil, fmt.Errorf("Couldn't run %s: %s", name, err)
+	}
+	w.Close()
+
+	return &processReader{newSAMReader(&inputFile{Reader: bufio.NewReader(r), f: r}), cmd}, nil
+
+}
+
+// bwaAligner aligns reads with bwa mem, marking the 5'-most segment of chimeric reads as
+// primary (-5) and, for pairs, disabling mate rescue and pairing (-SP).
+type bwaAligner struct {
+	path string
+}
+
+// Name returns "bwa".
+func (a *bwaAligner) Name() string {
+	return "bwa"
+}
+
+// Version returns the version given by the usage of bwa.
+func (a *bwaAligner) Version() (string, error) {
+	return toolVersion("Version:", a.path)
+}
+
+// Index indexes a reference with bwa index.
+func (a *bwaAligner) Index(ref string) error {
+	return indexWith(ref, []string{".amb", ".ann", ".bwt", ".pac", ".sa"}, a.path, "index", ref)
+}
+
+// Align starts bwa mem.
+func (a *bwaAligner) Align(ref string, reads []string, threads int) (AlignmentReader, error) {
+	args := []string{"mem", "-5", "-t", strconv.Itoa(threads)}
+	if len(reads) > 1 {
+		args[1] = "-5SP"
+	}
+	return startAligner(a.path, append(append(args, ref), reads...)...)
+}
+
+// minimap2Aligner aligns reads with minimap2 using its short read preset (-ax sr),
+// against an index written alongside the reference with the extension ".mmi".
+type minimap2Aligner struct {
+	path string
+}
+
+// Name returns "minimap2".
+func (a *minimap2Aligner) Name() string {
+	return "minimap2"
+}
+
+// Version returns the version reported by minimap2 --version.
+func (a *minimap2Aligner) Version() (string, error) {
+	return toolVersion("", a.path, "--version")
+}
+
+// Index writes the minimap2 index of a reference for its short read preset.
+func (a *minimap2Aligner) Index(ref string) error {
+	return indexWith(ref, []string{".mmi"}, a.path, "-x", "sr", "-d", ref+".mmi", ref)
+}
+
+// Align starts minimap2.
+func (a *minimap2Aligner) Align(ref string, reads []string, threads int) (AlignmentReader, error) {
+	args := []string{"-ax", "sr", "-t", strconv.Itoa(threads), ref + ".mmi"}
+	return startAligner(a.path, append(args, reads...)...)
+}
+
+// bowtie2Aligner aligns reads with bowtie2, indexing references with bowtie2-build.
+// Output is kept in the order of the reads (--reorder), as required to pair the
+// alignments of mates aligned independently.
+type bowtie2Aligner struct {
+	path      string
+	buildPath string
+}
+
+// Name returns "bowtie2".
+func (a *bowtie2Aligner) Name() string {
+	return "bowtie2"
+}
+
+// Version returns the version reported by bowtie2, checking that bowtie2-build, which
+// is needed for indexing, is also available.
+func (a *bowtie2Aligner) Version() (string, error) {
+	if _, err := exec.LookPath(a.buildPath); err != nil {
+		return "", fmt.Errorf("Couldn't find %s, which must be installed and on the PATH: %s", a.buildPath, err)
+	}
+	return toolVersion("version", a.path, "--version")
+}
+
+// Index indexes a reference with bowtie2-build, the index sharing its path.
+func (a *bowtie2Aligner) Index(ref string) error {
+	extensions := []string{".1.bt2", ".2.bt2", ".3.bt2", ".4.bt2", ".rev.1.bt2", ".rev.2.bt2"}
+	return indexWith(ref, extensions, a.buildPath, ref, ref)
+}
+
+// Align starts bowtie2.
+func (a *bowtie2Aligner) Align(ref string, reads []string, threads int) (AlignmentReader, error) {
+	args := []string{"--reorder", "-p", strconv.Itoa(threads), "-x", ref}
+	if len(reads) > 1 {
+		args = append(args, "-1", reads[0], "-2", reads[1])
+	} else {
+		args = append(args, "-U", reads[0])
+	}
+	return startAligner(a.path, args...)
+}
+
+// testAligner replays canned alignments in place of aligning reads, for testing. The
+// alignments of a FASTQ file are read from the file of the same path with ".sam"
+// appended, those of a pair of files from that of the first file.
+type testAligner struct{}
+
+// Name returns "test".
+func (a testAligner) Name() string {
+	return "test"
+}
+
+// Version returns "test", the test aligner always being available.
+func (a testAligner) Version() (string, error) {
+	return "test", nil
+}
+
+// Index only checks that the reference exists.
+func (a testAligner) Index(ref string) error {
+	_, err := indexed(ref, nil)
+	return err
+}
+
+// Align opens the canned alignments of the reads.
+func (a testAligner) Align(ref string, reads []string, threads int) (AlignmentReader, error) {
+	return OpenAlignments(reads[0] + ".sam")
+}
@@ -3,8 +3,8 @@ package util
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	//"github.com/codegangsta/cli"
@@ -24,6 +24,7 @@ type Alignment struct {
 	tlen  int     // observed Template LENgth
 	seq   string  // segment SEQuence
 	qual  string  // ASCII of Phred-scaled base QUALity+33ASCII of Phred-scaled base QUALity+33
+	tags  SAMTags // OPTional fields, e.g. NM:i:1, see SAMTag
 }
 
 // SAMFlag represents in decoded (easily accessible) for a SAM bitwise flag.
@@ -50,6 +51,13 @@ func parseSAMFlag(encodedString string) (SAMFlag, error) {
 	// Convert the string flag into an integer
 	encoded, _ := strconv.Atoi(encodedString)
 
+	return newSAMFlag(encoded), nil
+
+}
+
+// newSAMFlag decodes an integer SAM flag into a structured SAMFlag object.
+func newSAMFlag(encoded int) SAMFlag {
+
 	return SAMFlag{
 		(encoded&0x1 != 0),
 		(encoded&0x2 != 0),
@@ -64,7 +72,7 @@ func parseSAMFlag(encodedString string) (SAMFlag, error) {
 		(encoded&0x400 != 0),
 		(encoded&0x800 != 0),
 		encoded,
-	}, nil
+	}
 
 }
 
@@ -98,6 +106,10 @@ func (c *CIGAR) Add(val int, code string) {
 // String takes a structured CIGAR object and returns a compacted string representation
 // of the kind that can be read and written to a SAM file.
 func (c *CIGAR) String() string {
+	// An empty CIGAR, as of an unaligned read, is written as "*"
+	if len(*c) == 0 {
+		return "*"
+	}
 	cstring := ""
 	// Append each CIGARCode element to the output string in VALUECODE format.
 	for _, cc := range *c {
@@ -106,10 +118,21 @@ func (c *CIGAR) String() string {
 	return cstring
 }
 
+// refLength returns the number of reference bases spanned by an alignment with
+// the CIGAR, i.e. the summed length of its M, D, N, = and X operations.
+func (c CIGAR) refLength() int {
+	length := 0
+	for _, cc := range c {
+		switch cc.code {
+		case "M", "D", "N", "=", "X":
+			length += cc.value
+		}
+	}
+	return length
+}
+
 // if it's star, handle that appropriately <- **
 func parseCIGAR(cigarString string) (CIGAR, error) {
-	// for now, must contain only S, H, or M
-	///disallow := map[string]int{"I":1, "D":1, "N":1, "P":1, "=":1, "X":1,}
 	cigar := CIGAR{}
 	lastIndex := 0
 	for i, c := range cigarString {
@@ -126,7 +149,12 @@ func parseCIGAR(cigarString string) (CIGAR, error) {
 	return cigar, nil
 }
 
-// parseSAMLine takes a SAM formatted line and parses it into an Alignment object.
+// ErrUnaligned is the error returned along with the records of reads that did not align
+// to any reference sequence, which are otherwise parsed in full.
+var ErrUnaligned = fmt.Errorf("Read did not align")
+
+// parseSAMLine takes a SAM formatted line and parses it into an Alignment object,
+// returning ErrUnaligned for the records of unaligned reads.
 func parseSAMLine(line string) (Alignment, error) {
 	arr := strings.Split(line, "\t")
 	if len(arr) < 11 {
@@ -141,10 +169,6 @@ func parseSAMLine(line string) (Alignment, error) {
 	}
 
 	rname := arr[2]
-	if rname == "*" {
-		// didn't align, return null alignment for now
-		return Alignment{}, fmt.Errorf("Read did not align")
-	}
 
 	pos, ep := strconv.Atoi(arr[3])
 	if ep != nil {
@@ -180,9 +204,18 @@ func parseSAMLine(line string) (Alignment, error) {
 	seq := arr[9]
 	qual := arr[10]
 
+	tags, et := parseSAMTags(arr[11:])
+	if et != nil {
+		return Alignment{}, et
+	}
+
 	a := Alignment{qname, samflag,
 		rname, pos, mapq, c, rnext,
-		pnext, tlen, seq, qual}
+		pnext, tlen, seq, qual, tags}
+
+	if rname == "*" {
+		return a, ErrUnaligned
+	}
 
 	return a, nil
 
@@ -192,31 +225,219 @@ func parseSAMLine(line string) (Alignment, error) {
 func (a Alignment) String() string {
 	stringCIGAR := a.cigar.String()
 	stringAlignment := fmt.Sprintf("%s\t%d\t%s\t%d\t%d\t%s\t%s\t%d\t%d\t%s\t%s", a.qname, a.flag.code, a.rname, a.pos, a.mapq, stringCIGAR, a.rnext, a.pnext, a.tlen, a.seq, a.qual)
+	if len(a.tags) > 0 {
+		stringAlignment += "\t" + a.tags.String()
+	}
 	return stringAlignment
 }
 
+// AlignmentReader iterates over the records of a SAM or BAM file in the manner of a
+// bufio.Scanner, with Scan advancing to the next record and Alignment returning it.
+type AlignmentReader interface {
+
+	// Scan advances to the next alignment record, returning false at the end of the
+	// input or when a read error occurs.
+	Scan() bool
+
+	// Alignment returns the current record along with any error from parsing it, e.g.
+	// for reads that did not align.
+	Alignment() (Alignment, error)
+
+	// Header returns the SAM header text of the input, one "@" line per header line.
+	Header() string
+
+	// Err returns the first read error encountered by Scan.
+	Err() error
+
+	// Close closes the underlying file.
+	Close() error
+}
+
+// AlignmentWriter writes Alignment records to a SAM or BAM file.
+type AlignmentWriter interface {
+	Write(a Alignment) error
+	Close() error
+}
+
+// samReader reads Alignment records from a plain text SAM file.
+type samReader struct {
+	in      io.Closer
+	s       *bufio.Scanner
+	header  string
+	line    string
+	pending bool
+}
+
+// newSAMReader consumes the header lines at the start of a SAM file and returns a
+// samReader positioned at the first alignment record.
+func newSAMReader(in *inputFile) *samReader {
+
+	sr := &samReader{in: in, s: bufio.NewScanner(in)}
+	sr.s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	header := []string{}
+	for sr.s.Scan() {
+		line := sr.s.Text()
+		if len(line) > 0 && string(line[0]) != "@" {
+			sr.line = line
+			sr.pending = true
+			break
+		}
+		if len(line) > 0 {
+			header = append(header, line+"\n")
+		}
+	}
+	sr.header = strings.Join(header, "")
+
+	return sr
+
+}
+
+// Scan advances to the next non-empty line of the SAM file.
+func (sr *samReader) Scan() bool {
+	if sr.pending {
+		sr.pending = false
+		return true
+	}
+	for sr.s.Scan() {
+		sr.line = sr.s.Text()
+		if len(sr.line) > 0 && string(sr.line[0]) != "@" {
+			return true
+		}
+	}
+	return false
+}
+
+// Alignment parses the current SAM line, see parseSAMLine.
+func (sr *samReader) Alignment() (Alignment, error) {
+	return parseSAMLine(sr.line)
+}
+
+// Header returns the header lines read from the start of the SAM file.
+func (sr *samReader) Header() string {
+	return sr.header
+}
+
+// Err returns the first error encountered by the underlying scanner.
+func (sr *samReader) Err() error {
+	return sr.s.Err()
+}
+
+// Close closes the underlying SAM file.
+func (sr *samReader) Close() error {
+	return sr.in.Close()
+}
+
+// OpenAlignments opens a SAM, gzipped SAM or BAM file for reading, determining which
+// of these it is from the content of the file rather than its extension.
+func OpenAlignments(path string) (AlignmentReader, error) {
+
+	in, err := openInput(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// BAM files are BGZF compressed and the decompressed stream begins with the BAM
+	// magic string.
+	if magic, _ := in.Peek(len(bamMagic)); in.gz != nil && string(magic) == string(bamMagic) {
+		br, err := newBAMReader(in)
+		if err != nil {
+			in.Close()
+			return nil, fmt.Errorf("Couldn't read input file (%s): %s", path, err)
+		}
+		return br, nil
+	}
+
+	return newSAMReader(in), nil
+
+}
+
+// samWriter writes Alignment records to a plain text SAM file.
+type samWriter struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+// Write writes an Alignment as a single SAM line.
+func (sw *samWriter) Write(a Alignment) error {
+	_, err := sw.w.WriteString(a.String() + "\n")
+	return err
+}
+
+// Close flushes buffered records and closes the underlying SAM file.
+func (sw *samWriter) Close() error {
+	if err := sw.w.Flush(); err != nil {
+		return err
+	}
+	return sw.f.Close()
+}
+
+// CreateAlignments creates an alignment file with the provided SAM header text,
+// writing BAM if the path ends in ".bam" and SAM otherwise.
+func CreateAlignments(path, header string) (AlignmentWriter, error) {
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasSuffix(path, ".bam") {
+		return newBAMWriter(f, header)
+	}
+
+	sw := &samWriter{f, bufio.NewWriter(f)}
+	sw.w.WriteString(header)
+	return sw, nil
+
+}
+
+// GenomePositions walks the CIGAR of an alignment and returns a map from each reference
+// position covered by the read to the read's allele at that position.
+//
+// For aligned bases (M, = and X) the allele is the single read base. Bases inserted
+// relative to the reference (I) are appended to the allele of the reference position
+// preceding the insertion, and reference positions deleted from the read (D) are
+// present with an empty allele, such that indel alleles can be read off the map in
+// the VCF convention of an anchor base followed by the inserted or deleted sequence.
+// Skipped regions (N), padding (P) and clipping (S, H) contribute no positions.
 func GenomePositions(a Alignment) (map[int]string, error) {
 
 	hits := map[int]string{}
-	offset := 0
-	firstH := 1
+	readOffset := 0
+	refOffset := 0
 	for _, c := range a.cigar {
-		if c.code == "M" {
+		switch c.code {
+		case "M", "=", "X":
+			if readOffset+c.value > len(a.seq) {
+				return map[int]string{}, fmt.Errorf("CIGAR consumes more bases than are present in the read sequence.")
+			}
 			for i := 0; i < c.value; i++ {
-				ind := (a.pos + offset)
-				hits[ind] = string(a.seq[offset])
-				offset += 1
+				hits[a.pos+refOffset] = string(a.seq[readOffset])
+				readOffset += 1
+				refOffset += 1
+			}
+		case "I":
+			if readOffset+c.value > len(a.seq) {
+				return map[int]string{}, fmt.Errorf("CIGAR consumes more bases than are present in the read sequence.")
 			}
-		} else if c.code == "H" {
-			if firstH == 0 {
-				break
+			// An insertion is attributed to the preceding aligned reference base,
+			// unless it occurs before any such base.
+			if anchor, ok := hits[a.pos+refOffset-1]; ok {
+				hits[a.pos+refOffset-1] = anchor + a.seq[readOffset:readOffset+c.value]
 			}
-			firstH = 0
-		} else if c.code == "S" {
+			readOffset += c.value
+		case "D":
 			for i := 0; i < c.value; i++ {
-				offset += 1
+				hits[a.pos+refOffset] = ""
+				refOffset += 1
 			}
-		} else {
+		case "N":
+			refOffset += c.value
+		case "S":
+			readOffset += c.value
+		case "H", "P":
+			// consume neither read nor reference
+		default:
 			return map[int]string{}, fmt.Errorf("Unsupported code in CIGAR.")
 		}
 	}
@@ -225,6 +446,38 @@ func GenomePositions(a Alignment) (map[int]string, error) {
 
 }
 
+// callAllele determines whether the read alleles in a map of genome positions (see
+// GenomePositions) match the reference ("R") or alternate ("A") allele of a variant,
+// or neither ("N").
+//
+// The read allele is assembled over the full span of the reference allele so that
+// indels, given in the VCF convention of a shared anchor base, are called in the
+// same way as SNVs. The second return value is false if the read does not cover
+// that span and so cannot be called.
+func callAllele(gp map[int]string, pos int, v variant) (string, bool) {
+
+	allele := ""
+	for i := 0; i < len(v.Ref); i++ {
+		base, ok := gp[pos+i]
+		if !ok {
+			return "", false
+		}
+		allele += base
+	}
+
+	if allele == v.Ref {
+		return "R", true
+	} else if allele == v.Alt {
+		return "A", true
+	}
+	return "N", true
+
+}
+
+// GetVariants takes a map of positions and read alleles at those positions (see
+// GenomePositions) and returns, for each position with a known variant, whether the
+// read carries the reference ("R") or alternate ("A") allele of the variant or neither
+// ("N"). Indels are called the same way as SNVs, see callAllele.
 func GetVariants(chrom string, gp map[int]string, vars *Variants) map[int]string {
 
 	if _, ok := (*vars).data[chrom]; !ok {
@@ -234,27 +487,21 @@ func GetVariants(chrom string, gp map[int]string, vars *Variants) map[int]string
 		return map[int]string{} // no variants for this chromosome...
 	}
 
-	for pos, v := range gp {
+	// Build the calls in a separate map as calling an indel reads the alleles of the
+	// positions following the variant position.
+	calls := map[int]string{}
+	for pos, _ := range gp {
 		if _, ok := (*vars).data[chrom][pos]; !ok {
 			//fmt.Printf("didn't find position %d in variant set\n", pos)
-			delete(gp, pos)
-		} else {
-			// mark whether the variant is reference, alternate, or something else
-			//fmt.Println((*vars)[chrom][pos])
-			if v == (*vars).data[chrom][pos].Ref {
-				gp[pos] = "R"
-				//fmt.Printf("Reference: %s %s %d %s\n", v, chrom, pos, (*vars)[chrom][pos][3])
-			} else if v == (*vars).data[chrom][pos].Alt {
-				gp[pos] = "A"
-				//fmt.Printf("Alternate: %s %s %d %s\n", v, chrom, pos, (*vars)[chrom][pos][4])
-			} else {
-				gp[pos] = "N"
-				//fmt.Printf("Other: %s %s %d\n", v, chrom, pos)
-			}
+			continue
+		}
+		// mark whether the variant is reference, alternate, or something else
+		if call, ok := callAllele(gp, pos, (*vars).data[chrom][pos]); ok {
+			calls[pos] = call
 		}
 	}
 
-	return gp
+	return calls
 
 }
 
@@ -274,15 +521,16 @@ func GetVariantBlocks(chrom string, gp map[int]string, vars *Variants) map[int][
 		return map[int][]int{}
 	}
 
-	for pos, v := range gp {
+	for pos, _ := range gp {
 
-		if _, ok := (*vars).data[chrom][pos]; !ok {
-			delete(gp, pos)
-		} else {
+		if _, ok := (*vars).data[chrom][pos]; ok {
 			//fmt.Println("met cond 1")
-			//fmt.Println("chrom, pos:", chrom, pos, v)
 			//fmt.Println((*vars).data[chrom][pos].Ref, (*vars).data[chrom][pos].Alt)
-			if v == (*vars).data[chrom][pos].Ref {
+			call, covered := callAllele(gp, pos, (*vars).data[chrom][pos])
+			if !covered {
+				continue
+			}
+			if call == "R" {
 				//fmt.Println("calling ref")
 				if blockNum, ok := (*vars).data[chrom][pos].Info["BLOCK"]; ok {
 					//fmt.Println("met condition")
@@ -292,7 +540,7 @@ func GetVariantBlocks(chrom string, gp map[int]string, vars *Variants) map[int][
 					}
 					ret[n][0] += 1
 				}
-			} else if v == (*vars).data[chrom][pos].Alt {
+			} else if call == "A" {
 				//fmt.Println("calling alt")
 				if blockNum, ok := (*vars).data[chrom][pos].Info["BLOCK"]; ok {
 					//fmt.Println("met condition")
@@ -322,92 +570,81 @@ func GetVariantBlocks(chrom string, gp map[int]string, vars *Variants) map[int][
 
 }
 
-// VariantLinksFromSam parses a sam file, constructing a Links object
-// representing simple counts of association between variants.
-func VariantLinksFromSam(samPath, outPath string, vars Variants) {
+// checkPairsSequences returns an error if the input is a .pairs file without the CIGAR
+// and sequence columns of both ends, cigar1, cigar2, seq1 and seq2, from which the bases
+// read at variants are found. The files written by pairtools or Juicer lack them, each
+// end then being a single base of unknown sequence, see pairEndAlignment.
+func checkPairsSequences(path string) error {
 
-	out, err1 := os.Create(outPath)
-	if err1 != nil {
-		fmt.Printf("Couldn't open output file (%s) for reading: %s\n", outPath, err1)
+	if !IsPairsFile(path) {
+		return nil
 	}
-	defer out.Close()
-
-	in, err2 := os.Open(samPath)
-	if err2 != nil {
-		fmt.Printf("Couldn't open input file (%s) for reading: %s\n", samPath, err2)
+	pr, err := OpenPairs(path)
+	if err != nil {
+		return err
 	}
-	defer in.Close()
+	defer pr.Close()
 
-	links := NewLinks()
-	currentID := ""
-	hits := []Alignment{}
-	s := bufio.NewScanner(in)
-	linkedVariants := 0
-	lineCount := 0
-	balance := 0
-	//maxlines := 1000000
+	missing := []string{}
+	for _, name := range []string{"cigar1", "cigar2", "seq1", "seq2"} {
+		if pr.Header().column(name) < 0 {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("Couldn't link variants from %s, a .pairs file without the columns %s giving the sequence of each end", path, strings.Join(missing, ", "))
+	}
+	return nil
 
-	for s.Scan() {
-		line := s.Text()
-		lineCount += 1
-		/*if lineCount >  maxlines {
-			break
-		}*/
-		if string(line[0]) != "@" {
+}
 
-			a, e := parseSAMLine(s.Text())
-			if e != nil {
-				//fmt.Printf("error parsing sam line, continuing: %s, %s\n", e, line)
-				hits = []Alignment{}
-				currentID = ""
-				continue
-			}
+// VariantLinksFromSam parses a sam file, constructing a Links object
+// representing simple counts of association between variants. Read pairs are counted
+// only if they pass the filter, which may be nil to count every pair. Pairs are
+// tabulated by the specified number of threads, see buildLinksFromSam, the links
+// written being the same for any number of threads. A .pairs input must give the
+// sequence of each end, see checkPairsSequences.
+func VariantLinksFromSam(samPath, outPath string, vars Variants, filter *PairFilterChain, threads int) error {
+
+	if err := checkPairsSequences(samPath); err != nil {
+		return err
+	}
 
-			if a.qname != currentID {
-				if (len(hits) == 2) && (len(currentID) > 0) {
-					if hits[0].rname == hits[1].rname { // same chromosome
+	out, err1 := CreateOutput(outPath)
+	if err1 != nil {
+		return fmt.Errorf("Couldn't open output file (%s) for writing: %s", outPath, err1)
+	}
 
-						gp1, egp1 := GenomePositions(hits[0])
-						gp2, egp2 := GenomePositions(hits[1])
-						if (egp1 != nil) || (egp2 != nil) {
-							//fmt.Println("continuing")
-							//fmt.Println(gp1)
-							//fmt.Println(gp2)
-							hits = []Alignment{}
-							currentID = ""
-							continue
-						}
-						//fmt.Println(hits[0].rname)
-						varPos1 := GetVariants(hits[0].rname, gp1, &vars)
-						varPos2 := GetVariants(hits[0].rname, gp2, &vars)
-						// requires both on same chromosome, otherwise bug in following
-						ct1, bal1 := links.TabulateVariantLinks(hits[0].rname, varPos1, varPos2)
-						ct2, bal2 := links.TabulateVariantLinks(hits[0].rname, varPos1, varPos1)
-						ct3, bal3 := links.TabulateVariantLinks(hits[0].rname, varPos2, varPos2)
-						ct := ct1 + ct2 + ct3
-						bal := bal1 + bal2 + bal3
-						if ct > 0 {
-							//fmt.Println(varPos1)
-							//fmt.Println(varPos2)
-							//fmt.Println(hits)
-							linkedVariants += ct
-							balance += bal
-							fmt.Printf("linked a total of %d variants in %d lines with balance %d\n", linkedVariants, lineCount, balance)
-						}
-						//fmt.Println(varPos1)
-						//fmt.Println(varPos2)
-					}
-				}
-				currentID = a.qname
-				hits = []Alignment{}
-			}
+	links, err2 := buildLinksFromSam(samPath, filter, threads, func(shard *Links, a1, a2 Alignment) {
 
-			hits = append(hits, a)
+		if a1.rname != a2.rname { // requires both on same chromosome
+			return
+		}
 
+		gp1, egp1 := GenomePositions(a1)
+		gp2, egp2 := GenomePositions(a2)
+		if (egp1 != nil) || (egp2 != nil) {
+			return
 		}
+
+		varPos1 := GetVariants(a1.rname, gp1, &vars)
+		varPos2 := GetVariants(a1.rname, gp2, &vars)
+		shard.TabulateVariantLinks(a1.rname, varPos1, varPos2)
+		shard.TabulateVariantLinks(a1.rname, varPos1, varPos1)
+		shard.TabulateVariantLinks(a1.rname, varPos2, varPos2)
+
+	})
+	if err2 != nil {
+		out.Close()
+		return err2
 	}
 
-	links.Write(out)
+	fmt.Print(filter.Summary())
+	if err := writeLinks(&links, out, outPath); err != nil {
+		out.Close()
+		return fmt.Errorf("Couldn't write links to %s: %s", outPath, err)
+	}
+	return out.Close()
 
 }
 
@@ -456,101 +693,57 @@ readid-7.2	16	chr3	200	60	3M	*	0	0	GCG	III
 
 */
 
-// VariantLinksFromSam parses a sam file, constructing a Links object
-// representing simple counts of association between variants.
-func BlockLinksFromSam(samPath, outPath string, vars Variants) {
+// BlockLinksFromSam parses a sam file, constructing a Links object representing
+// simple counts of association between variant blocks. Read pairs are counted only
+// if they pass the filter, which may be nil to count every pair, and which should
+// include a Deduplicator for links to be built from deduplicated pairs. Pairs are
+// tabulated by the specified number of threads, see buildLinksFromSam. A .pairs input
+// must give the sequence of each end, see checkPairsSequences.
+func BlockLinksFromSam(samPath, outPath string, vars Variants, filter *PairFilterChain, threads int) error {
 
-	out, err1 := os.Create(outPath)
-	if err1 != nil {
-		fmt.Printf("Couldn't open output file (%s) for reading: %s\n", outPath, err1)
+	if err := checkPairsSequences(samPath); err != nil {
+		return err
 	}
-	defer out.Close()
 
-	in, err2 := os.Open(samPath)
-	if err2 != nil {
-		fmt.Printf("Couldn't open input file (%s) for reading: %s\n", samPath, err2)
+	out, err1 := CreateOutput(outPath)
+	if err1 != nil {
+		return fmt.Errorf("Couldn't open output file (%s) for writing: %s", outPath, err1)
 	}
-	defer in.Close()
-
-	links := NewLinks()
-	currentID := ""
-	hits := []Alignment{}
-	s := bufio.NewScanner(in)
-	linkedVariants := 0
-	lineCount := 0
-	balance := 0
-	//maxlines := 1000000
-
-	for s.Scan() {
-		line := s.Text()
-		lineCount += 1
-		/*if lineCount >  maxlines {
-			break
-		}*/
-		if string(line[0]) != "@" {
 
-			a, e := parseSAMLine(s.Text())
-			if e != nil {
-				//fmt.Printf("error parsing sam line, continuing: %s, %s\n", e, line)
-				hits = []Alignment{}
-				currentID = ""
-				continue
-			}
+	links, err2 := buildLinksFromSam(samPath, filter, threads, func(shard *Links, a1, a2 Alignment) {
 
-			if a.qname != currentID {
-				if (len(hits) == 2) && (len(currentID) > 0) {
-					if hits[0].rname == hits[1].rname { // same chromosome
+		if a1.rname != a2.rname { // requires both on same chromosome
+			return
+		}
 
-						gp1, egp1 := GenomePositions(hits[0])
-						gp2, egp2 := GenomePositions(hits[1])
+		gp1, egp1 := GenomePositions(a1)
+		gp2, egp2 := GenomePositions(a2)
+		if (egp1 != nil) || (egp2 != nil) {
+			return
+		}
 
-						//fmt.Println(gp1, gp2)
+		// Given the above genome positions, first map the variants at those positions to
+		// known variant blocks.
 
-						if (egp1 != nil) || (egp2 != nil) {
-							//fmt.Println("continuing")
-							//fmt.Println(gp1)
-							//fmt.Println(gp2)
-							hits = []Alignment{}
-							currentID = ""
-							continue
-						}
+		// The trick to parsing block links instead of variant links is passing a varpos
+		// object into the subsequent step that indicates whether each block that was inter-
+		// sected was so at a reference or alternate position.
+		varPos1 := GetVariantBlocks(a1.rname, gp1, &vars)
+		varPos2 := GetVariantBlocks(a2.rname, gp2, &vars)
+		shard.TabulateVariantBlockLinks(a1.rname, varPos1, varPos2)
 
-						// Given the above genome positions, first map the variants at those positions to
-						// known variant blocks.
-
-						// The trick to parsing block links instead of variant links is passing a varpos
-						// object into the subsequent step that indicates whether each block that was inter-
-						// sected was so at a reference or alternate position.
-						varPos1 := GetVariantBlocks(hits[0].rname, gp1, &vars)
-						varPos2 := GetVariantBlocks(hits[1].rname, gp2, &vars)
-
-						// requires both on same chromosome, otherwise bug in following
-						ct1, bal1 := links.TabulateVariantBlockLinks(hits[0].rname, varPos1, varPos2)
-						//fmt.Println(ct1)
-						ct := ct1
-						bal := bal1
-						if ct > 0 {
-							//fmt.Println(varPos1)
-							//fmt.Println(varPos2)
-							//fmt.Println(hits)
-							linkedVariants += ct
-							balance += bal
-							//fmt.Printf("linked a total of %d variants in %d lines with balance %d\n", linkedVariants, lineCount, balance)
-						}
-						//fmt.Println(varPos1)
-						//fmt.Println(varPos2)
-					}
-				}
-				currentID = a.qname
-				hits = []Alignment{}
-			}
-
-			hits = append(hits, a)
-
-		}
+	})
+	if err2 != nil {
+		out.Close()
+		return err2
 	}
 
-	links.Write(out)
+	fmt.Print(filter.Summary())
+	if err := writeLinks(&links, out, outPath); err != nil {
+		out.Close()
+		return fmt.Errorf("Couldn't write links to %s: %s", outPath, err)
+	}
+	return out.Close()
 
 }
 
@@ -620,118 +813,78 @@ func BlockLinksFromSam(samPath, outPath string, vars Variants) {
 }*/
 
 // ScaffoldLinksFromSam parses a sam file, constructing a Links object
-// representing simple counts of association between contigs.
-func ScaffoldLinksFromSam(samPath, outPath string) {
-
-	// assumes sam is sorted by id
-	// for now, building links map in memory but could
-	// also write all to disk then sort | uniq -c
-
-	out, err1 := os.Create(outPath)
-	if err1 != nil {
-		fmt.Printf("Couldn't open output file (%s) for reading: %s\n", outPath, err1)
-	}
-	defer out.Close()
+// representing simple counts of association between contigs, see LinksFromSam, and
+// writes it to the output path, see WriteLinksFile.
+func ScaffoldLinksFromSam(samPath, outPath string, refs *RefDict, filter *PairFilterChain, threads int) error {
 
-	in, err2 := os.Open(samPath)
-	if err2 != nil {
-		fmt.Printf("Couldn't open input file (%s) for reading: %s\n", samPath, err2)
+	links, err := LinksFromSam(samPath, refs, filter, threads)
+	if err != nil {
+		return err
 	}
-	defer in.Close()
-
-	links := NewLinks()
-	currentID := ""
-	chrHits := []string{}
-	//posHits := []int{}
-	s := bufio.NewScanner(in)
-
-	for s.Scan() {
-		line := s.Text()
-		if string(line[0]) != "@" {
-
-			arr := strings.Split(s.Text(), "\t")
-
-			if arr[0] != currentID {
-
-				if len(chrHits) == 2 {
-					id1 := links.ID(chrHits[0])
-					id2 := links.ID(chrHits[1])
-					links.Add(id1, id2, 1)
-				}
+	return WriteLinksFile(&links, outPath)
 
-				currentID = arr[0]
-				chrHits = []string{}
-				//posHits = []string{}
+}
 
-			}
+// LinksFromSam parses a sam file, returning a Links object representing simple counts
+// of association between contigs. Read pairs are counted only if they pass the filter,
+// which may be nil to count every pair, and which should include a Deduplicator for
+// links to be built from deduplicated pairs. Pairs are tabulated by the specified number
+// of threads, see buildLinksFromSam.
+//
+// The links record the sequence dictionary of the reference, giving the length of each
+// contig, see Links.SetReference. This is refs if it is not nil, which must then agree
+// with the @SQ lines of the input, and otherwise that of the @SQ lines.
+func LinksFromSam(samPath string, refs *RefDict, filter *PairFilterChain, threads int) (Links, error) {
 
-			chrHits = append(chrHits, arr[2])
-			//posHits = append(posHits, arr[3])
+	// input not grouped by read name is sorted by qname first, see openGroupedAlignments
+	// for now, building links map in memory but could
+	// also write all to disk then sort | uniq -c
 
-		}
+	in, err := openGroupedAlignments(samPath)
+	if err != nil {
+		return Links{}, err
 	}
+	defer in.Close()
 
-	links.Write(out)
+	return LinksFromAlignments(in, refs, filter, threads)
 
 }
 
-// PartitionAlignmentsByContig takes a path of an alignments file and partitions
-// it into separate files with the alignments for each chromosome each in a separate
-// file. The output is written to the output directory with the specified output stem
-// with the chromosome name included, e.g. /path/to/file/outstem.chrNN.[sam/bam]
-func PartitionAlignmentsByContig(inputPath, outstem string) error {
-
-	// Set a limit on the number of allowed open files
-	maxOpenFiles := 200
-
-	// Initialize a map to store the output file handles
-	fhMap := map[string]*os.File{}
-	fhCount := 0
+// ScaffoldLinksFromAlignments is ScaffoldLinksFromSam for the alignments of a reader
+// whose records are grouped by read name, such as those streamed from an aligner, see
+// AlignHiC. The reader is not closed.
+func ScaffoldLinksFromAlignments(in AlignmentReader, outPath string, refs *RefDict, filter *PairFilterChain, threads int) error {
 
-	// Open the input file for reading
-	infh, err := os.Open(inputPath)
+	links, err := LinksFromAlignments(in, refs, filter, threads)
 	if err != nil {
-		return fmt.Errorf("error: PartitionAlignmentsByContig(%s, %s) failed at attempt to open input file", inputPath, outstem)
+		return err
 	}
-	defer infh.Close()
-	s := bufio.NewScanner(infh)
+	return WriteLinksFile(&links, outPath)
 
-	for s.Scan() {
-
-		// Get the line
-		line := s.Text()
-		if string(line[0]) != "#" {
-
-			alignment, e := parseSAMLine(line)
-			if e != nil {
-				return e
-			}
-
-			// If the observed chromosome name is not in the name/file handle map,
-			// and we have not reached the limit of open files, create a new file handle
-			// and add it to the map.
-			if _, ok := fhMap[alignment.rname]; !ok {
-				if fhCount >= maxOpenFiles {
-					return fmt.Errorf("Too many open files for PartitionAlignmentsByContig to proceed. Either increase the limit or try a different approach.")
-				}
-				outpath := filepath.Join(outstem, alignment.rname, ".vcf")
-				f, err := os.Create(outpath)
-				if err != nil {
-					return err
-				}
-				defer f.Close()
-				fhMap[alignment.rname] = f
-				fhCount += 1
-			}
+}
 
-			fh := fhMap[alignment.rname]
-			fh.WriteString(alignment.String() + "\n")
+// LinksFromAlignments is LinksFromSam for the alignments of a reader whose records are
+// grouped by read name, see ScaffoldLinksFromAlignments.
+func LinksFromAlignments(in AlignmentReader, refs *RefDict, filter *PairFilterChain, threads int) (Links, error) {
 
-		}
+	header := RefDictFromHeader(in.Header())
+	if refs == nil {
+		refs = header
+	} else if err := refs.Check(header); err != nil {
+		return Links{}, fmt.Errorf("The alignments are not to the reference: %s", err)
+	}
 
+	links, err := buildLinks(in, filter, threads, func(shard *Links, a1, a2 Alignment) {
+		shard.Add(shard.ID(a1.rname), shard.ID(a2.rname), 1)
+	})
+	if err != nil {
+		return Links{}, err
+	}
+	if refs.Size() > 0 {
+		links.SetReference(refs)
 	}
 
-	// Return nil, signaling an error free run
-	return nil
+	fmt.Print(filter.Summary())
+	return links, nil
 
 }
@@ -72,6 +72,13 @@ func TestParseSAMLine(t *testing.T) {
 		"*", 0, 0,
 		"CAAACGTGTGCACATCCNNGAGAGCCGTGAGCAACTTGCTCAGCANACNNCTCANCTTCCANGNCNTTCNCAAGCCCAGAG",
 		"<<<???@?@?@@@???<%%33=>???@??????????????????%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%",
+		SAMTags{
+			SAMTag{"NM", 'i', 0, 10},
+			SAMTag{"MD", 'Z', 0, "17T0T26C2A0G4C6G1C1A3A11"},
+			SAMTag{"AS", 'i', 0, 61},
+			SAMTag{"XS", 'i', 0, 0},
+			SAMTag{"SA", 'Z', 0, "10,52681560,+,100M100S,60,1;"},
+		},
 	}
 
 	l2 := "SRR927086.6	0	11	95204105	0	99S101M	*	0	0	CAGGACATANGCGNNNGCAAGGACTTCATGTCCAAAACACCAAAAGCAATGGCAACAAAAGCCAAAATTGACAAATGAGATCTAATTAAACTAAAGAGCTTCTATATCTCTGTTTTGGTACCAGTACCATGCTGTTTTGGTTACTGTAGCCTTGTAGTATAGTTTGAAGTCAGGTAGTGTGATGCCTCCAGCTTTGTTCN	%%%%%%%%%%%%%%%%EEEHCHHHHDC@CDIIGGHHFGDGEGFEGGIIIH@IIHGIIIIIGIIIIHBCIIHIIHF>FIHHBHGBGIIFFFBD?BDDD?@@B@>B@B?B>@B@BBCCCCABDDDDB;FECFFIIIIFCGF@FBBGIFDBIIIIGEIGBDDFFEFGEGEG9IFFF;EFF<CCA:F?FCBDDFFDDDDDB:1NM:i:1	MD:Z:100T0	AS:i:100	XS:i:100	SA:Z:8,98312769,-,103M97S,0,4;"
@@ -81,6 +88,12 @@ func TestParseSAMLine(t *testing.T) {
 		"*", 0, 0,
 		"CAGGACATANGCGNNNGCAAGGACTTCATGTCCAAAACACCAAAAGCAATGGCAACAAAAGCCAAAATTGACAAATGAGATCTAATTAAACTAAAGAGCTTCTATATCTCTGTTTTGGTACCAGTACCATGCTGTTTTGGTTACTGTAGCCTTGTAGTATAGTTTGAAGTCAGGTAGTGTGATGCCTCCAGCTTTGTTCN",
 		"%%%%%%%%%%%%%%%%EEEHCHHHHDC@CDIIGGHHFGDGEGFEGGIIIH@IIHGIIIIIGIIIIHBCIIHIIHF>FIHHBHGBGIIFFFBD?BDDD?@@B@>B@B?B>@B@BBCCCCABDDDDB;FECFFIIIIFCGF@FBBGIFDBIIIIGEIGBDDFFEFGEGEG9IFFF;EFF<CCA:F?FCBDDFFDDDDDB:1NM:i:1",
+		SAMTags{
+			SAMTag{"MD", 'Z', 0, "100T0"},
+			SAMTag{"AS", 'i', 0, 100},
+			SAMTag{"XS", 'i', 0, 100},
+			SAMTag{"SA", 'Z', 0, "8,98312769,-,103M97S,0,4;"},
+		},
 	}
 
 	check := map[string]Alignment{
@@ -100,6 +113,49 @@ func TestParseSAMLine(t *testing.T) {
 
 }
 
+func TestSAMTags(t *testing.T) {
+
+	line := "read\t0\tchr1\t100\t60\t3M\t*\t0\t0\tATG\tIII\tNM:i:-1\tXF:f:1.5\tRG:A:x\tBX:Z:ACGT-1\tXH:H:1AE3\tXB:B:c,-1,2,3\tXC:B:f,0.5,1"
+	a, e := parseSAMLine(line)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if v, ok := a.IntTag("NM"); !ok || v != -1 {
+		t.Errorf("IntTag(NM) returned %d, %t", v, ok)
+	}
+	if v, ok := a.FloatTag("XF"); !ok || v != 1.5 {
+		t.Errorf("FloatTag(XF) returned %f, %t", v, ok)
+	}
+	if v, ok := a.StringTag("RG"); !ok || v != "x" {
+		t.Errorf("StringTag(RG) returned %s, %t", v, ok)
+	}
+	if v, ok := a.StringTag("BX"); !ok || v != "ACGT-1" {
+		t.Errorf("StringTag(BX) returned %s, %t", v, ok)
+	}
+	if v, ok := a.IntArrayTag("XB"); !ok || !reflect.DeepEqual(v, []int{-1, 2, 3}) {
+		t.Errorf("IntArrayTag(XB) returned %v, %t", v, ok)
+	}
+	if v, ok := a.FloatArrayTag("XC"); !ok || !reflect.DeepEqual(v, []float64{0.5, 1}) {
+		t.Errorf("FloatArrayTag(XC) returned %v, %t", v, ok)
+	}
+	if _, ok := a.IntTag("BX"); ok {
+		t.Errorf("IntTag(BX) returned a value for a string tag")
+	}
+	if _, ok := a.Tag("SA"); ok {
+		t.Errorf("Tag(SA) returned a value for an absent tag")
+	}
+
+	if a.String() != line {
+		t.Errorf("Alignment.String() did not round trip optional fields:\n%s\n%s", a.String(), line)
+	}
+
+	if _, e := parseSAMLine("read\t0\tchr1\t100\t60\t3M\t*\t0\t0\tATG\tIII\tNM:i:x"); e == nil {
+		t.Errorf("parseSAMLine did not return an error for a malformed optional field")
+	}
+
+}
+
 func TestGenomePositions(t *testing.T) {
 
 	a1 := Alignment{"SRR927086.7", SAMFlag{}, "12", 20766468, 60,
@@ -107,6 +163,7 @@ func TestGenomePositions(t *testing.T) {
 		"*", 0, 0,
 		"CAAA",
 		"<<<???@?@?@@@???<%%33=>???@??????????????????%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%",
+		nil,
 	}
 
 	a2 := Alignment{
@@ -115,6 +172,7 @@ func TestGenomePositions(t *testing.T) {
 		"*", 0, 0,
 		"CAGG",
 		"%%%%%%%%%%%%%%%%EEEHCHHHHDC@CDIIGGHHFGDGEGFEGGIIIH@IIHGIIIIIGIIIIHBCIIHIIHF>FIHHBHGBGIIFFFBD?BDDD?@@B@>B@B?B>@B@BBCCCCABDDDDB;FECFFIIIIFCGF@FBBGIFDBIIIIGEIGBDDFFEFGEGEG9IFFF;EFF<CCA:F?FCBDDFFDDDDDB:1NM:i:1",
+		nil,
 	}
 	a := []Alignment{a1, a2}
 
@@ -125,10 +183,12 @@ func TestGenomePositions(t *testing.T) {
 		20766471: "A",
 	}
 
+	// POS is the position of the first aligned base, so leading soft clipped
+	// bases do not shift the reference positions.
 	gp2 := map[int]string{
-		95204106: "A",
+		95204105: "A",
+		95204106: "G",
 		95204107: "G",
-		95204108: "G",
 	}
 	gp := []map[int]string{gp1, gp2}
 
@@ -141,6 +201,68 @@ func TestGenomePositions(t *testing.T) {
 
 }
 
+func TestGenomePositionsIndels(t *testing.T) {
+
+	cases := []struct {
+		cigar CIGAR
+		seq   string
+		gp    map[int]string
+	}{
+		// An insertion is attributed to the preceding reference base
+		{CIGAR{CIGARCode{2, "M"}, CIGARCode{2, "I"}, CIGARCode{2, "M"}}, "ACGTAC",
+			map[int]string{100: "A", 101: "CGT", 102: "A", 103: "C"}},
+		// Deleted reference positions are present with an empty allele
+		{CIGAR{CIGARCode{1, "S"}, CIGARCode{2, "="}, CIGARCode{2, "D"}, CIGARCode{1, "X"}}, "TACG",
+			map[int]string{100: "A", 101: "C", 102: "", 103: "", 104: "G"}},
+		// Skipped regions and padding contribute no positions
+		{CIGAR{CIGARCode{5, "H"}, CIGARCode{1, "M"}, CIGARCode{10, "N"}, CIGARCode{1, "P"}, CIGARCode{2, "M"}, CIGARCode{5, "H"}}, "ACG",
+			map[int]string{100: "A", 111: "C", 112: "G"}},
+	}
+
+	for _, c := range cases {
+		a := Alignment{"read", SAMFlag{}, "1", 100, 60, c.cigar, "*", 0, 0, c.seq, "*", nil}
+		gp, e := GenomePositions(a)
+		if e != nil {
+			t.Error(e)
+		}
+		if !reflect.DeepEqual(gp, c.gp) {
+			t.Errorf("GenomePositions for CIGAR %s yielded %v, expected %v", c.cigar.String(), gp, c.gp)
+		}
+	}
+
+	// A CIGAR consuming more bases than the read has is an error rather than a panic
+	a := Alignment{"read", SAMFlag{}, "1", 100, 60, CIGAR{CIGARCode{10, "M"}}, "*", 0, 0, "*", "*", nil}
+	if _, e := GenomePositions(a); e == nil {
+		t.Errorf("GenomePositions did not return an error for a CIGAR longer than the read sequence")
+	}
+
+}
+
+func TestGetVariantsIndels(t *testing.T) {
+
+	vars := NewVariants()
+	vars.add(variant{"1", "101", "del1", "CTT", "C", "0", "PASS", nil, nil})
+	vars.add(variant{"1", "201", "ins1", "G", "GAA", "0", "PASS", nil, nil})
+
+	// Reads carrying the deletion and insertion respectively
+	gpAlt := map[int]string{100: "A", 101: "C", 102: "", 103: "", 104: "G", 201: "GAA", 202: "T"}
+	// Reads matching the reference
+	gpRef := map[int]string{100: "A", 101: "C", 102: "T", 103: "T", 104: "G", 201: "G", 202: "T"}
+	// A read ending within the deleted sequence cannot be called
+	gpPartial := map[int]string{100: "A", 101: "C", 102: "T"}
+
+	if v := GetVariants("1", gpAlt, &vars); !reflect.DeepEqual(v, map[int]string{101: "A", 201: "A"}) {
+		t.Errorf("GetVariants failed to call indel alternate alleles, yielding %v", v)
+	}
+	if v := GetVariants("1", gpRef, &vars); !reflect.DeepEqual(v, map[int]string{101: "R", 201: "R"}) {
+		t.Errorf("GetVariants failed to call indel reference alleles, yielding %v", v)
+	}
+	if v := GetVariants("1", gpPartial, &vars); len(v) != 0 {
+		t.Errorf("GetVariants called an indel not spanned by the read, yielding %v", v)
+	}
+
+}
+
 func TestGetVariants(t *testing.T) {
 
 	header := map[string][]string{
@@ -286,7 +408,7 @@ func TestBlockLinksFromSam(t *testing.T) {
 	outPath := filepath.Join(cwd(t), "_testdata", "output.var.links")
 	vars, _ := ReadVariants(filepath.Join(cwd(t), "_testdata", "toy.vcf"))
 
-	BlockLinksFromSam(samPath, outPath, vars)
+	BlockLinksFromSam(samPath, outPath, vars, nil, 1)
 
 	linksTest, _ := LoadLinks(outPath)
 	linksKey, _ := LoadLinks(filepath.Join(cwd(t), "_testdata", "toy.varblock.links"))
@@ -305,7 +427,7 @@ func TestVariantLinksFromSAM(t *testing.T) {
 	outPath := filepath.Join(cwd(t), "_testdata", "output.var.links")
 	vars, _ := ReadVariants(filepath.Join(cwd(t), "_testdata", "toy.var.links"))
 
-	VariantLinksFromSam(samPath, outPath, vars)
+	VariantLinksFromSam(samPath, outPath, vars, nil, 1)
 
 	linksTest, _ := LoadLinks(outPath)
 	linksKey, _ := LoadLinks(filepath.Join(cwd(t), "_testdata", "toy.var.links"))
@@ -321,7 +443,9 @@ func TestScaffoldLinksFromSam(t *testing.T) {
 	samPath := filepath.Join(cwd(t), "_testdata", "toy.sam")
 	outPath := filepath.Join(cwd(t), "_testdata", "output.ctg.links")
 
-	ScaffoldLinksFromSam(samPath, outPath)
+	if err := ScaffoldLinksFromSam(samPath, outPath, nil, nil, 1); err != nil {
+		t.Fatal(err)
+	}
 
 	linksTest, _ := LoadLinks(outPath)
 	linksKey, _ := LoadLinks(filepath.Join(cwd(t), "_testdata", "toy.ctg.links"))
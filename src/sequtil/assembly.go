@@ -3,6 +3,7 @@ package util
 import (
 	//"github.com/codegangsta/cli"
 	"fmt"
+    "io"
     "os"
     "bufio"
     "strings"
@@ -27,10 +28,11 @@ func Partition(inPath, outPath string, windowSize int) {
     }
     defer out.Close()
 
-    // Open the input file
-    in, err := os.Open(inPath)
+    // Open the input file, decompressing it if it is gzipped
+    in, err := OpenInput(inPath)
     if err != nil {
-        fmt.Errorf("Couldn't open input file with path %s\n", inPath)
+        fmt.Println(err)
+        return
     }
     defer in.Close()
 
@@ -66,19 +68,74 @@ func Partition(inPath, outPath string, windowSize int) {
     }
 }
 
+// Mask writes a copy of a FASTA file with the bases at the positions of a set of
+// variants replaced by N. Uncompressed FASTA files are copied and the variant positions
+// then overwritten in place, located using the FASTA index, see OpenFasta, while
+// compressed files are masked line by line.
 func Mask(inPath, outPath string, vcf Variants) {
 
+    fr, err := OpenFasta(inPath)
+    if err != nil {
+        maskLines(inPath, outPath, vcf)
+        return
+    }
+    defer fr.Close()
+
+    // Copy the input to the output, which is then masked in place
+    in, err1 := os.Open(inPath)
+    if err1 != nil {
+        fmt.Println(err1)
+        return
+    }
+    defer in.Close()
+    out, err2 := os.Create(outPath)
+    if err2 != nil {
+        fmt.Printf("Couldn't open output file with path %s\n", outPath)
+        return
+    }
+    defer out.Close()
+    if _, err := io.Copy(out, in); err != nil {
+        fmt.Println(err)
+        return
+    }
+
+    // Seek to each variant position, those of the VCF being 1-based
+    for _, rec := range fr.Index() {
+        positions, ok := vcf.data[rec.Name]
+        if !ok {
+            continue
+        }
+        fmt.Printf("masking contig... %s\n", rec.Name)
+        for pos, _ := range positions {
+            if pos < 1 || pos > rec.Length {
+                continue
+            }
+            if _, err := out.WriteAt([]byte("N"), rec.offset(pos-1)); err != nil {
+                fmt.Println(err)
+                return
+            }
+        }
+    }
+
+}
+
+// maskLines masks a FASTA file which cannot be indexed, such as a compressed one, line by
+// line, see Mask.
+func maskLines(inPath, outPath string, vcf Variants) {
+
     // Open the ouput file for writing
     out, err1 := os.Create(outPath)
     if err1 != nil {
-        fmt.Errorf("Couldn't open output file with path %s\n", outPath)
+        fmt.Printf("Couldn't open output file with path %s\n", outPath)
+        return
     }
     defer out.Close()
 
-    // Open the input file
-    in, err2 := os.Open(inPath)
+    // Open the input file, decompressing it if it is gzipped
+    in, err2 := OpenInput(inPath)
     if err2 != nil {
-        fmt.Errorf("Couldn't open input file with path %s\n", inPath)
+        fmt.Println(err2)
+        return
     }
     defer in.Close()
    
@@ -88,16 +145,17 @@ func Mask(inPath, outPath string, vcf Variants) {
     toggle := true
     for s.Scan() {
         line := s.Text()
-        if string(line[0]) == ">" {
-            fmt.Printf("masking contig... %s\n", line[1:])
-            out.WriteString(line + "\n")
+        if len(line) > 0 && string(line[0]) == ">" {
             currentID = strings.Split(line[1:], " ")[0]
+            fmt.Printf("masking contig... %s\n", currentID)
+            out.WriteString(line + "\n")
             _, toggle = vcf.data[currentID]
             seqOffset = 0
         } else {
             if toggle {
                 for i, _ := range line {
-                    if _, ok := vcf.data[currentID][seqOffset + i]; ok {
+                    // Variant positions are 1-based
+                    if _, ok := vcf.data[currentID][seqOffset + i + 1]; ok {
                         line = line[:i] + "N" + line[i+1:]
                     }
                 }                
@@ -1,6 +1,8 @@
 package util
 
 import (
+	"io/ioutil"
+	"strings"
 	"testing"
 )
 
@@ -10,5 +12,34 @@ func TestPartition(t *testing.T) {
 
 func TestMask(t *testing.T) {
 
+	vcf := NewVariants()
+	vcf.data["chr1"] = map[int]variant{1: variant{}, 11: variant{}, 23: variant{}, 24: variant{}}
+	vcf.data["chr3"] = map[int]variant{6: variant{}}
+	expected := ">chr1 first contig\nNCGTACGTAC\nNTACGTACGT\nAAN\n>chr2\nGGGGCCCCAA\n\n>chr3\r\nACGTA\r\nNG\r\n"
+
+	// Uncompressed files are masked in place, and compressed ones line by line, which
+	// normalizes line endings.
+	inPath := "/tmp/lxy/test/testmask.fa"
+	writeTestFile(t, inPath, testFasta)
+	gzPath := "/tmp/lxy/test/testmask.fa.gz"
+	out, err := CreateOutput(gzPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Write([]byte(testFasta))
+	out.Close()
+
+	for path, expected := range map[string]string{inPath: expected, gzPath: strings.Replace(expected, "\r", "", -1)} {
+		outPath := "/tmp/lxy/test/testmask.masked.fa"
+		Mask(path, outPath, vcf)
+		b, err := ioutil.ReadFile(outPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != expected {
+			t.Errorf("Expected %s to be masked as %q, got %q", path, expected, string(b))
+		}
+	}
+
 }
 
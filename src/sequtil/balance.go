@@ -0,0 +1,369 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// The methods by which a Links object may be balanced, see Balance.
+const (
+	// Iterative correction (ICE), which repeatedly divides each value by the sums of
+	// the rows of its two entities, relative to their mean
+	BalanceICE = "ice"
+
+	// The Knight-Ruiz algorithm, which finds the balancing vector by Newton's method
+	// with conjugate gradient inner iterations, typically converging in far fewer passes
+	// over the links than ICE
+	BalanceKR = "kr"
+)
+
+// BalanceMethods are the methods accepted by Balance.
+var BalanceMethods = []string{BalanceICE, BalanceKR}
+
+// BalanceOptions configures the balancing of links, see Balance.
+type BalanceOptions struct {
+
+	// The balancing method, BalanceICE or BalanceKR
+	Method string
+
+	// The maximum number of iterations, after which balancing fails if it has not
+	// converged, 200 if zero
+	MaxIterations int
+
+	// The tolerance of the relative deviation of the row sums from their target at
+	// which balancing has converged, 1e-5 if zero
+	Tolerance float64
+}
+
+// linksMatrix is the symmetric matrix of the values of a set of links between distinct
+// entities, as the entries (i, j, value) of one triangle, for those entities with
+// non-zero row sums, which i and j index. The diagonal, the value of each such entity
+// with itself, is held separately.
+type linksMatrix struct {
+	ids   []int
+	i, j  []int
+	vals  []float64
+	diag  map[int]float64
+	index map[int]int
+}
+
+// newLinksMatrix constructs the matrix of a set of links, returning an error if any
+// value is negative, as balancing requires.
+func newLinksMatrix(l *Links) (*linksMatrix, error) {
+
+	m := &linksMatrix{diag: map[int]float64{}, index: map[int]int{}}
+	sums := map[int]float64{}
+	for id1, sub := range l.data {
+		for id2, v := range sub {
+			if v < 0 {
+				return nil, fmt.Errorf("Couldn't balance links with negative values, e.g. %s %s %f", l.idKeyRev[id1], l.idKeyRev[id2], v)
+			}
+			if _, ok := l.idKeyRev[id1]; !ok {
+				continue
+			}
+			if _, ok := l.idKeyRev[id2]; !ok || id1 == id2 {
+				continue
+			}
+			sums[id1] += v
+			sums[id2] += v
+		}
+	}
+
+	for _, id := range l.sortedIDs() {
+		if sums[id] > 0 {
+			m.index[id] = len(m.ids)
+			m.ids = append(m.ids, id)
+		}
+	}
+	for id1, sub := range l.data {
+		for id2, v := range sub {
+			i, ok1 := m.index[id1]
+			j, ok2 := m.index[id2]
+			if ok1 && ok2 && i == j {
+				m.diag[i] = v
+			} else if ok1 && ok2 && v > 0 {
+				m.i, m.j, m.vals = append(m.i, i), append(m.j, j), append(m.vals, v)
+			}
+		}
+	}
+
+	return m, nil
+
+}
+
+// mulVec returns the product of the matrix scaled by x on both sides, diag(x) A diag(x),
+// with the vector y, which for y all ones is the vector of the row sums of the scaled
+// matrix.
+func (m *linksMatrix) mulVec(x, y []float64) []float64 {
+	out := make([]float64, len(m.ids))
+	for k, v := range m.vals {
+		i, j := m.i[k], m.j[k]
+		w := x[i] * v * x[j]
+		out[i] += w * y[j]
+		out[j] += w * y[i]
+	}
+	return out
+}
+
+// mean returns the mean of a vector.
+func mean(v []float64) float64 {
+	total := 0.0
+	for _, x := range v {
+		total += x
+	}
+	return total / float64(len(v))
+}
+
+// dot returns the dot product of two vectors.
+func dot(u, v []float64) float64 {
+	total := 0.0
+	for i := range u {
+		total += u[i] * v[i]
+	}
+	return total
+}
+
+// balanceICE finds the scaling vector x which balances the matrix by iterative
+// correction, such that the rows of diag(x) A diag(x) all sum to one.
+func (m *linksMatrix) balanceICE(maxIter int, tol float64) ([]float64, error) {
+
+	n := len(m.ids)
+	x, ones := make([]float64, n), make([]float64, n)
+	for i := range x {
+		x[i], ones[i] = 1, 1
+	}
+
+	for iter := 0; iter < maxIter; iter++ {
+		sums := m.mulVec(x, ones)
+		scale := mean(sums)
+		converged := true
+		for i, s := range sums {
+			s /= scale
+			if math.Abs(s-1) > tol {
+				converged = false
+			}
+			x[i] /= s
+		}
+		if converged {
+			// Scale the rows to sum to one rather than to their common sum.
+			for i := range x {
+				x[i] /= math.Sqrt(scale)
+			}
+			return x, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Balancing with ICE did not converge in %d iterations", maxIter)
+
+}
+
+// balanceKR finds the scaling vector x which balances the matrix by the algorithm of
+// Knight and Ruiz (2013, IMA J. Numer. Anal. 33:1029), such that the rows of
+// diag(x) A diag(x) all sum to one. The matrix must have total support, which the
+// matrices of sparse links may not, in which case it fails to converge.
+func (m *linksMatrix) balanceKR(maxIter int, tol float64) ([]float64, error) {
+
+	n := len(m.ids)
+	x, ones := make([]float64, n), make([]float64, n)
+	for i := range x {
+		x[i], ones[i] = 1, 1
+	}
+
+	// The bounds on the step of each inner iteration, and the parameters of the inner
+	// tolerance, as recommended by Knight and Ruiz.
+	const delta, upper, g, etaMax = 0.1, 3.0, 0.9, 0.1
+	eta, stopTol := etaMax, tol*0.5
+	rt := tol * tol
+
+	v := m.mulVec(x, ones)
+	rk := make([]float64, n)
+	for i := range rk {
+		rk[i] = 1 - v[i]
+	}
+	rhoKm1 := dot(rk, rk)
+	rout, rold := rhoKm1, rhoKm1
+
+	for iter := 0; rout > rt; iter++ {
+		if iter >= maxIter {
+			return nil, fmt.Errorf("Balancing with KR did not converge in %d iterations", maxIter)
+		}
+
+		// Solve for the step by conjugate gradient iterations, within the bounds.
+		y := append([]float64{}, ones...)
+		z, p := make([]float64, n), make([]float64, n)
+		innerTol := math.Max(eta*eta*rout, rt)
+		rhoKm2 := 0.0
+		for k := 0; rhoKm1 > innerTol; k++ {
+			if k == 0 {
+				for i := range z {
+					z[i] = rk[i] / v[i]
+				}
+				copy(p, z)
+				rhoKm1 = dot(rk, z)
+			} else {
+				beta := rhoKm1 / rhoKm2
+				for i := range p {
+					p[i] = z[i] + beta*p[i]
+				}
+			}
+
+			xp := make([]float64, n)
+			for i := range xp {
+				xp[i] = x[i] * p[i]
+			}
+			w := m.mulVec(ones, xp)
+			for i := range w {
+				w[i] = x[i]*w[i] + v[i]*p[i]
+			}
+			alpha := rhoKm1 / dot(p, w)
+
+			ynew := make([]float64, n)
+			minY, maxY := math.Inf(1), math.Inf(-1)
+			for i := range ynew {
+				ynew[i] = y[i] + alpha*p[i]
+				minY, maxY = math.Min(minY, ynew[i]), math.Max(maxY, ynew[i])
+			}
+			if minY <= delta || maxY >= upper {
+				// Step only as far as the bounds allow.
+				gamma := math.Inf(1)
+				for i := range y {
+					ap := alpha * p[i]
+					if minY <= delta && ap < 0 {
+						gamma = math.Min(gamma, (delta-y[i])/ap)
+					} else if minY > delta && ynew[i] > upper {
+						gamma = math.Min(gamma, (upper-y[i])/ap)
+					}
+				}
+				for i := range y {
+					y[i] += gamma * alpha * p[i]
+				}
+				break
+			}
+			y = ynew
+
+			for i := range rk {
+				rk[i] -= alpha * w[i]
+			}
+			rhoKm2 = rhoKm1
+			for i := range z {
+				z[i] = rk[i] / v[i]
+			}
+			rhoKm1 = dot(rk, z)
+		}
+
+		for i := range x {
+			x[i] *= y[i]
+		}
+		v = m.mulVec(x, ones)
+		for i := range v {
+			rk[i] = 1 - v[i]
+		}
+		rhoKm1 = dot(rk, rk)
+		rout = rhoKm1
+		rat := rout / rold
+		rold = rout
+		etaO := eta
+		eta = g * rat
+		if g*etaO*etaO > 0.1 {
+			eta = math.Max(eta, g*etaO*etaO)
+		}
+		eta = math.Max(math.Min(eta, etaMax), stopTol/math.Sqrt(rout))
+	}
+
+	return x, nil
+
+}
+
+// Balance balances the matrix of the association values of a set of links, such as
+// Hi-C contact counts between contigs, removing the biases of each entity, e.g. of
+// contig length, mappability and restriction site density, which otherwise dominate
+// them. It returns the balanced links, with the same entities and ids, along with the
+// bias of each entity, the balanced value of each pair of entities being its raw value
+// divided by the product of their biases.
+//
+// Balancing scales the values such that the values of each entity sum to the same total,
+// here the mean such sum of the raw values, so that balanced values are on the scale
+// of raw ones. The value of an entity with itself, e.g. of the links within a contig,
+// is scaled by its bias but not counted in these sums, as it would otherwise dominate
+// them. Entities with no links to others are left out, with a bias of NaN. Values must
+// not be negative, such that variant links cannot be balanced.
+func (l *Links) Balance(opts BalanceOptions) (Links, map[int]float64, error) {
+
+	if opts.MaxIterations <= 0 {
+		opts.MaxIterations = 200
+	}
+	if opts.Tolerance <= 0 {
+		opts.Tolerance = 1e-5
+	}
+
+	m, err := newLinksMatrix(l)
+	if err != nil {
+		return Links{}, nil, err
+	}
+
+	balanced := l.copyEntities()
+	bias := map[int]float64{}
+	for id := range l.idKeyRev {
+		bias[id] = math.NaN()
+	}
+	if len(m.ids) == 0 {
+		return balanced, bias, nil
+	}
+
+	// Balance the matrix scaled to have rows summing to one on average, from which the
+	// Knight-Ruiz algorithm starts closer to its solution.
+	ones := make([]float64, len(m.ids))
+	for i := range ones {
+		ones[i] = 1
+	}
+	target := mean(m.mulVec(ones, ones))
+	scaled := &linksMatrix{ids: m.ids, i: m.i, j: m.j, vals: make([]float64, len(m.vals))}
+	for k, v := range m.vals {
+		scaled.vals[k] = v / target
+	}
+
+	var x []float64
+	switch strings.ToLower(opts.Method) {
+	case BalanceICE:
+		x, err = scaled.balanceICE(opts.MaxIterations, opts.Tolerance)
+	case BalanceKR:
+		x, err = scaled.balanceKR(opts.MaxIterations, opts.Tolerance)
+	default:
+		err = fmt.Errorf("Unknown balancing method %s, expected one of %s", opts.Method, strings.Join(BalanceMethods, ", "))
+	}
+	if err != nil {
+		return Links{}, nil, err
+	}
+
+	for k, v := range m.vals {
+		i, j := m.i[k], m.j[k]
+		balanced.Set(m.ids[i], m.ids[j], x[i]*v*x[j])
+	}
+	for i, v := range m.diag {
+		balanced.Set(m.ids[i], m.ids[i], x[i]*v*x[i])
+	}
+	for i, id := range m.ids {
+		bias[id] = 1 / x[i]
+	}
+
+	return balanced, bias, nil
+
+}
+
+// WriteBias writes the biases of the entities of a set of links, see Balance, as
+// tab-separated name and bias lines in the order of their ids.
+func (l *Links) WriteBias(out io.Writer, bias map[int]float64) {
+	ids := make([]int, 0, len(bias))
+	for id := range bias {
+		if _, ok := l.idKeyRev[id]; ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		fmt.Fprintf(out, "%s\t%g\n", l.idKeyRev[id], bias[id])
+	}
+}
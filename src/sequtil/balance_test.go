@@ -0,0 +1,92 @@
+package util
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestBalance(t *testing.T) {
+
+	raw := NewLinks()
+	counts := []struct {
+		a, b string
+		v    float64
+	}{
+		{"c1", "c2", 40}, {"c1", "c3", 10}, {"c1", "c4", 5},
+		{"c2", "c3", 20}, {"c2", "c4", 8}, {"c3", "c4", 2},
+		{"c1", "c1", 500}, {"lonely", "lonely", 30},
+	}
+	for _, c := range counts {
+		raw.Set(raw.ID(c.a), raw.ID(c.b), c.v)
+	}
+	rawSums := (40 + 10 + 5 + 40 + 20 + 8 + 10 + 20 + 2 + 5 + 8 + 2) / 4.0
+
+	biases := map[string]map[int]float64{}
+	for _, method := range BalanceMethods {
+		balanced, bias, err := raw.Balance(BalanceOptions{Method: method, Tolerance: 1e-8})
+		if err != nil {
+			t.Errorf("Balance(%s): %s", method, err)
+			continue
+		}
+
+		biases[method] = bias
+
+		// The rows sum to the mean raw row sum, self-links aside.
+		for _, a := range []string{"c1", "c2", "c3", "c4"} {
+			sum := 0.0
+			for _, b := range []string{"c1", "c2", "c3", "c4"} {
+				if v, _ := balanced.Get(balanced.ID(a), balanced.ID(b)); a != b {
+					sum += v
+				}
+			}
+			if math.Abs(sum-rawSums) > 1e-4 {
+				t.Errorf("Balance(%s) yielded a row sum of %f for %s, expected %f", method, sum, a, rawSums)
+			}
+		}
+
+		// The balanced values are the raw values divided by the biases.
+		for _, c := range counts[:7] {
+			id1, id2 := raw.ID(c.a), raw.ID(c.b)
+			v, _ := balanced.Get(id1, id2)
+			if expected := c.v / (bias[id1] * bias[id2]); math.Abs(v-expected) > 1e-9 {
+				t.Errorf("Balance(%s) yielded %f for %s %s, expected %f", method, v, c.a, c.b, expected)
+			}
+		}
+		if !math.IsNaN(bias[raw.ID("lonely")]) || balanced.Size() != raw.Size() {
+			t.Errorf("Balance(%s) didn't leave out the entity with no links to others", method)
+		}
+
+		var out bytes.Buffer
+		balanced.WriteBias(&out, bias)
+		if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 5 || lines[4] != "lonely\tNaN" {
+			t.Errorf("WriteBias wrote unexpected biases:\n%s", out.String())
+		}
+	}
+
+	// The balancing is unique, such that both methods find the same biases.
+	for id, b := range biases[BalanceICE] {
+		if kr := biases[BalanceKR][id]; math.Abs(b-kr) > 1e-6*b {
+			t.Errorf("ICE and KR yielded biases %f and %f for %s", b, kr, raw.idKeyRev[id])
+		}
+	}
+
+	negative := NewLinks()
+	negative.Set(negative.ID("a"), negative.ID("b"), -1)
+	if _, _, err := negative.Balance(BalanceOptions{Method: BalanceICE}); err == nil {
+		t.Errorf("Expected an error balancing negative links")
+	}
+	if _, _, err := raw.Balance(BalanceOptions{Method: "vc"}); err == nil {
+		t.Errorf("Expected an error for an unknown balancing method")
+	}
+
+	// A chain of three entities can't be balanced.
+	chain := NewLinks()
+	chain.Set(chain.ID("a"), chain.ID("b"), 1)
+	chain.Set(chain.ID("b"), chain.ID("c"), 1)
+	if _, _, err := chain.Balance(BalanceOptions{Method: BalanceICE, MaxIterations: 50}); err == nil {
+		t.Errorf("Expected an error balancing links which can't be balanced")
+	}
+
+}
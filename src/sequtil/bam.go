@@ -0,0 +1,704 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// bamMagic is the four byte magic string found at the start of the decompressed
+// stream of every BAM file.
+var bamMagic = []byte("BAM\x01")
+
+// bgzfEOF is the empty BGZF block that terminates a well formed BGZF file.
+var bgzfEOF = []byte{
+	0x1f, 0x8b, 0x08, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0x06, 0x00, 0x42, 0x43,
+	0x02, 0x00, 0x1b, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+}
+
+// bgzfBlockSize is the maximum amount of uncompressed data stored in a single BGZF
+// block, chosen (as in htslib) so that the compressed block never exceeds 64kb.
+const bgzfBlockSize = 0xff00
+
+// cigarOps and seqNt16 are the BAM encodings of CIGAR operations and 4-bit
+// nucleotide codes respectively, indexed by their integer code.
+const cigarOps = "MIDNSHP=X"
+const seqNt16 = "=ACMGRSVTWYHKDBN"
+
+// bgzfWriter is an io.WriteCloser which compresses its input into a series of
+// BGZF blocks, as used by BAM, and terminates the stream with the BGZF EOF block
+// on Close.
+type bgzfWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+// newBGZFWriter returns a bgzfWriter writing compressed blocks to w.
+func newBGZFWriter(w io.Writer) *bgzfWriter {
+	return &bgzfWriter{w: w, buf: make([]byte, 0, bgzfBlockSize)}
+}
+
+// Write buffers p, flushing a compressed block each time a full block of
+// uncompressed data has accumulated.
+func (bw *bgzfWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		space := bgzfBlockSize - len(bw.buf)
+		if space > len(p) {
+			space = len(p)
+		}
+		bw.buf = append(bw.buf, p[:space]...)
+		p = p[space:]
+		n += space
+		if len(bw.buf) == bgzfBlockSize {
+			if err := bw.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flush compresses the buffered data into a single BGZF block and writes it out.
+func (bw *bgzfWriter) flush() error {
+
+	if len(bw.buf) == 0 {
+		return nil
+	}
+
+	// Deflate the buffered data
+	var cdata bytes.Buffer
+	fw, err := flate.NewWriter(&cdata, flate.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	fw.Write(bw.buf)
+	if err := fw.Close(); err != nil {
+		return err
+	}
+
+	// Assemble the gzip member, including the BC extra subfield which records the
+	// total block size minus one.
+	header := []byte{0x1f, 0x8b, 0x08, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0x06, 0x00, 0x42, 0x43, 0x02, 0x00, 0x00, 0x00}
+	binary.LittleEndian.PutUint16(header[16:], uint16(len(header)+cdata.Len()+8-1))
+	footer := make([]byte, 8)
+	binary.LittleEndian.PutUint32(footer[0:], crc32.ChecksumIEEE(bw.buf))
+	binary.LittleEndian.PutUint32(footer[4:], uint32(len(bw.buf)))
+
+	for _, b := range [][]byte{header, cdata.Bytes(), footer} {
+		if _, err := bw.w.Write(b); err != nil {
+			return err
+		}
+	}
+
+	bw.buf = bw.buf[:0]
+	return nil
+
+}
+
+// Close flushes any buffered data and writes the BGZF EOF marker block.
+func (bw *bgzfWriter) Close() error {
+	if err := bw.flush(); err != nil {
+		return err
+	}
+	_, err := bw.w.Write(bgzfEOF)
+	return err
+}
+
+// bamRefs parses the @SQ lines of a SAM header into the ordered list of reference
+// names and lengths that make up the reference section of a BAM file.
+func bamRefs(header string) ([]string, []int) {
+	names := []string{}
+	lengths := []int{}
+	for _, line := range strings.Split(header, "\n") {
+		if !strings.HasPrefix(line, "@SQ") {
+			continue
+		}
+		name := ""
+		length := 0
+		for _, field := range strings.Split(line, "\t")[1:] {
+			if strings.HasPrefix(field, "SN:") {
+				name = field[3:]
+			} else if strings.HasPrefix(field, "LN:") {
+				length, _ = strconv.Atoi(field[3:])
+			}
+		}
+		names = append(names, name)
+		lengths = append(lengths, length)
+	}
+	return names, lengths
+}
+
+// reg2bin computes the BAI bin for an alignment spanning the 0-based, half-open
+// interval [beg, end), per the SAM specification.
+func reg2bin(beg, end int) int {
+	end -= 1
+	if beg>>14 == end>>14 {
+		return ((1<<15)-1)/7 + (beg >> 14)
+	}
+	if beg>>17 == end>>17 {
+		return ((1<<12)-1)/7 + (beg >> 17)
+	}
+	if beg>>20 == end>>20 {
+		return ((1<<9)-1)/7 + (beg >> 20)
+	}
+	if beg>>23 == end>>23 {
+		return ((1<<6)-1)/7 + (beg >> 23)
+	}
+	if beg>>26 == end>>26 {
+		return ((1<<3)-1)/7 + (beg >> 26)
+	}
+	return 0
+}
+
+// bamReader reads Alignment records from a BAM file, see AlignmentReader.
+type bamReader struct {
+	in     *inputFile
+	header string
+	refs   []string
+	cur    Alignment
+	curErr error
+	err    error
+}
+
+// newBAMReader reads the BAM header from a decompressed input file and returns a
+// bamReader positioned at the first alignment record.
+func newBAMReader(in *inputFile) (*bamReader, error) {
+
+	br := &bamReader{in: in}
+	r := in.Reader
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, bamMagic) {
+		return nil, fmt.Errorf("sequtil/bam: input is not a BAM file")
+	}
+
+	// Read the plain text header
+	var lText int32
+	if err := binary.Read(r, binary.LittleEndian, &lText); err != nil {
+		return nil, fmt.Errorf("sequtil/bam: truncated BAM header: %s", err)
+	}
+	text, err := readBAMField(r, int64(lText))
+	if err != nil {
+		return nil, fmt.Errorf("sequtil/bam: truncated BAM header: %s", err)
+	}
+	br.header = string(bytes.TrimRight(text, "\x00"))
+
+	// Read the reference names, which are what refID fields index into
+	var nRef int32
+	if err := binary.Read(r, binary.LittleEndian, &nRef); err != nil {
+		return nil, fmt.Errorf("sequtil/bam: truncated BAM header: %s", err)
+	}
+	if nRef < 0 {
+		return nil, fmt.Errorf("sequtil/bam: corrupt BAM header with %d references", nRef)
+	}
+	// The references are appended as they are read, as their number is unchecked.
+	br.refs = []string{}
+	for i := 0; i < int(nRef); i++ {
+		var lName int32
+		if err := binary.Read(r, binary.LittleEndian, &lName); err != nil {
+			return nil, fmt.Errorf("sequtil/bam: truncated BAM reference list: %s", err)
+		}
+		if lName < 0 {
+			return nil, fmt.Errorf("sequtil/bam: corrupt BAM reference name length %d", lName)
+		}
+		name, err := readBAMField(r, int64(lName)+4)
+		if err != nil {
+			return nil, fmt.Errorf("sequtil/bam: truncated BAM reference list: %s", err)
+		}
+		br.refs = append(br.refs, string(bytes.TrimRight(name[:len(name)-4], "\x00")))
+	}
+
+	return br, nil
+
+}
+
+// readBAMField reads a field of n bytes, whose length was itself read from the BAM file
+// and so may be corrupt. A negative length is an error, and the field is copied as it is
+// read rather than allocated from its length, such that a length beyond the end of the
+// input fails there.
+func readBAMField(r io.Reader, n int64) ([]byte, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("negative length %d", n)
+	}
+	var b bytes.Buffer
+	if _, err := io.CopyN(&b, r, n); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// Scan advances to the next record in the BAM file, returning false at the end of
+// the input or on a read error.
+func (br *bamReader) Scan() bool {
+
+	if br.err != nil {
+		return false
+	}
+
+	var blockSize int32
+	if err := binary.Read(br.in, binary.LittleEndian, &blockSize); err != nil {
+		if err != io.EOF {
+			br.err = fmt.Errorf("sequtil/bam: truncated BAM record: %s", err)
+		}
+		return false
+	}
+
+	block, err := readBAMField(br.in, int64(blockSize))
+	if err != nil {
+		br.err = fmt.Errorf("sequtil/bam: truncated BAM record: %s", err)
+		return false
+	}
+
+	br.cur, br.curErr = br.decode(block)
+	return true
+
+}
+
+// decode converts a single binary BAM record into an Alignment, yielding the same
+// Alignment that parseSAMLine would for the equivalent SAM line.
+func (br *bamReader) decode(b []byte) (Alignment, error) {
+
+	if len(b) < 32 {
+		return Alignment{}, fmt.Errorf("sequtil/bam: BAM record shorter than its fixed length fields")
+	}
+
+	le := binary.LittleEndian
+	refID := int32(le.Uint32(b[0:]))
+	pos := int32(le.Uint32(b[4:]))
+	lReadName := int(b[8])
+	mapq := int(b[9])
+	nCigar := int(le.Uint16(b[12:]))
+	flag := int(le.Uint16(b[14:]))
+	lSeq := int(int32(le.Uint32(b[16:])))
+	nextRefID := int32(le.Uint32(b[20:]))
+	nextPos := int32(le.Uint32(b[24:]))
+	tlen := int(int32(le.Uint32(b[28:])))
+
+	if lSeq < 0 {
+		return Alignment{}, fmt.Errorf("sequtil/bam: BAM record with negative sequence length %d", lSeq)
+	}
+	if len(b) < 32+lReadName+4*nCigar+(lSeq+1)/2+lSeq {
+		return Alignment{}, fmt.Errorf("sequtil/bam: BAM record shorter than its variable length fields")
+	}
+
+	if int(refID) >= len(br.refs) {
+		return Alignment{}, fmt.Errorf("sequtil/bam: BAM record reference id %d is not present in the header", refID)
+	}
+
+	offset := 32
+	qname := strings.TrimRight(string(b[offset:offset+lReadName]), "\x00")
+	offset += lReadName
+
+	cigar := CIGAR{}
+	for i := 0; i < nCigar; i++ {
+		op := le.Uint32(b[offset:])
+		if int(op&0xf) >= len(cigarOps) {
+			return Alignment{}, fmt.Errorf("Error parsing CIGAR string.")
+		}
+		cigar.Add(int(op>>4), string(cigarOps[op&0xf]))
+		offset += 4
+	}
+
+	seq := "*"
+	if lSeq > 0 {
+		s := make([]byte, lSeq)
+		for i := 0; i < lSeq; i++ {
+			packed := b[offset+i/2]
+			if i%2 == 0 {
+				s[i] = seqNt16[packed>>4]
+			} else {
+				s[i] = seqNt16[packed&0xf]
+			}
+		}
+		seq = string(s)
+	}
+	offset += (lSeq + 1) / 2
+
+	qual := "*"
+	if lSeq > 0 && b[offset] != 0xff {
+		q := make([]byte, lSeq)
+		for i := 0; i < lSeq; i++ {
+			q[i] = b[offset+i] + 33
+		}
+		qual = string(q)
+	}
+	offset += lSeq
+
+	rnext := "*"
+	if nextRefID == refID && refID >= 0 {
+		rnext = "="
+	} else if nextRefID >= 0 && int(nextRefID) < len(br.refs) {
+		rnext = br.refs[nextRefID]
+	}
+
+	tags, err := decodeBAMTags(b[offset:])
+	if err != nil {
+		return Alignment{}, err
+	}
+
+	rname := "*"
+	if refID >= 0 {
+		rname = br.refs[refID]
+	}
+
+	a := Alignment{qname, newSAMFlag(flag),
+		rname, int(pos) + 1, mapq, cigar, rnext,
+		int(nextPos) + 1, tlen, seq, qual, tags}
+
+	if refID < 0 {
+		return a, ErrUnaligned
+	}
+
+	return a, nil
+
+}
+
+// bamIntSize gives the size in bytes of each of the BAM integer and float types.
+var bamIntSize = map[byte]int{'c': 1, 'C': 1, 's': 2, 'S': 2, 'i': 4, 'I': 4, 'f': 4}
+
+// decodeBAMNumber decodes a single BAM integer or float of the specified type.
+func decodeBAMNumber(b []byte, typ byte) interface{} {
+	le := binary.LittleEndian
+	switch typ {
+	case 'c':
+		return int(int8(b[0]))
+	case 'C':
+		return int(b[0])
+	case 's':
+		return int(int16(le.Uint16(b)))
+	case 'S':
+		return int(le.Uint16(b))
+	case 'i':
+		return int(int32(le.Uint32(b)))
+	case 'I':
+		return int(le.Uint32(b))
+	}
+	return float64(math.Float32frombits(le.Uint32(b)))
+}
+
+// decodeBAMTags decodes the binary optional fields at the end of a BAM record. All of
+// the BAM integer types decode to the single SAM integer type, i.
+func decodeBAMTags(b []byte) (SAMTags, error) {
+
+	var tags SAMTags
+	truncated := fmt.Errorf("sequtil/bam: truncated optional field in BAM record")
+
+	for len(b) > 0 {
+
+		if len(b) < 4 {
+			return nil, truncated
+		}
+		t := SAMTag{Tag: string(b[0:2]), Type: b[2]}
+		typ := b[2]
+		b = b[3:]
+
+		switch typ {
+		case 'A':
+			t.Value = string(b[0])
+			b = b[1:]
+		case 'c', 'C', 's', 'S', 'i', 'I', 'f':
+			if len(b) < bamIntSize[typ] {
+				return nil, truncated
+			}
+			t.Value = decodeBAMNumber(b, typ)
+			if typ != 'f' {
+				t.Type = 'i'
+			}
+			b = b[bamIntSize[typ]:]
+		case 'Z', 'H':
+			end := bytes.IndexByte(b, 0)
+			if end < 0 {
+				return nil, truncated
+			}
+			t.Value = string(b[:end])
+			b = b[end+1:]
+		case 'B':
+			if len(b) < 5 || bamIntSize[b[0]] == 0 {
+				return nil, truncated
+			}
+			t.SubType = b[0]
+			n := int(binary.LittleEndian.Uint32(b[1:]))
+			size := bamIntSize[t.SubType]
+			b = b[5:]
+			if len(b) < n*size {
+				return nil, truncated
+			}
+			if t.SubType == 'f' {
+				vals := make([]float64, n)
+				for i := range vals {
+					vals[i] = decodeBAMNumber(b[i*size:], t.SubType).(float64)
+				}
+				t.Value = vals
+			} else {
+				vals := make([]int, n)
+				for i := range vals {
+					vals[i] = decodeBAMNumber(b[i*size:], t.SubType).(int)
+				}
+				t.Value = vals
+			}
+			b = b[n*size:]
+		default:
+			return nil, fmt.Errorf("sequtil/bam: unsupported optional field type %c in BAM record", typ)
+		}
+
+		tags = append(tags, t)
+
+	}
+
+	return tags, nil
+
+}
+
+// encodeBAMTags encodes a set of optional fields in the BAM binary format, storing each
+// integer in the smallest BAM integer type that can hold it.
+func encodeBAMTags(tags SAMTags, buf *bytes.Buffer) error {
+
+	le := binary.LittleEndian
+	for _, t := range tags {
+
+		buf.WriteString(t.Tag)
+
+		switch v := t.Value.(type) {
+		case int:
+			switch {
+			case v >= 0 && v <= math.MaxUint8:
+				buf.WriteByte('C')
+				buf.WriteByte(byte(v))
+			case v >= math.MinInt8 && v < 0:
+				buf.WriteByte('c')
+				buf.WriteByte(byte(int8(v)))
+			case v >= 0 && v <= math.MaxUint16:
+				buf.WriteByte('S')
+				binary.Write(buf, le, uint16(v))
+			case v >= math.MinInt16 && v < 0:
+				buf.WriteByte('s')
+				binary.Write(buf, le, int16(v))
+			case v >= 0 && v <= math.MaxUint32:
+				buf.WriteByte('I')
+				binary.Write(buf, le, uint32(v))
+			case v >= math.MinInt32 && v < 0:
+				buf.WriteByte('i')
+				binary.Write(buf, le, int32(v))
+			default:
+				return fmt.Errorf("sequtil/bam: integer optional field %s out of range for BAM", t.Tag)
+			}
+		case float64:
+			buf.WriteByte('f')
+			binary.Write(buf, le, float32(v))
+		case string:
+			buf.WriteByte(t.Type)
+			if t.Type == 'A' {
+				buf.WriteByte(v[0])
+			} else {
+				buf.WriteString(v + "\x00")
+			}
+		case []int:
+			buf.WriteByte('B')
+			buf.WriteByte(t.SubType)
+			binary.Write(buf, le, int32(len(v)))
+			for _, e := range v {
+				if size := bamIntSize[t.SubType]; size < 4 && (e >= 1<<uint(8*size) || e < -(1<<uint(8*size-1))) {
+					return fmt.Errorf("sequtil/bam: array optional field %s has element %d out of range for type %c", t.Tag, e, t.SubType)
+				}
+				switch t.SubType {
+				case 'c', 'C':
+					buf.WriteByte(byte(e))
+				case 's', 'S':
+					binary.Write(buf, le, uint16(e))
+				default:
+					binary.Write(buf, le, uint32(e))
+				}
+			}
+		case []float64:
+			buf.WriteByte('B')
+			buf.WriteByte('f')
+			binary.Write(buf, le, int32(len(v)))
+			for _, e := range v {
+				binary.Write(buf, le, float32(e))
+			}
+		default:
+			return fmt.Errorf("sequtil/bam: unsupported value for optional field %s", t.Tag)
+		}
+
+	}
+
+	return nil
+
+}
+
+// Alignment returns the record read by the last call to Scan, along with any error
+// encountered decoding it.
+func (br *bamReader) Alignment() (Alignment, error) {
+	return br.cur, br.curErr
+}
+
+// Header returns the plain text SAM header stored in the BAM file.
+func (br *bamReader) Header() string {
+	return br.header
+}
+
+// Err returns the first non-EOF error encountered while reading the BAM file.
+func (br *bamReader) Err() error {
+	return br.err
+}
+
+// Close closes the underlying BAM file.
+func (br *bamReader) Close() error {
+	return br.in.Close()
+}
+
+// bamWriter writes Alignment records to a BAM file, see AlignmentWriter.
+type bamWriter struct {
+	f    *os.File
+	bgzf *bgzfWriter
+	w    *bufio.Writer
+	refs map[string]int
+}
+
+// newBAMWriter writes the BAM header, including the reference list parsed from the
+// @SQ lines of the provided SAM header text, and returns a bamWriter ready to accept
+// records.
+func newBAMWriter(f *os.File, header string) (*bamWriter, error) {
+
+	bw := appendBAMWriter(f, header)
+	names, lengths := bamRefs(header)
+
+	le := binary.LittleEndian
+	bw.w.Write(bamMagic)
+	binary.Write(bw.w, le, int32(len(header)))
+	bw.w.WriteString(header)
+	binary.Write(bw.w, le, int32(len(names)))
+	for i, name := range names {
+		binary.Write(bw.w, le, int32(len(name)+1))
+		bw.w.WriteString(name + "\x00")
+		binary.Write(bw.w, le, int32(lengths[i]))
+	}
+
+	return bw, nil
+
+}
+
+// appendBAMWriter returns a bamWriter appending records to a BAM file whose header,
+// that provided, has already been written. The records are written as further BGZF
+// blocks following the EOF marker block of the existing file, which readers take as
+// an empty block.
+func appendBAMWriter(f *os.File, header string) *bamWriter {
+
+	bw := &bamWriter{f: f, bgzf: newBGZFWriter(f)}
+	bw.w = bufio.NewWriter(bw.bgzf)
+
+	names, _ := bamRefs(header)
+	bw.refs = make(map[string]int, len(names))
+	for i, name := range names {
+		bw.refs[name] = i
+	}
+
+	return bw
+
+}
+
+// Write encodes an Alignment as a binary BAM record.
+func (bw *bamWriter) Write(a Alignment) error {
+
+	refID, ok := bw.refs[a.rname]
+	if a.rname == "*" {
+		refID, ok = -1, true
+	}
+	if !ok {
+		return fmt.Errorf("sequtil/bam: reference %s of read %s is not present in the BAM header", a.rname, a.qname)
+	}
+
+	nextRefID := -1
+	if a.rnext == "=" {
+		nextRefID = refID
+	} else if id, ok := bw.refs[a.rnext]; ok {
+		nextRefID = id
+	}
+
+	seq := a.seq
+	if seq == "*" {
+		seq = ""
+	}
+
+	var rec bytes.Buffer
+	le := binary.LittleEndian
+	binary.Write(&rec, le, int32(refID))
+	binary.Write(&rec, le, int32(a.pos-1))
+	rec.WriteByte(byte(len(a.qname) + 1))
+	rec.WriteByte(byte(a.mapq))
+	binary.Write(&rec, le, uint16(reg2bin(a.pos-1, a.pos-1+a.cigar.refLength())))
+	binary.Write(&rec, le, uint16(len(a.cigar)))
+	binary.Write(&rec, le, uint16(a.flag.code))
+	binary.Write(&rec, le, int32(len(seq)))
+	binary.Write(&rec, le, int32(nextRefID))
+	nextPos := a.pnext - 1
+	if a.pnext <= 0 {
+		nextPos = -1
+	}
+	binary.Write(&rec, le, int32(nextPos))
+	binary.Write(&rec, le, int32(a.tlen))
+	rec.WriteString(a.qname + "\x00")
+
+	for _, cc := range a.cigar {
+		op := strings.Index(cigarOps, cc.code)
+		if op < 0 {
+			return fmt.Errorf("sequtil/bam: unsupported CIGAR operation %s in read %s", cc.code, a.qname)
+		}
+		binary.Write(&rec, le, uint32(cc.value<<4|op))
+	}
+
+	packed := make([]byte, (len(seq)+1)/2)
+	for i := 0; i < len(seq); i++ {
+		code := strings.IndexByte(seqNt16, seq[i])
+		if code < 0 {
+			code = 15 // N
+		}
+		if i%2 == 0 {
+			packed[i/2] |= byte(code << 4)
+		} else {
+			packed[i/2] |= byte(code)
+		}
+	}
+	rec.Write(packed)
+
+	for i := 0; i < len(seq); i++ {
+		if a.qual == "*" || i >= len(a.qual) {
+			rec.WriteByte(0xff)
+		} else {
+			rec.WriteByte(a.qual[i] - 33)
+		}
+	}
+
+	if err := encodeBAMTags(a.tags, &rec); err != nil {
+		return err
+	}
+
+	binary.Write(bw.w, le, int32(rec.Len()))
+	_, err := bw.w.Write(rec.Bytes())
+	return err
+
+}
+
+// Close flushes all buffered records, terminates the BGZF stream and closes the
+// underlying file.
+func (bw *bamWriter) Close() error {
+	if err := bw.w.Flush(); err != nil {
+		return err
+	}
+	if err := bw.bgzf.Close(); err != nil {
+		return err
+	}
+	return bw.f.Close()
+}
@@ -0,0 +1,183 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestBAMIO(t *testing.T) {
+
+	path := "/tmp/lxy/test/testbamio.bam"
+	MkdirForFile(path)
+
+	header := "@HD\tVN:1.6\tSO:queryname\n@SQ\tSN:chr1\tLN:100\n@SQ\tSN:chr2\tLN:300\n"
+
+	sf, _ := parseSAMFlag("97")
+	alignments := []Alignment{
+		Alignment{"readid-1", sf, "chr1", 4, 60,
+			CIGAR{CIGARCode{1, "S"}, CIGARCode{3, "M"}, CIGARCode{1, "I"}, CIGARCode{2, "M"}},
+			"chr2", 200, 0, "AATGCGT", "IIIIIII",
+			SAMTags{SAMTag{"NM", 'i', 0, 1}, SAMTag{"AS", 'i', 0, -300}, SAMTag{"MC", 'Z', 0, "3M"}}},
+		Alignment{"readid-1", newSAMFlag(145), "chr2", 200, 0,
+			CIGAR{CIGARCode{3, "M"}},
+			"chr1", 4, 0, "GCG", "*",
+			SAMTags{SAMTag{"XF", 'f', 0, 0.5}, SAMTag{"RG", 'A', 0, "x"}, SAMTag{"XB", 'B', 'I', []int{1, 70000}}}},
+		Alignment{"readid-2", newSAMFlag(0), "chr1", 23, 37,
+			CIGAR{CIGARCode{2, "M"}, CIGARCode{5, "D"}, CIGARCode{1, "M"}},
+			"=", 0, 0, "GTA", "#5I", nil},
+	}
+
+	out, err := CreateAlignments(path, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range alignments {
+		if err := out.Write(a); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	in, err := OpenAlignments(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	if in.Header() != header {
+		t.Errorf("OpenAlignments(%s) read header %q, expected %q", path, in.Header(), header)
+	}
+
+	read := []Alignment{}
+	for in.Scan() {
+		a, e := in.Alignment()
+		if e != nil {
+			t.Error(e)
+		}
+		read = append(read, a)
+	}
+	if in.Err() != nil {
+		t.Error(in.Err())
+	}
+
+	if !reflect.DeepEqual(read, alignments) {
+		t.Errorf("alignments read back from BAM do not match those written")
+		t.Log(read)
+		t.Log(alignments)
+	}
+
+	os.Remove(path)
+
+}
+
+func TestBAMCorrupt(t *testing.T) {
+
+	// bam concatenates the magic number with little-endian int32 lengths and fields.
+	bam := func(fields ...interface{}) *inputFile {
+		var b bytes.Buffer
+		b.Write(bamMagic)
+		for _, f := range fields {
+			if s, ok := f.(string); ok {
+				b.WriteString(s)
+			} else {
+				binary.Write(&b, binary.LittleEndian, f)
+			}
+		}
+		return &inputFile{Reader: bufio.NewReader(&b)}
+	}
+
+	// Header lengths which are negative or run past the end of the input are errors.
+	headers := map[string]*inputFile{
+		"negative text length":     bam(int32(-1)),
+		"text length past the end": bam(int32(1<<30), "@HD"),
+		"negative reference count": bam(int32(0), int32(-1)),
+		"reference count past end": bam(int32(0), int32(1<<30), int32(5), "chr1\x00"),
+		"negative name length":     bam(int32(0), int32(1), int32(-4), "chr1"),
+		"name length past the end": bam(int32(0), int32(1), int32(1<<30), "chr1"),
+	}
+	for name, in := range headers {
+		if _, err := newBAMReader(in); err == nil {
+			t.Errorf("newBAMReader() didn't return an error for a header with a %s", name)
+		}
+	}
+
+	// A record whose block size is negative or runs past the end of the input ends the
+	// reading with an error.
+	header := []interface{}{int32(0), int32(1), int32(5), "chr1\x00", int32(100)}
+	for _, size := range []int32{-1, 1 << 30} {
+		br, err := newBAMReader(bam(append(header, size, "truncated")...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if br.Scan() || br.Err() == nil {
+			t.Errorf("bamReader.Scan() didn't return an error for a record of block size %d", size)
+		}
+	}
+
+	// A record with a negative sequence length is an error.
+	record := []interface{}{int32(0), int32(0), uint8(2), uint8(60), uint16(0), uint16(0), uint16(0),
+		int32(-8), int32(-1), int32(-1), int32(0), "r\x00"}
+	var block bytes.Buffer
+	for _, f := range record {
+		if s, ok := f.(string); ok {
+			block.WriteString(s)
+		} else {
+			binary.Write(&block, binary.LittleEndian, f)
+		}
+	}
+	br, err := newBAMReader(bam(append(header, int32(block.Len()), block.String())...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !br.Scan() {
+		t.Fatalf("bamReader.Scan() yielded no record: %v", br.Err())
+	}
+	if _, err := br.Alignment(); err == nil {
+		t.Errorf("bamReader.Alignment() didn't return an error for a negative sequence length")
+	}
+
+}
+
+func TestOpenAlignmentsSAM(t *testing.T) {
+
+	path := "/tmp/lxy/test/testsamio.sam"
+	MkdirForFile(path)
+
+	header := "@SQ\tSN:chr1\tLN:100\n"
+	a := Alignment{"readid-1", newSAMFlag(16), "chr1", 4, 60, CIGAR{CIGARCode{3, "M"}}, "*", 0, 0, "ATG", "III", nil}
+
+	out, err := CreateAlignments(path, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Write(a)
+	out.Close()
+
+	in, err := OpenAlignments(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	if _, ok := in.(*samReader); !ok {
+		t.Errorf("OpenAlignments(%s) did not detect a plain text SAM file", path)
+	}
+	if in.Header() != header {
+		t.Errorf("OpenAlignments(%s) read header %q, expected %q", path, in.Header(), header)
+	}
+	if !in.Scan() {
+		t.Fatalf("OpenAlignments(%s) yielded no records", path)
+	}
+	if b, _ := in.Alignment(); !reflect.DeepEqual(a, b) {
+		t.Errorf("alignment read back from SAM does not match that written")
+	}
+
+	os.Remove(path)
+
+}
@@ -0,0 +1,283 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// fivePrimeClip returns the number of bases of a read clipped from its 5' end by an
+// alignment, soft or hard, which for reads aligned to the reverse strand are those at
+// the end of the CIGAR. Of the segments of a chimeric read, that with the least 5'
+// clipping is the one containing the 5' end of the read.
+func fivePrimeClip(a Alignment) int {
+
+	clip := 0
+	for i := range a.cigar {
+		cc := a.cigar[i]
+		if a.flag.reversecomp {
+			cc = a.cigar[len(a.cigar)-1-i]
+		}
+		if cc.code != "S" && cc.code != "H" {
+			break
+		}
+		clip += cc.value
+	}
+
+	return clip
+
+}
+
+// complementBases maps each nucleotide to its complement, see reverseComplement.
+var complementBases = map[byte]byte{
+	'A': 'T', 'C': 'G', 'G': 'C', 'T': 'A', 'N': 'N',
+	'a': 't', 'c': 'g', 'g': 'c', 't': 'a', 'n': 'n',
+}
+
+// reverseComplement returns the reverse complement of a nucleotide sequence, with
+// characters other than ACGTN left as they are.
+func reverseComplement(seq string) string {
+	rc := make([]byte, len(seq))
+	for i := 0; i < len(seq); i++ {
+		b := seq[len(seq)-1-i]
+		if c, ok := complementBases[b]; ok {
+			b = c
+		}
+		rc[i] = b
+	}
+	return string(rc)
+}
+
+// reverse returns a string with its characters in reverse order.
+func reverse(s string) string {
+	r := make([]byte, len(s))
+	for i := 0; i < len(s); i++ {
+		r[i] = s[len(s)-1-i]
+	}
+	return string(r)
+}
+
+// supplementarySegments returns the other segments of a chimeric read given by the SA
+// tag of one of its records, as supplementary records of the same read. When the
+// record carries the full read sequence, without hard clipping, the sequence and base
+// qualities of each segment are those of the full read, with its hard clipping
+// replaced by soft clipping, such that variants can be called from the segment.
+//
+// The SA tag is a list of segments of the form rname,pos,strand,CIGAR,mapQ,NM;
+// and malformed segments are skipped.
+func supplementarySegments(a Alignment) []Alignment {
+
+	sa, ok := a.StringTag("SA")
+	if !ok {
+		return []Alignment{}
+	}
+
+	full := len(a.seq) > 1
+	for _, cc := range a.cigar {
+		if cc.code == "H" {
+			full = false
+		}
+	}
+
+	segments := []Alignment{}
+	for _, segment := range strings.Split(sa, ";") {
+
+		fields := strings.Split(segment, ",")
+		if len(fields) != 6 || (fields[2] != "+" && fields[2] != "-") {
+			continue
+		}
+		pos, ep := strconv.Atoi(fields[1])
+		mapq, em := strconv.Atoi(fields[4])
+		cigar, ec := parseCIGAR(fields[3])
+		if ep != nil || em != nil || ec != nil || len(cigar) == 0 {
+			continue
+		}
+
+		reverseStrand := fields[2] == "-"
+		flag := (a.flag.code &^ (0x4 | 0x10 | 0x100)) | 0x800
+		if reverseStrand {
+			flag |= 0x10
+		}
+
+		seq, qual := "*", "*"
+		if full {
+			seq, qual = a.seq, a.qual
+			if reverseStrand != a.flag.reversecomp {
+				seq = reverseComplement(seq)
+				if qual != "*" {
+					qual = reverse(qual)
+				}
+			}
+			for i := range cigar {
+				if cigar[i].code == "H" {
+					cigar[i].code = "S"
+				}
+			}
+		}
+
+		segments = append(segments, Alignment{a.qname, newSAMFlag(flag), fields[0], pos, mapq, cigar, a.rnext, a.pnext, 0, seq, qual, nil})
+
+	}
+
+	return segments
+
+}
+
+// fivePrimeSegment returns the segment of a read containing its 5' end from among its
+// aligned records and the segments given by their SA tags, see fivePrimeClip, or false
+// if none of the records are aligned. Secondary records are disregarded other than for
+// their SA tags, as BWA MEM -M marks the shorter segments of chimeric reads secondary
+// rather than supplementary, and ties are resolved in favor of the primary record.
+func fivePrimeSegment(records []Alignment) (Alignment, bool) {
+
+	candidates := []Alignment{}
+	for _, primary := range []bool{true, false} {
+		for _, a := range records {
+			if !a.flag.unmapped && !a.flag.secondary && a.flag.supplementary != primary {
+				candidates = append(candidates, a)
+			}
+		}
+	}
+	for _, a := range records {
+		if !a.flag.unmapped {
+			candidates = append(candidates, supplementarySegments(a)...)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return Alignment{}, false
+	}
+
+	best := candidates[0]
+	for _, a := range candidates[1:] {
+		if fivePrimeClip(a) < fivePrimeClip(best) {
+			best = a
+		}
+	}
+
+	return best, true
+
+}
+
+// pairHits forms a read pair from the records sharing a qname. The records of each mate
+// are identified by their first and last segment flags, and the segment of each
+// containing the 5' end of the read is chosen, see fivePrimeSegment, so that reads
+// spanning a ligation junction, aligned as a primary and a supplementary record, yield
+// the contact between the ligated fragments. Groups of two records that cannot be
+// divided into mates, e.g. lacking those flags, are paired as they are.
+func pairHits(hits []Alignment) (Alignment, Alignment, bool) {
+
+	mates := [2][]Alignment{}
+	for _, a := range hits {
+		switch {
+		case a.flag.first && !a.flag.last:
+			mates[0] = append(mates[0], a)
+		case a.flag.last && !a.flag.first:
+			mates[1] = append(mates[1], a)
+		}
+	}
+
+	if len(mates[0]) == 0 || len(mates[1]) == 0 {
+		if len(hits) == 2 && unflaggedMates(hits[0], hits[1]) {
+			return hits[0], hits[1], true
+		}
+		return Alignment{}, Alignment{}, false
+	}
+
+	a1, ok1 := fivePrimeSegment(mates[0])
+	a2, ok2 := fivePrimeSegment(mates[1])
+
+	return a1, a2, ok1 && ok2
+
+}
+
+// unflaggedMates determines whether two records which can't be divided into mates by
+// their segment flags may nonetheless be the records of the two mates of a pair, being
+// neither secondary nor supplementary, and not both flagged as the same mate. The
+// records of one mate whose other mate is missing, e.g. a primary and a supplementary
+// record, are thereby not paired with one another.
+func unflaggedMates(a1, a2 Alignment) bool {
+	for _, a := range []Alignment{a1, a2} {
+		if a.flag.secondary || a.flag.supplementary {
+			return false
+		}
+	}
+	return !(a1.flag.first && a2.flag.first) && !(a1.flag.last && a2.flag.last)
+}
+
+// pairScanner iterates over the read pairs of an alignments file whose records are
+// grouped by qname in the manner of a bufio.Scanner, forming a pair from each group of
+// records, see pairHits. Groups interrupted by a record that could not be parsed or
+// did not align are skipped, without affecting the groups of other reads.
+type pairScanner struct {
+	in       AlignmentReader
+	current  string
+	hits     []Alignment
+	poisoned bool
+	done     bool
+	a1, a2   Alignment
+	pairs    int
+	rescued  int
+}
+
+// newPairScanner constructs a pairScanner reading from an AlignmentReader.
+func newPairScanner(in AlignmentReader) *pairScanner {
+	return &pairScanner{in: in, hits: []Alignment{}}
+}
+
+// Scan advances to the next read pair, returning false at the end of the input.
+func (s *pairScanner) Scan() bool {
+
+	for !s.done {
+
+		group := []Alignment{}
+		if s.in.Scan() {
+			// A record that could not be parsed or did not align poisons the group of
+			// its own read, while the group of the previous read is still paired.
+			a, e := s.in.Alignment()
+			if len(s.current) > 0 && a.qname == s.current {
+				if e != nil {
+					s.hits, s.poisoned = []Alignment{}, true
+				} else if !s.poisoned {
+					s.hits = append(s.hits, a)
+				}
+				continue
+			}
+			group = s.hits
+			s.current, s.hits, s.poisoned = a.qname, []Alignment{}, e != nil
+			if e == nil {
+				s.hits = append(s.hits, a)
+			}
+		} else {
+			s.done = true
+			group = s.hits
+		}
+
+		if len(group) == 0 {
+			continue
+		}
+		if a1, a2, ok := pairHits(group); ok {
+			s.a1, s.a2 = a1, a2
+			s.pairs += 1
+			if len(group) != 2 {
+				s.rescued += 1
+			}
+			return true
+		}
+
+	}
+
+	return false
+
+}
+
+// Pair returns the current read pair.
+func (s *pairScanner) Pair() (Alignment, Alignment) {
+	return s.a1, s.a2
+}
+
+// Summary reports the number of read pairs formed from groups of other than two
+// records, which would be discarded if chimeric reads were not resolved.
+func (s *pairScanner) Summary() string {
+	return fmt.Sprintf("rescued %d of %d read pairs from chimeric alignments\n", s.rescued, s.pairs)
+}
@@ -0,0 +1,155 @@
+package util
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPairHits(t *testing.T) {
+
+	parse := func(lines ...string) []Alignment {
+		hits := []Alignment{}
+		for _, line := range lines {
+			a, err := parseSAMLine(line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hits = append(hits, a)
+		}
+		return hits
+	}
+
+	// The first 3 bases of read 1 span a ligation junction to chr2, either as a forward
+	// supplementary record or given only by the SA tag of the primary on the reverse
+	// strand, with the sequence taken from the primary.
+	primary := "read\t65\tchr1\t100\t60\t3S7M\tchr3\t1000\t0\tAACGTACGTT\tABCDEFGHIJ\tSA:Z:chr2,500,+,3M7H,60,0;"
+	primaryRev := "read\t65\tchr1\t100\t60\t3S7M\tchr3\t1000\t0\tAACGTACGTT\tABCDEFGHIJ\tSA:Z:chr2,500,-,7S3M,60,0;"
+	supplementary := "read\t2113\tchr2\t500\t60\t3M7H\tchr3\t1000\t0\tAAC\tABC\tSA:Z:chr1,100,+,3S7M,60,0;"
+	secondary := "read\t321\tchr2\t500\t60\t3M7H\tchr3\t1000\t0\tAAC\tABC"
+	mate := "read\t145\tchr3\t1000\t60\t10M\tchr1\t100\t0\tGGGGGGGGGG\tIIIIIIIIII"
+
+	tests := []struct {
+		hits       []Alignment
+		ok         bool
+		r1, r2     string
+		pos1       int
+		reverse1   bool
+		seq1, cig1 string
+	}{
+		{parse(primary, supplementary, mate), true, "chr2", "chr3", 500, false, "AAC", "3M7H"},
+		{parse(mate, supplementary, primary), true, "chr2", "chr3", 500, false, "AAC", "3M7H"},
+		{parse(primary, secondary, mate), true, "chr2", "chr3", 500, false, "AACGTACGTT", "3M7S"},
+		{parse(primaryRev, mate), true, "chr2", "chr3", 500, true, "AACGTACGTT", "7S3M"},
+		{parse("read\t65\tchr1\t100\t60\t10M\t*\t0\t0\tAACGTACGTT\tABCDEFGHIJ", mate), true, "chr1", "chr3", 100, false, "AACGTACGTT", "10M"},
+		// Records which cannot be divided into mates are paired only if there are two.
+		{parse("read\t16\tchr1\t4\t60\t3M\t*\t0\t0\tATG\tIII", "read\t16\tchr2\t23\t60\t3M\t*\t0\t0\tGTA\tIII"), true, "chr1", "chr2", 4, true, "ATG", "3M"},
+		{parse(primary, supplementary, secondary), false, "", "", 0, false, "", ""},
+		// A read is not paired with its own supplementary record when its mate is missing.
+		{parse(primary, supplementary), false, "", "", 0, false, "", ""},
+	}
+
+	for i, test := range tests {
+		a1, a2, ok := pairHits(test.hits)
+		if ok != test.ok {
+			t.Errorf("pairHits() of group %d yielded %t, expected %t", i, ok, test.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if a1.rname != test.r1 || a2.rname != test.r2 || a1.pos != test.pos1 || a1.flag.reversecomp != test.reverse1 {
+			t.Errorf("pairHits() of group %d yielded pair:\n%s\n%s", i, a1, a2)
+		}
+		if a1.seq != test.seq1 && !(test.reverse1 && a1.seq == reverseComplement(test.seq1)) {
+			t.Errorf("pairHits() of group %d yielded sequence %s, expected %s", i, a1.seq, test.seq1)
+		}
+		if a1.cigar.String() != test.cig1 {
+			t.Errorf("pairHits() of group %d yielded CIGAR %s, expected %s", i, a1.cigar.String(), test.cig1)
+		}
+	}
+
+	// Segments from the SA tag of a record on the other strand are reverse complemented.
+	a, _ := parseSAMLine(primaryRev)
+	segments := supplementarySegments(a)
+	if len(segments) != 1 || segments[0].seq != "AACGTACGTT" || segments[0].qual != "JIHGFEDCBA" || fivePrimeClip(segments[0]) != 0 || fivePrimeClip(a) != 3 {
+		t.Errorf("supplementarySegments(%s) yielded %v", a, segments)
+	}
+
+}
+
+func TestScaffoldLinksFromSamChimeric(t *testing.T) {
+
+	samPath := "/tmp/lxy/test/testchimeric.sam"
+	outPath := "/tmp/lxy/test/testchimeric.links"
+	MkdirForFile(samPath)
+
+	sam := []string{
+		"read-1\t65\tchr1\t100\t60\t3S7M\tchr3\t1000\t0\tAACGTACGTT\tABCDEFGHIJ\tSA:Z:chr2,500,+,3M7H,60,0;",
+		"read-1\t2113\tchr2\t500\t60\t3M7H\tchr3\t1000\t0\tAAC\tABC\tSA:Z:chr1,100,+,3S7M,60,0;",
+		"read-1\t145\tchr3\t1000\t60\t10M\tchr1\t100\t0\tGGGGGGGGGG\tIIIIIIIIII",
+		"read-2\t65\tchr1\t100\t60\t10M\tchr3\t1000\t0\tAACGTACGTT\tABCDEFGHIJ",
+		"read-2\t145\tchr3\t1000\t60\t10M\tchr1\t100\t0\tGGGGGGGGGG\tIIIIIIIIII",
+	}
+	f, err := os.Create(samPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(strings.Join(sam, "\n") + "\n")
+	f.Close()
+
+	if err := ScaffoldLinksFromSam(samPath, outPath, nil, nil, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	links, _ := LoadLinks(outPath)
+	v13, _ := links.Get(links.ID("chr1"), links.ID("chr3"))
+	v23, _ := links.Get(links.ID("chr2"), links.ID("chr3"))
+	if v13 != 1 || v23 != 1 {
+		t.Errorf("ScaffoldLinksFromSam(%s) yielded chr1-chr3 %f and chr2-chr3 %f links, expected 1 and 1", samPath, v13, v23)
+	}
+
+	in, _ := OpenAlignments(samPath)
+	pairs := newPairScanner(in)
+	for pairs.Scan() {
+	}
+	in.Close()
+	if pairs.rescued != 1 || pairs.pairs != 2 {
+		t.Errorf("pairScanner of %s rescued %d of %d pairs, expected 1 of 2", samPath, pairs.rescued, pairs.pairs)
+	}
+
+	// A pair with an unmapped mate is skipped without losing the pair before it.
+	sam = []string{
+		"read-1\t65\tchr1\t100\t60\t10M\tchr2\t1000\t0\tAACGTACGTT\tABCDEFGHIJ",
+		"read-1\t145\tchr2\t1000\t60\t10M\tchr1\t100\t0\tGGGGGGGGGG\tIIIIIIIIII",
+		"read-2\t69\t*\t0\t0\t*\tchr1\t300\t0\tAACGTACGTT\tABCDEFGHIJ",
+		"read-2\t137\tchr1\t300\t60\t10M\t=\t300\t0\tGGGGGGGGGG\tIIIIIIIIII",
+		"read-3\t65\tchr1\t500\t60\t10M\tchr2\t2000\t0\tAACGTACGTT\tABCDEFGHIJ",
+		"read-3\t145\tchr2\t2000\t60\t10M\tchr1\t500\t0\tGGGGGGGGGG\tIIIIIIIIII",
+	}
+	writeTestFile(t, samPath, strings.Join(sam, "\n")+"\n")
+
+	in, _ = OpenAlignments(samPath)
+	pairs = newPairScanner(in)
+	for pairs.Scan() {
+		if pairs.a1.rname != "chr1" || pairs.a2.rname != "chr2" {
+			t.Errorf("pairScanner of %s yielded pair:\n%s\n%s", samPath, pairs.a1, pairs.a2)
+		}
+	}
+	in.Close()
+	if pairs.pairs != 2 {
+		t.Errorf("pairScanner of %s yielded %d pairs, expected 2", samPath, pairs.pairs)
+	}
+
+	if err := ScaffoldLinksFromSam(samPath, outPath, nil, nil, 1); err != nil {
+		t.Fatal(err)
+	}
+	links, _ = LoadLinks(outPath)
+	if v, _ := links.Get(links.ID("chr1"), links.ID("chr2")); v != 2 {
+		t.Errorf("ScaffoldLinksFromSam(%s) yielded %f chr1-chr2 links, expected 2", samPath, v)
+	}
+
+	os.Remove(samPath)
+	os.Remove(outPath)
+
+}
@@ -2,10 +2,11 @@ package util
 
 import (
 	"fmt"
-	log "github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
+	"io"
 	"os"
-	"os/exec"
+	"runtime"
+	"strings"
 )
 
 func VarsCommand() cli.Command {
@@ -141,47 +142,336 @@ func SeqCommand() cli.Command {
 					},
 				},
 			},
+			cli.Command{
+				Name:   "faidx",
+				Usage:  "Index a fasta, writing a .fai, and optionally print the sequence of a region.",
+				Action: faidxCommand,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "fasta",
+						Value: "",
+						Usage: "Path to the uncompressed fasta to index.",
+					},
+					cli.StringFlag{
+						Name:  "region",
+						Value: "",
+						Usage: "The region to print, as contig or contig:start-end with 1-based inclusive coordinates.",
+					},
+					cli.StringFlag{
+						Name:  "output",
+						Value: "",
+						Usage: "The destination path to which to write the sequence, stdout if unspecified.",
+					},
+				},
+			},
 			cli.Command{
 				Name:   "align",
-				Usage:  "Wrapper for BWA MEM sequence alignment.",
+				Usage:  "Align Hi-C read pairs, streaming the alignments into the pair filters and link builder.",
 				Action: alignCommand,
+				Flags: append([]cli.Flag{
+					cli.StringFlag{
+						Name:  "r1",
+						Value: "",
+						Usage: "FASTQ of R1 reads, or of interleaved reads if --r2 is not given.",
+					},
+					cli.StringFlag{
+						Name:  "r2",
+						Value: "",
+						Usage: "FASTQ of R2 reads.",
+					},
+					cli.StringFlag{
+						Name:  "ref",
+						Value: "",
+						Usage: "Reference sequence, which is indexed if it has not been.",
+					},
+					cli.StringFlag{
+						Name:  "mode",
+						Value: AlignMates,
+						Usage: "How the mates are aligned, independently (mates) or together without mate rescue (paired).",
+					},
+					cli.StringFlag{
+						Name:  "aligner",
+						Value: "bwa",
+						Usage: "The aligner, one of " + strings.Join(AlignerNames, ", ") + ".",
+					},
+					cli.IntFlag{
+						Name:  "threads",
+						Value: runtime.NumCPU(),
+						Usage: "Number of threads used by the aligner and to build links.",
+					},
+					cli.StringFlag{
+						Name:  "sam",
+						Value: "",
+						Usage: "Optional path to which to write the alignments, as BAM if it ends in .bam.",
+					},
+					cli.StringFlag{
+						Name:  "links",
+						Value: "",
+						Usage: "Optional path to which to write the links between contigs built from the aligned pairs, in the binary links format if it has the extension .lxyb.",
+					},
+				}, PairFilterFlags()...),
+			},
+			cli.Command{
+				Name:   "sort",
+				Usage:  "Sort a SAM or BAM file by read name, as required to build links, using a bounded amount of memory.",
+				Action: sortCommand,
 				Flags: []cli.Flag{
 					cli.StringFlag{
 						Name:  "input",
 						Value: "",
-						Usage: "FASTQ reads to align to the reference sequence.",
+						Usage: "Path to the SAM or BAM file to be sorted.",
 					},
 					cli.StringFlag{
 						Name:  "output",
 						Value: "",
-						Usage: "Path to which to write aligned reads.",
+						Usage: "The destination path to which to write the output, BAM if it ends in .bam and SAM otherwise.",
+					},
+					cli.IntFlag{
+						Name:  "mem",
+						Value: DefaultSortMemory / (1024 * 1024),
+						Usage: "Approximate memory in megabytes to use for records held in memory.",
 					},
 					cli.StringFlag{
-						Name:  "ref",
+						Name:  "tmpdir",
 						Value: "",
-						Usage: "Indexed reference sequence.",
+						Usage: "Directory in which to write temporary files, the system temporary directory if not given.",
 					},
 				},
 			},
 			cli.Command{
-				Name:   "index",
-				Usage:  "Wrapper for BWA sequence indexing.",
-				Action: alignCommand,
+				Name:   "stats",
+				Usage:  "Report pair, MAPQ, insert distance, contig contact and flag statistics of a Hi-C library.",
+				Action: statsCommand,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "sam",
+						Value: "",
+						Usage: "Path to the SAM, BAM or .pairs file of the aligned read pairs.",
+					},
+					cli.StringFlag{
+						Name:  "output",
+						Value: "",
+						Usage: "Path to which to write the tab-delimited report, written to stdout if none is given.",
+					},
+					cli.StringFlag{
+						Name:  "json",
+						Value: "",
+						Usage: "Path to which to write the report as JSON.",
+					},
+				},
+			},
+			cli.Command{
+				Name:   "decay",
+				Usage:  "Estimate the decay of contact probability with genomic distance, P(s), from intra-contig pairs.",
+				Action: decayCommand,
+				Flags: append([]cli.Flag{
+					cli.StringFlag{
+						Name:  "sam",
+						Value: "",
+						Usage: "Path to the SAM, BAM or .pairs file of the aligned read pairs.",
+					},
+					cli.StringFlag{
+						Name:  "output",
+						Value: "",
+						Usage: "Path to which to write the curve, written to stdout if none is given.",
+					},
+					cli.IntFlag{
+						Name:  "binsPerDecade",
+						Value: 10,
+						Usage: "Number of log-spaced distance bins per factor of ten of distance.",
+					},
+					cli.IntFlag{
+						Name:  "fitMin",
+						Value: 1000,
+						Usage: "Minimum distance of the bins to which the power law is fit.",
+					},
+					cli.IntFlag{
+						Name:  "fitMax",
+						Value: 0,
+						Usage: "Maximum distance of the bins to which the power law is fit, no limit if zero.",
+					},
+				}, PairFilterFlags()...),
+			},
+			cli.Command{
+				Name:   "trim",
+				Usage:  "Trim reads at the ligation junctions of a restriction enzyme before alignment.",
+				Action: trimCommand,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "fastq",
+						Value: "",
+						Usage: "Path to the FASTQ file of reads, or of R1 reads if --fastq2 is given, plain or gzipped.",
+					},
+					cli.StringFlag{
+						Name:  "fastq2",
+						Value: "",
+						Usage: "Path to the FASTQ file of R2 reads.",
+					},
+					cli.StringFlag{
+						Name:  "output",
+						Value: "",
+						Usage: "Path to which to write the trimmed reads, gzipped if it ends in .gz.",
+					},
+					cli.StringFlag{
+						Name:  "output2",
+						Value: "",
+						Usage: "Path to which to write the trimmed R2 reads.",
+					},
+					cli.StringFlag{
+						Name:  "enzyme",
+						Value: "",
+						Usage: "Restriction enzyme, e.g. DpnII, HindIII or Arima, or sites such as ^GATC,G^ANTC.",
+					},
+					cli.StringFlag{
+						Name:  "report",
+						Value: "",
+						Usage: "Path to which to write the trimming statistics, written to stdout if none is given.",
+					},
+				},
+			},
+			cli.Command{
+				Name:   "interleave",
+				Usage:  "Interleave R1 and R2 FASTQ files into a single file.",
+				Action: interleaveCommand,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "r1",
+						Value: "",
+						Usage: "Path to the FASTQ file of R1 reads.",
+					},
+					cli.StringFlag{
+						Name:  "r2",
+						Value: "",
+						Usage: "Path to the FASTQ file of R2 reads.",
+					},
+					cli.StringFlag{
+						Name:  "output",
+						Value: "",
+						Usage: "Path to which to write the interleaved reads.",
+					},
+				},
+			},
+			cli.Command{
+				Name:   "deinterleave",
+				Usage:  "Split an interleaved FASTQ file into R1 and R2 files.",
+				Action: deinterleaveCommand,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "input",
+						Value: "",
+						Usage: "Path to the interleaved FASTQ file.",
+					},
+					cli.StringFlag{
+						Name:  "r1",
+						Value: "",
+						Usage: "Path to which to write the R1 reads.",
+					},
+					cli.StringFlag{
+						Name:  "r2",
+						Value: "",
+						Usage: "Path to which to write the R2 reads.",
+					},
+				},
+			},
+			cli.Command{
+				Name:   "split",
+				Usage:  "Split a SAM or BAM file into a file for each contig or group of contigs.",
+				Action: splitCommand,
 				Flags: []cli.Flag{
 					cli.StringFlag{
 						Name:  "input",
 						Value: "",
-						Usage: "FASTQ reads to align to the reference sequence.",
+						Usage: "Path to the SAM or BAM file to split.",
+					},
+					cli.StringFlag{
+						Name:  "outstem",
+						Value: "",
+						Usage: "Output path stem, to which the contig or group name and extension are appended.",
+					},
+					cli.StringFlag{
+						Name:  "groups",
+						Value: "",
+						Usage: "Path to a file of contig, group lines, e.g. a contig clustering, by which to split rather than by contig.",
+					},
+					cli.IntFlag{
+						Name:  "maxOpenFiles",
+						Value: DefaultMaxOpenFiles,
+						Usage: "Maximum number of output files to hold open at once.",
+					},
+				},
+			},
+			cli.Command{
+				Name:   "sam2pairs",
+				Usage:  "Convert aligned Hi-C read pairs to the 4DN .pairs format.",
+				Action: sam2pairsCommand,
+				Flags: append([]cli.Flag{
+					cli.StringFlag{
+						Name:  "sam",
+						Value: "",
+						Usage: "Path to SAM or BAM file of aligned reads.",
+					},
+					cli.StringFlag{
+						Name:  "output",
+						Value: "",
+						Usage: "The destination path to which to write the pairs, BGZF compressed if it ends in .gz.",
+					},
+					cli.BoolFlag{
+						Name:  "withSeq",
+						Usage: "Include the CIGAR and sequence of each read, as required to phase from .pairs input.",
+					},
+				}, PairFilterFlags()...),
+			},
+			cli.Command{
+				Name:   "hicqc",
+				Usage:  "Classify Hi-C read pairs as valid ligation products, dangling ends, self-circles or religations, counting duplicates among the valid pairs only.",
+				Action: hicqcCommand,
+				Flags: append([]cli.Flag{
+					cli.StringFlag{
+						Name:  "sam",
+						Value: "",
+						Usage: "Path to SAM, BAM or .pairs file of aligned reads.",
+					},
+					cli.StringFlag{
+						Name:  "fasta",
+						Value: "",
+						Usage: "Path to the reference fasta to which the reads were aligned.",
+					},
+					cli.StringFlag{
+						Name:  "enzyme",
+						Value: "",
+						Usage: "Restriction enzyme, one of HindIII, DpnII, MboI, NcoI or Arima, or sites such as ^GATC,G^ANTC.",
 					},
 					cli.StringFlag{
 						Name:  "output",
 						Value: "",
-						Usage: "Path to which to write aligned reads.",
+						Usage: "Path to which to write per-class pair counts, standard output if not given.",
+					},
+					cli.StringFlag{
+						Name:  "links",
+						Value: "",
+						Usage: "Path to which to write contig links built from valid pairs only, in the binary links format if it has the extension .lxyb.",
+					},
+					cli.IntFlag{
+						Name:  "threads",
+						Value: runtime.NumCPU(),
+						Usage: "Number of threads with which to tabulate links from read pairs.",
 					},
+				}, PairFilterFlags()...),
+			},
+			cli.Command{
+				Name:   "index",
+				Usage:  "Index a reference sequence for alignment.",
+				Action: indexCommand,
+				Flags: []cli.Flag{
 					cli.StringFlag{
 						Name:  "ref",
 						Value: "",
-						Usage: "Indexed reference sequence.",
+						Usage: "Reference sequence to index.",
+					},
+					cli.StringFlag{
+						Name:  "aligner",
+						Value: "bwa",
+						Usage: "The aligner for which to index the reference, one of " + strings.Join(AlignerNames, ", ") + ".",
 					},
 				},
 			},
@@ -193,92 +483,422 @@ func SeqCommand() cli.Command {
 func alignCommand(c *cli.Context) {
 
 	// Check that the inputs exist
-	if len(c.String("input")) == 0 {
-		fmt.Printf("error: must provide a fastq of reads to align to the reference with --input\n")
+	if len(c.String("r1")) == 0 || len(c.String("ref")) == 0 {
+		fmt.Printf("error: must provide reads to align with --r1 and a reference with --ref\n")
+		return
+	}
+	for _, path := range []string{c.String("r1"), c.String("r2"), c.String("ref")} {
+		if _, err := os.Stat(path); len(path) > 0 && os.IsNotExist(err) {
+			fmt.Printf("error: the provided input file does not exist: %s\n", path)
+			return
+		}
+	}
+	if len(c.String("sam")) == 0 && len(c.String("links")) == 0 {
+		fmt.Printf("error: must provide --sam, --links or both\n")
 		return
 	}
 
-	if _, err := os.Stat(c.String("input")); os.IsNotExist(err) {
-		fmt.Printf("error: the provided input reads file does not exist: %s\n", c.String("input"))
+	filter, err := PairFilterFromContext(c)
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		return
+	}
+	aligner, err := NewAligner(c.String("aligner"))
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
 		return
 	}
 
-	if len(c.String("ref")) == 0 {
-		fmt.Printf("error: must provide a reference to which to align with --ref\n")
+	aligned, err := AlignHiC(AlignOptions{
+		Aligner: aligner,
+		Ref:     c.String("ref"),
+		R1:      c.String("r1"),
+		R2:      c.String("r2"),
+		Threads: c.Int("threads"),
+		Mode:    c.String("mode"),
+	})
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
 		return
 	}
 
-	if _, err := os.Stat(c.String("ref")); os.IsNotExist(err) {
-		fmt.Printf("error: the specified reference assembly does not exist: %s\n", c.String("ref"))
+	// The alignments are written as they are streamed to the link builder.
+	in := aligned
+	var out AlignmentWriter
+	if len(c.String("sam")) > 0 {
+		if out, err = CreateAlignments(c.String("sam"), aligned.Header()); err != nil {
+			aligned.Close()
+			fmt.Printf("Couldn't open output file (%s) for writing: %s\n", c.String("sam"), err)
+			return
+		}
+		in = TeeAlignments(aligned, out)
+	}
+
+	if len(c.String("links")) > 0 {
+		refs := RefDictFromHeader(aligned.Header())
+		refs.Source = c.String("ref")
+		err = ScaffoldLinksFromAlignments(in, c.String("links"), refs, filter, c.Int("threads"))
+	} else {
+		for in.Scan() {
+		}
+		err = in.Err()
+	}
+	if e := aligned.Close(); err == nil {
+		err = e
+	}
+	if out != nil {
+		if e := out.Close(); err == nil {
+			err = e
+		}
+	}
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+	}
+
+}
+
+func indexCommand(c *cli.Context) {
+
+	if len(c.String("ref")) == 0 {
+		fmt.Printf("error: must provide a reference to index with --ref\n")
 		return
 	}
 
-	// If the reference assembly has not yet been indexed, index it.
-	if _, err := os.Stat(c.String("ref") + ".ann"); os.IsNotExist(err) {
-		fmt.Printf("error: the reference assembly is not indexed, attempting to do so now...")
-		indexCommand(c)
+	aligner, err := NewAligner(c.String("aligner"))
+	if err == nil {
+		_, err = aligner.Version()
+	}
+	if err == nil {
+		err = aligner.Index(c.String("ref"))
+	}
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
 	}
 
-	// Command string for the following
-	// bwa mem -t 1 path/to/ref/stem.fa path/to/reads.fq > out.sam
-	cmd := exec.Command("bwa", "mem", "-t4", c.String("ref"), c.String("input"))
+}
+
+func partitionCommand(c *cli.Context) {
+	Partition(c.String("fasta"), c.String("output"), c.Int("windowsize"))
+}
+
+func maskCommand(c *cli.Context) {
+	vcf, _, _ := ReadVariants(c.String("vcf"))
+	Mask(c.String("fasta"), c.String("output"), vcf)
+}
 
-	outfile, err := os.Create(c.String("output"))
+func faidxCommand(c *cli.Context) {
+
+	if len(c.String("fasta")) == 0 {
+		fmt.Printf("error: must provide --fasta\n")
+		return
+	}
+
+	fr, err := OpenFasta(c.String("fasta"))
 	if err != nil {
-		log.Fatal(err)
+		fmt.Printf("error: %s\n", err)
+		return
+	}
+	defer fr.Close()
+	if len(c.String("region")) == 0 {
+		return
 	}
-	defer outfile.Close()
-	cmd.Stdout = outfile
-	cmd.Stderr = os.Stderr
 
-	err2 := cmd.Start()
-	if err2 != nil {
-		log.Fatal(err2)
+	contig, start, end, err := ParseRegion(c.String("region"))
+	if err == nil && end < 0 {
+		end, _ = fr.Length(contig)
+	}
+	var seq string
+	if err == nil {
+		seq, err = fr.Fetch(contig, start, end)
+	}
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		return
 	}
 
-	log.Debug("Performing sequence alignment with BWA...")
-	err3 := cmd.Wait()
-	if err3 != nil {
-		log.Fatal("An error occurred during sequence alignment: %v", err3)
+	out := io.Writer(os.Stdout)
+	if len(c.String("output")) > 0 {
+		f, err := CreateOutput(c.String("output"))
+		if err != nil {
+			fmt.Printf("Couldn't open output file (%s) for writing: %s\n", c.String("output"), err)
+			return
+		}
+		defer closeOutput(f, c.String("output"))
+		out = f
+	}
+	fmt.Fprintf(out, ">%s\n", c.String("region"))
+	for i := 0; i < len(seq); i += 60 {
+		j := i + 60
+		if j > len(seq) {
+			j = len(seq)
+		}
+		fmt.Fprintf(out, "%s\n", seq[i:j])
 	}
 
 }
 
-func indexCommand(c *cli.Context) {
+func sortCommand(c *cli.Context) {
 
-	// Check that the input exists
-	if len(c.String("ref")) == 0 {
-		fmt.Printf("error: must provide a reference to which to align with --ref\n")
+	if len(c.String("input")) == 0 || len(c.String("output")) == 0 {
+		fmt.Printf("error: must provide --input and --output\n")
+		return
+	}
+
+	if err := SortAlignmentsByName(c.String("input"), c.String("output"), c.Int("mem")*1024*1024, c.String("tmpdir")); err != nil {
+		fmt.Printf("error: %s\n", err)
+	}
+
+}
+
+func statsCommand(c *cli.Context) {
+
+	if len(c.String("sam")) == 0 {
+		fmt.Printf("error: must provide --sam\n")
+		return
+	}
+
+	stats, err := AlignmentStatsFromSam(c.String("sam"))
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
 		return
 	}
 
-	if _, err := os.Stat(c.String("ref")); os.IsNotExist(err) {
-		fmt.Printf("error: the specified reference assembly does not exist: %s\n", c.String("ref"))
+	out := io.Writer(os.Stdout)
+	if len(c.String("output")) > 0 {
+		f, err := CreateOutput(c.String("output"))
+		if err != nil {
+			fmt.Printf("Couldn't open output file (%s) for writing: %s\n", c.String("output"), err)
+			return
+		}
+		defer closeOutput(f, c.String("output"))
+		out = f
+	}
+	if err := stats.WriteTSV(out); err != nil {
+		fmt.Printf("error: %s\n", err)
 		return
 	}
 
-	// Construct the command
-	commandString := "bwa index " + c.String("ref")
+	if len(c.String("json")) > 0 {
+		f, err := CreateOutput(c.String("json"))
+		if err != nil {
+			fmt.Printf("Couldn't open output file (%s) for writing: %s\n", c.String("json"), err)
+			return
+		}
+		defer closeOutput(f, c.String("json"))
+		if err := stats.WriteJSON(f); err != nil {
+			fmt.Printf("error: %s\n", err)
+		}
+	}
+
+}
+
+func decayCommand(c *cli.Context) {
 
-	// Exec the command and handle various outcomes
-	cmd := exec.Command(commandString)
-	err := cmd.Start()
+	if len(c.String("sam")) == 0 {
+		fmt.Printf("error: must provide --sam\n")
+		return
+	}
+
+	filter, err := PairFilterFromContext(c)
 	if err != nil {
-		log.Fatal(err)
+		fmt.Printf("error: %s\n", err)
+		return
 	}
-	log.Debug("Indexing reference assembly...")
-	err = cmd.Wait()
+
+	curve, err := DecayFromSam(c.String("sam"), filter, c.Int("binsPerDecade"), c.Int("fitMin"), c.Int("fitMax"))
 	if err != nil {
-		log.Fatal("An error occurred during reference indexing: %v", err)
+		fmt.Printf("error: %s\n", err)
+		return
+	}
+	fmt.Print(filter.Summary())
+	fmt.Printf("Fit P(s) ~ s^%f to %d intra-contig pairs\n", curve.Exponent, curve.Pairs)
+
+	out := io.Writer(os.Stdout)
+	if len(c.String("output")) > 0 {
+		f, err := CreateOutput(c.String("output"))
+		if err != nil {
+			fmt.Printf("Couldn't open output file (%s) for writing: %s\n", c.String("output"), err)
+			return
+		}
+		defer closeOutput(f, c.String("output"))
+		out = f
+	}
+	if err := curve.Write(out); err != nil {
+		fmt.Printf("error: %s\n", err)
 	}
 
 }
 
-func partitionCommand(c *cli.Context) {
-	Partition(c.String("fasta"), c.String("output"), c.Int("windowsize"))
+func trimCommand(c *cli.Context) {
+
+	for _, flag := range []string{"fastq", "output", "enzyme"} {
+		if len(c.String(flag)) == 0 {
+			fmt.Printf("error: must provide --%s\n", flag)
+			return
+		}
+	}
+	if (len(c.String("fastq2")) == 0) != (len(c.String("output2")) == 0) {
+		fmt.Printf("error: must provide both or neither of --fastq2 and --output2\n")
+		return
+	}
+
+	enzyme, err := ParseEnzyme(c.String("enzyme"))
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		return
+	}
+
+	stats := NewTrimStats()
+	if err := TrimFastq(c.String("fastq"), c.String("output"), enzyme, stats); err != nil {
+		fmt.Printf("error: %s\n", err)
+		return
+	}
+	if len(c.String("fastq2")) > 0 {
+		if err := TrimFastq(c.String("fastq2"), c.String("output2"), enzyme, stats); err != nil {
+			fmt.Printf("error: %s\n", err)
+			return
+		}
+	}
+
+	out := io.Writer(os.Stdout)
+	if len(c.String("report")) > 0 {
+		f, err := CreateOutput(c.String("report"))
+		if err != nil {
+			fmt.Printf("Couldn't open output file (%s) for writing: %s\n", c.String("report"), err)
+			return
+		}
+		defer closeOutput(f, c.String("report"))
+		out = f
+	}
+	stats.Write(out, enzyme)
+
 }
 
-func maskCommand(c *cli.Context) {
-	vcf, _, _ := ReadVariants(c.String("vcf"))
-	Mask(c.String("fasta"), c.String("output"), vcf)
+func interleaveCommand(c *cli.Context) {
+
+	if len(c.String("r1")) == 0 || len(c.String("r2")) == 0 || len(c.String("output")) == 0 {
+		fmt.Printf("error: must provide --r1, --r2 and --output\n")
+		return
+	}
+
+	if err := InterleaveFastq(c.String("r1"), c.String("r2"), c.String("output")); err != nil {
+		fmt.Printf("error: %s\n", err)
+	}
+
+}
+
+func deinterleaveCommand(c *cli.Context) {
+
+	if len(c.String("input")) == 0 || len(c.String("r1")) == 0 || len(c.String("r2")) == 0 {
+		fmt.Printf("error: must provide --input, --r1 and --r2\n")
+		return
+	}
+
+	if err := DeinterleaveFastq(c.String("input"), c.String("r1"), c.String("r2")); err != nil {
+		fmt.Printf("error: %s\n", err)
+	}
+
+}
+
+func splitCommand(c *cli.Context) {
+
+	if len(c.String("input")) == 0 || len(c.String("outstem")) == 0 {
+		fmt.Printf("error: must provide --input and --outstem\n")
+		return
+	}
+
+	var groups map[string]string
+	if len(c.String("groups")) > 0 {
+		g, err := ReadGroups(c.String("groups"))
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			return
+		}
+		groups = g
+	}
+
+	if err := PartitionAlignments(c.String("input"), c.String("outstem"), groups, c.Int("maxOpenFiles")); err != nil {
+		fmt.Printf("error: %s\n", err)
+	}
+
+}
+
+func sam2pairsCommand(c *cli.Context) {
+
+	if len(c.String("sam")) == 0 || len(c.String("output")) == 0 {
+		fmt.Printf("error: must provide --sam and --output\n")
+		return
+	}
+
+	filter, err := PairFilterFromContext(c)
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		return
+	}
+
+	if err := SamToPairs(c.String("sam"), c.String("output"), filter, c.Bool("withSeq")); err != nil {
+		fmt.Printf("error: %s\n", err)
+		return
+	}
+	fmt.Print(filter.Summary())
+
+}
+
+func hicqcCommand(c *cli.Context) {
+
+	for _, flag := range []string{"sam", "fasta", "enzyme"} {
+		if len(c.String(flag)) == 0 {
+			fmt.Printf("error: must provide --%s\n", flag)
+			return
+		}
+	}
+
+	enzyme, err := ParseEnzyme(c.String("enzyme"))
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		return
+	}
+
+	filter, err := PairFilterFromContext(c)
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		return
+	}
+
+	fmt.Printf("Digesting %s with %s...\n", c.String("fasta"), enzyme.Name)
+	digest, err := DigestFasta(c.String("fasta"), enzyme)
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		return
+	}
+
+	// Classify pairs as part of building links if links were requested, with the
+	// classifier ahead of the Deduplicator, last in the filter chain, so that only valid
+	// pairs are linked and the duplication rate is that of the valid pairs.
+	qc := NewHiCQC(digest)
+	if len(c.String("links")) > 0 {
+		filter.AddBeforeDuplicates(qc)
+		if err := ScaffoldLinksFromSam(c.String("sam"), c.String("links"), nil, filter, c.Int("threads")); err != nil {
+			fmt.Printf("error: %s\n", err)
+			return
+		}
+	} else if err := ClassifyPairsFromSam(c.String("sam"), qc, filter); err != nil {
+		fmt.Printf("error: %s\n", err)
+		return
+	}
+
+	out := io.Writer(os.Stdout)
+	if len(c.String("output")) > 0 {
+		f, err := CreateOutput(c.String("output"))
+		if err != nil {
+			fmt.Printf("Couldn't open output file (%s) for writing: %s\n", c.String("output"), err)
+			return
+		}
+		defer closeOutput(f, c.String("output"))
+		out = f
+	}
+	if err := qc.Write(out); err != nil {
+		fmt.Printf("error: %s\n", err)
+	}
+
 }
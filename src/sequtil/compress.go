@@ -0,0 +1,107 @@
+package util
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// gzipMagic is the two byte magic number that begins every gzip member, including
+// the blocks of a BGZF file.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// inputFile is a file opened for reading whose content is transparently decompressed
+// if it was gzip or BGZF compressed.
+type inputFile struct {
+	*bufio.Reader
+	f  *os.File
+	gz *gzip.Reader
+}
+
+// Close closes the decompressor, if any, and the underlying file.
+func (in *inputFile) Close() error {
+	if in.gz != nil {
+		in.gz.Close()
+	}
+	return in.f.Close()
+}
+
+// openInput opens a file for reading, sniffing its first bytes for the gzip magic
+// number and decompressing on the fly if it is found. BGZF files, being a series of
+// gzip members, are handled the same way.
+func openInput(path string) (*inputFile, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't open input file (%s) for reading: %s", path, err)
+	}
+
+	in := &inputFile{Reader: bufio.NewReader(f), f: f}
+	if magic, _ := in.Peek(len(gzipMagic)); string(magic) == string(gzipMagic) {
+		in.gz, err = gzip.NewReader(in.Reader)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("Couldn't decompress input file (%s): %s", path, err)
+		}
+		in.Reader = bufio.NewReader(in.gz)
+	}
+
+	return in, nil
+
+}
+
+// OpenInput opens a plain, gzip or BGZF compressed file for reading, returning a reader
+// over the decompressed content regardless of how the file was stored on disk.
+func OpenInput(path string) (io.ReadCloser, error) {
+	return openInput(path)
+}
+
+// outputFile is a file opened for writing whose content is BGZF compressed on the fly.
+type outputFile struct {
+	f    *os.File
+	bgzf *bgzfWriter
+}
+
+// Write compresses p into the output file.
+func (out *outputFile) Write(p []byte) (int, error) {
+	return out.bgzf.Write(p)
+}
+
+// Close flushes the final compressed block and closes the underlying file.
+func (out *outputFile) Close() error {
+	if err := out.bgzf.Close(); err != nil {
+		out.f.Close()
+		return err
+	}
+	return out.f.Close()
+}
+
+// CreateOutput creates a file for writing, compressing its content if the path ends
+// in ".gz" or ".bgz". Compressed output is written as BGZF, which any gzip reader can
+// decompress and which tabix and samtools can additionally index. Closing compressed
+// output flushes its final block, so the error from Close must be checked.
+func CreateOutput(path string) (io.WriteCloser, error) {
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasSuffix(path, ".gz") || strings.HasSuffix(path, ".bgz") {
+		return &outputFile{f, newBGZFWriter(f)}, nil
+	}
+
+	return f, nil
+
+}
+
+// closeOutput closes a file created by CreateOutput, reporting any error, such as in
+// flushing the final compressed block, for the commands which write to it.
+func closeOutput(out io.Closer, path string) {
+	if err := out.Close(); err != nil {
+		fmt.Printf("Couldn't close output file (%s): %s\n", path, err)
+	}
+}
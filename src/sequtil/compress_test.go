@@ -0,0 +1,76 @@
+package util
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompressedIO(t *testing.T) {
+
+	content := strings.Repeat("chr1 chr2 2.000000\n", 10000)
+
+	for _, path := range []string{"/tmp/lxy/test/testio.txt", "/tmp/lxy/test/testio.txt.gz"} {
+
+		MkdirForFile(path)
+		out, err := CreateOutput(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out.Write([]byte(content))
+		if err := out.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		in, err := OpenInput(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		read, err := ioutil.ReadAll(in)
+		in.Close()
+		if err != nil {
+			t.Error(err)
+		}
+		if string(read) != content {
+			t.Errorf("content read back from %s does not match that written", path)
+		}
+
+		os.Remove(path)
+
+	}
+
+}
+
+func TestOpenInputGzip(t *testing.T) {
+
+	// A file written by a standard gzip writer, rather than as BGZF, should also be
+	// transparently decompressed.
+	path := "/tmp/lxy/test/testgzip.links.gz"
+	MkdirForFile(path)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	gz.Write([]byte("chr1 chr2 2\nchr2 chr3 1\n"))
+	gz.Close()
+	f.Close()
+
+	links, err := LoadLinks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	linksKey := NewLinks()
+	linksKey.Set(linksKey.ID("chr1"), linksKey.ID("chr2"), 2)
+	linksKey.Set(linksKey.ID("chr2"), linksKey.ID("chr3"), 1)
+
+	if !reflect.DeepEqual(links, linksKey) {
+		t.Errorf("LoadLinks(%s) yielded links object not matching key", path)
+	}
+
+	os.Remove(path)
+
+}
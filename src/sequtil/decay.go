@@ -0,0 +1,221 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// DecayBin is a bin of the contact probability decay curve, see DecayCurve, holding the
+// intra-contig pairs with distances between the 5' ends of their reads in [Min, Max).
+type DecayBin struct {
+	Min         int
+	Max         int
+	Pairs       int
+	Probability float64
+}
+
+// DecayCurve is the contact probability P(s) of a Hi-C library as a function of genomic
+// distance s, estimated from intra-contig pairs in logarithmically spaced bins of
+// distance, along with the exponent of the power law P(s) ~ s^Exponent fit to the
+// curve, which is typically near -1.
+type DecayCurve struct {
+	Bins          []DecayBin
+	Exponent      float64
+	Intercept     float64
+	FitMin        int
+	FitMax        int
+	Pairs         int
+	binsPerDecade int
+	counts        map[int]int
+}
+
+// NewDecayCurve constructs an empty DecayCurve with bins of distance spaced evenly on a
+// log scale, binsPerDecade to each factor of ten.
+func NewDecayCurve(binsPerDecade int) *DecayCurve {
+	if binsPerDecade < 1 {
+		binsPerDecade = 1
+	}
+	return &DecayCurve{binsPerDecade: binsPerDecade, counts: map[int]int{}}
+}
+
+// binStart returns the smallest integer distance in a bin of the curve, bin i holding
+// distances in [10^(i/binsPerDecade), 10^((i+1)/binsPerDecade)).
+func (d *DecayCurve) binStart(i int) int {
+	return int(math.Ceil(math.Pow(10, float64(i)/float64(d.binsPerDecade)) - 1e-9))
+}
+
+// bin returns the index of the bin holding a distance of at least one.
+func (d *DecayCurve) bin(s int) int {
+	i := int(math.Floor(math.Log10(float64(s)) * float64(d.binsPerDecade)))
+	for i > 0 && s < d.binStart(i) {
+		i -= 1
+	}
+	for s >= d.binStart(i+1) {
+		i += 1
+	}
+	return i
+}
+
+// Add counts an intra-contig pair with the specified distance between the 5' ends of
+// its reads. Pairs at a distance of zero are disregarded, there being no such bin on a
+// log scale.
+func (d *DecayCurve) Add(s int) {
+	if s < 1 {
+		return
+	}
+	d.counts[d.bin(s)] += 1
+	d.Pairs += 1
+}
+
+// opportunities returns the number of pairs of positions on contigs of the specified
+// lengths separated by distances in [lo, hi), by which the pairs counted in a bin are
+// divided to estimate the contact probability. With no contig lengths, each distance
+// is taken to be equally likely to be observed.
+func opportunities(lengths []int, lo, hi int) float64 {
+
+	if len(lengths) == 0 {
+		return float64(hi - lo)
+	}
+
+	total := 0.0
+	for _, l := range lengths {
+		end := hi
+		if l < end {
+			end = l
+		}
+		if end <= lo {
+			continue
+		}
+		n := float64(end - lo)
+		total += n*float64(l) - (float64(lo)+float64(end-1))*n/2
+	}
+	return total
+
+}
+
+// Estimate computes the contact probability of each bin from the pairs counted, given
+// the lengths of the contigs from which they were drawn, and fits a power law to the
+// bins with distances in [fitMin, fitMax], fitMax less than one meaning no upper limit.
+//
+// The probability of a bin is the number of its pairs per pair of positions at its
+// distances, normalized such that the probability summed over all distances is one.
+// The power law is fit by least squares to the logarithms of the probability and the
+// geometric midpoint of each bin with pairs.
+func (d *DecayCurve) Estimate(lengths []int, fitMin, fitMax int) error {
+
+	d.FitMin, d.FitMax = fitMin, fitMax
+	d.Bins = []DecayBin{}
+	if len(d.counts) == 0 {
+		return fmt.Errorf("Couldn't estimate contact probability decay, no intra-contig pairs were counted")
+	}
+
+	last := 0
+	for i := range d.counts {
+		if i > last {
+			last = i
+		}
+	}
+
+	total := 0.0
+	for i := 0; i <= last; i++ {
+		lo, hi := d.binStart(i), d.binStart(i+1)
+		if lo == hi {
+			continue
+		}
+		b := DecayBin{lo, hi, d.counts[i], 0}
+		if o := opportunities(lengths, lo, hi); o > 0 {
+			b.Probability = float64(b.Pairs) / o
+		}
+		total += b.Probability * float64(hi-lo)
+		d.Bins = append(d.Bins, b)
+	}
+
+	xs, ys := []float64{}, []float64{}
+	for i := range d.Bins {
+		b := &d.Bins[i]
+		if total > 0 {
+			b.Probability /= total
+		}
+		if b.Pairs == 0 || b.Probability <= 0 || b.Min < fitMin || (fitMax > 0 && b.Max-1 > fitMax) {
+			continue
+		}
+		xs = append(xs, math.Log10(math.Sqrt(float64(b.Min)*float64(b.Max-1))))
+		ys = append(ys, math.Log10(b.Probability))
+	}
+
+	if len(xs) < 2 {
+		return fmt.Errorf("Couldn't fit a power law to contact probability decay, %d bins with pairs in the fit range", len(xs))
+	}
+
+	mx, my := 0.0, 0.0
+	for i := range xs {
+		mx += xs[i]
+		my += ys[i]
+	}
+	mx /= float64(len(xs))
+	my /= float64(len(xs))
+	sxy, sxx := 0.0, 0.0
+	for i := range xs {
+		sxy += (xs[i] - mx) * (ys[i] - my)
+		sxx += (xs[i] - mx) * (xs[i] - mx)
+	}
+	if sxx == 0 {
+		return fmt.Errorf("Couldn't fit a power law to contact probability decay, all bins in the fit range are at the same distance")
+	}
+	d.Exponent = sxy / sxx
+	d.Intercept = my - d.Exponent*mx
+
+	return nil
+
+}
+
+// Write writes the curve as tab-delimited min, max, pairs and probability columns,
+// preceded by comment lines giving the fitted power law.
+func (d *DecayCurve) Write(out io.Writer) error {
+
+	fitMax := "inf"
+	if d.FitMax > 0 {
+		fitMax = fmt.Sprintf("%d", d.FitMax)
+	}
+	if _, err := fmt.Fprintf(out, "# exponent\t%f\n# intercept\t%f\n# fit range\t%d-%s\n# pairs\t%d\nmin\tmax\tpairs\tprobability\n", d.Exponent, d.Intercept, d.FitMin, fitMax, d.Pairs); err != nil {
+		return err
+	}
+	for _, b := range d.Bins {
+		if _, err := fmt.Fprintf(out, "%d\t%d\t%d\t%g\n", b.Min, b.Max, b.Pairs, b.Probability); err != nil {
+			return err
+		}
+	}
+	return nil
+
+}
+
+// DecayFromSam tabulates the distances between the 5' ends of the reads of the
+// intra-contig pairs of an alignments file passing the filter, which may be nil, in a
+// DecayCurve, see NewDecayCurve, and estimates the curve given the contig lengths of
+// the @SQ lines of the header, see DecayCurve.Estimate.
+func DecayFromSam(samPath string, filter *PairFilterChain, binsPerDecade, fitMin, fitMax int) (*DecayCurve, error) {
+
+	in, err := openGroupedAlignments(samPath)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	d := NewDecayCurve(binsPerDecade)
+	pairs := newPairScanner(in)
+	for pairs.Scan() {
+		a1, a2 := pairs.Pair()
+		if filter.Accept(a1, a2) && a1.rname == a2.rname {
+			d.Add(abs(fivePrime(a1) - fivePrime(a2)))
+		}
+	}
+	if err := in.Err(); err != nil {
+		return nil, err
+	}
+	fmt.Print(pairs.Summary())
+
+	_, lengths := bamRefs(in.Header())
+	return d, d.Estimate(lengths, fitMin, fitMax)
+
+}
@@ -0,0 +1,53 @@
+package util
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestDecayCurve(t *testing.T) {
+
+	d := NewDecayCurve(10)
+	for s, bin := range map[int]int{1: 0, 7: 8, 8: 9, 9: 9, 10: 10, 99: 19, 100: 20, 1000: 30} {
+		if b := d.bin(s); b != bin {
+			t.Errorf("DecayCurve.bin(%d) yielded %d, expected %d", s, b, bin)
+		}
+	}
+
+	// There are 9 pairs of positions 1 apart and 8 pairs 2 apart on a contig of length 10.
+	if o := opportunities([]int{10, 1}, 1, 3); o != 17 {
+		t.Errorf("opportunities() yielded %f, expected 17", o)
+	}
+
+	// Pairs at each distance in proportion to its inverse follow a power law with an
+	// exponent of -1.
+	for s := 1; s <= 10000; s++ {
+		for i := 0; i < 10000/s; i++ {
+			d.Add(s)
+		}
+	}
+	d.Add(0)
+	if err := d.Estimate(nil, 10, 5000); err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(d.Exponent+1) > 0.05 {
+		t.Errorf("DecayCurve.Estimate() fit exponent %f, expected -1", d.Exponent)
+	}
+
+	sum := 0.0
+	for _, b := range d.Bins {
+		sum += b.Probability * float64(b.Max-b.Min)
+	}
+	if math.Abs(sum-1) > 1e-9 {
+		t.Errorf("DecayCurve.Estimate() yielded probabilities summing to %f, expected 1", sum)
+	}
+
+	var out bytes.Buffer
+	d.Write(&out)
+	if !strings.Contains(out.String(), "min\tmax\tpairs\tprobability\n1\t2\t10000\t") {
+		t.Errorf("DecayCurve.Write() wrote:\n%s", out.String())
+	}
+
+}
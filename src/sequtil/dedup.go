@@ -0,0 +1,125 @@
+package util
+
+import (
+	"fmt"
+)
+
+// pairEnd is one end of a read pair as used to identify duplicates, the reference, 5'
+// position and strand of the read.
+type pairEnd struct {
+	rname   string
+	pos     int
+	reverse bool
+}
+
+// less orders pair ends by reference, position and then strand.
+func (e pairEnd) less(o pairEnd) bool {
+	if e.rname != o.rname {
+		return e.rname < o.rname
+	}
+	if e.pos != o.pos {
+		return e.pos < o.pos
+	}
+	return !e.reverse && o.reverse
+}
+
+// dupBucket identifies the pairs whose ends are on the same references and strands and
+// whose positions fall within the same windows of the duplicate position tolerance.
+type dupBucket struct {
+	rname1, rname2     string
+	reverse1, reverse2 bool
+	bin1, bin2         int
+}
+
+// Deduplicator is a PairFilter rejecting PCR and optical duplicate read pairs, those
+// whose two ends share a reference, strand and 5' position with those of a pair seen
+// previously. The order of the two reads of a pair is disregarded.
+//
+// With a tolerance greater than zero, the 5' positions of the ends of a duplicate pair
+// may each differ by up to that many bases, as is typical of optical duplicates and
+// of reads trimmed by differing amounts.
+//
+// As the input of the link builders is sorted by qname, duplicates are not adjacent
+// and the ends of every pair seen are held in memory.
+type Deduplicator struct {
+	tolerance int
+	seen      map[dupBucket][][2]int
+	pairs     int
+	dups      int
+}
+
+// NewDeduplicator constructs a Deduplicator with the specified position tolerance.
+func NewDeduplicator(tolerance int) *Deduplicator {
+	if tolerance < 0 {
+		tolerance = 0
+	}
+	return &Deduplicator{tolerance, map[dupBucket][][2]int{}, 0, 0}
+}
+
+// ends returns the ends of a pair in canonical order.
+func (d *Deduplicator) ends(a1, a2 Alignment) (pairEnd, pairEnd) {
+	e1 := pairEnd{a1.rname, fivePrime(a1), a1.flag.reversecomp}
+	e2 := pairEnd{a2.rname, fivePrime(a2), a2.flag.reversecomp}
+	if e2.less(e1) {
+		return e2, e1
+	}
+	return e1, e2
+}
+
+// Accept returns false if the pair duplicates one previously accepted, recording it as
+// seen otherwise.
+func (d *Deduplicator) Accept(a1, a2 Alignment) bool {
+
+	d.pairs += 1
+	e1, e2 := d.ends(a1, a2)
+
+	// Positions within the tolerance of one another are in the same or adjacent bins.
+	width := d.tolerance + 1
+	key := dupBucket{e1.rname, e2.rname, e1.reverse, e2.reverse, e1.pos / width, e2.pos / width}
+	for i := -1; i <= 1; i++ {
+		for j := -1; j <= 1; j++ {
+			if d.tolerance == 0 && (i != 0 || j != 0) {
+				continue
+			}
+			near := key
+			near.bin1 += i
+			near.bin2 += j
+			for _, p := range d.seen[near] {
+				if abs(p[0]-e1.pos) <= d.tolerance && abs(p[1]-e2.pos) <= d.tolerance {
+					d.dups += 1
+					return false
+				}
+			}
+		}
+	}
+
+	d.seen[key] = append(d.seen[key], [2]int{e1.pos, e2.pos})
+	return true
+
+}
+
+// Name describes the pairs rejected by the Deduplicator.
+func (d *Deduplicator) Name() string {
+	return "duplicate"
+}
+
+// Rate returns the fraction of the pairs seen that were duplicates.
+func (d *Deduplicator) Rate() float64 {
+	if d.pairs == 0 {
+		return 0
+	}
+	return float64(d.dups) / float64(d.pairs)
+}
+
+// Summary reports the duplication rate, see PairFilterChain.Summary.
+func (d *Deduplicator) Summary() string {
+	return fmt.Sprintf("duplication rate %f (%d of %d pairs)\n", d.Rate(), d.dups, d.pairs)
+}
+
+// abs returns the absolute value of an integer.
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
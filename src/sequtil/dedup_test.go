@@ -0,0 +1,100 @@
+package util
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDeduplicator(t *testing.T) {
+
+	read := func(rname string, pos, flag int) Alignment {
+		return Alignment{"readid", newSAMFlag(flag), rname, pos, 60, CIGAR{CIGARCode{10, "M"}}, "*", 0, 0, "ACGTACGTAC", "*", nil}
+	}
+
+	// A clipped read on the reverse strand sharing its 5' end with an unclipped read
+	clipped := read("chr1", 105, 16)
+	clipped.cigar = CIGAR{CIGARCode{5, "M"}, CIGARCode{5, "S"}}
+
+	pairs := []struct {
+		a1, a2 Alignment
+		exact  bool // accepted with no tolerance
+		fuzzy  bool // accepted with a tolerance of 2
+	}{
+		{read("chr1", 100, 0), read("chr2", 500, 16), true, true},
+		{read("chr1", 100, 0), read("chr2", 500, 16), false, false}, // exact duplicate
+		{read("chr2", 500, 16), read("chr1", 100, 0), false, false}, // mates swapped
+		{read("chr1", 100, 16), read("chr2", 500, 16), true, true},  // other strand
+		{read("chr1", 102, 0), read("chr2", 499, 16), true, false},  // within tolerance
+		{read("chr1", 103, 0), read("chr2", 500, 16), true, true},   // beyond tolerance
+		{read("chr1", 101, 0), read("chr2", 498, 16), true, false},  // within tolerance of the last
+		{clipped, read("chr2", 500, 16), false, false},              // same 5' position (109) as the fourth pair
+		{read("chr1", 100, 0), read("chr3", 500, 16), true, true},
+	}
+
+	exact := NewDeduplicator(0)
+	fuzzy := NewDeduplicator(2)
+	for i, p := range pairs {
+		if exact.Accept(p.a1, p.a2) != p.exact {
+			t.Errorf("Deduplicator(0).Accept() of pair %d yielded %t, expected %t", i, !p.exact, p.exact)
+		}
+		if fuzzy.Accept(p.a1, p.a2) != p.fuzzy {
+			t.Errorf("Deduplicator(2).Accept() of pair %d yielded %t, expected %t", i, !p.fuzzy, p.fuzzy)
+		}
+	}
+
+	if exact.Rate() != 3.0/9.0 {
+		t.Errorf("Deduplicator(0).Rate() yielded %f, expected %f", exact.Rate(), 3.0/9.0)
+	}
+	if fuzzy.Rate() != 5.0/9.0 {
+		t.Errorf("Deduplicator(2).Rate() yielded %f, expected %f", fuzzy.Rate(), 5.0/9.0)
+	}
+
+}
+
+func TestScaffoldLinksFromSamDedup(t *testing.T) {
+
+	samPath := "/tmp/lxy/test/testdedup.sam"
+	outPath := "/tmp/lxy/test/testdedup.links"
+	MkdirForFile(samPath)
+
+	sam := []string{
+		"readid-1\t65\tchr1\t4\t60\t3M\tchr2\t200\t0\tATG\tIII",
+		"readid-1\t145\tchr2\t200\t60\t3M\tchr1\t4\t0\tGCG\tIII",
+		"readid-2\t65\tchr1\t4\t60\t3M\tchr2\t200\t0\tATG\tIII",
+		"readid-2\t145\tchr2\t200\t60\t3M\tchr1\t4\t0\tGCG\tIII",
+		"readid-3\t65\tchr1\t4\t60\t3M\tchr3\t200\t0\tATG\tIII",
+		"readid-3\t145\tchr3\t200\t60\t3M\tchr1\t4\t0\tGCG\tIII",
+		"readid-4\t65\tchr1\t4\t60\t3M\tchr3\t200\t0\tATG\tIII",
+		"readid-4\t145\tchr3\t200\t60\t3M\tchr1\t4\t0\tGCG\tIII",
+		"readid-5\t65\tchr1\t10\t60\t3M\tchr3\t200\t0\tATG\tIII",
+		"readid-5\t145\tchr3\t200\t60\t3M\tchr1\t10\t0\tGCG\tIII",
+		"readid-6\t65\tchr1\t50\t60\t3M\tchr3\t200\t0\tATG\tIII",
+		"readid-6\t145\tchr3\t200\t60\t3M\tchr1\t10\t0\tGCG\tIII",
+	}
+	f, err := os.Create(samPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(strings.Join(sam, "\n") + "\n")
+	f.Close()
+
+	dedup := NewDeduplicator(0)
+	if err := ScaffoldLinksFromSam(samPath, outPath, nil, NewPairFilterChain(dedup), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	links, _ := LoadLinks(outPath)
+	v12, _ := links.Get(links.ID("chr1"), links.ID("chr2"))
+	v13, _ := links.Get(links.ID("chr1"), links.ID("chr3"))
+	if v12 != 1 || v13 != 3 || links.Size() != 3 {
+		t.Errorf("ScaffoldLinksFromSam(%s, %s) with a Deduplicator yielded links chr1-chr2 %f and chr1-chr3 %f, expected 1 and 3", samPath, outPath, v12, v13)
+	}
+	if dedup.Rate() != 2.0/6.0 {
+		t.Errorf("Deduplicator.Rate() yielded %f, expected %f", dedup.Rate(), 2.0/6.0)
+	}
+
+	os.Remove(samPath)
+	os.Remove(outPath)
+
+}
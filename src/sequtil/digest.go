@@ -0,0 +1,199 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// RestrictionSite is a recognition motif of a restriction enzyme along with the offset
+// into the motif at which the enzyme cuts, e.g. A^AGCTT for HindIII is {"AAGCTT", 1}.
+// Motifs may contain N to match any base.
+type RestrictionSite struct {
+	Motif string
+	Cut   int
+}
+
+// Enzyme is a restriction enzyme, or a cocktail of enzymes as in the Arima kit, given by
+// the set of sites at which it cuts. All of the sites are assumed to be palindromic,
+// as is the case for the enzymes commonly used in Hi-C, such that only the forward
+// strand of the reference need be searched.
+type Enzyme struct {
+	Name  string
+	Sites []RestrictionSite
+}
+
+// Enzymes holds the restriction enzymes that can be referred to by name in ParseEnzyme.
+var Enzymes = map[string]Enzyme{
+	"hindiii": Enzyme{"HindIII", []RestrictionSite{{"AAGCTT", 1}}},
+	"dpnii":   Enzyme{"DpnII", []RestrictionSite{{"GATC", 0}}},
+	"mboi":    Enzyme{"MboI", []RestrictionSite{{"GATC", 0}}},
+	"ncoi":    Enzyme{"NcoI", []RestrictionSite{{"CCATGG", 1}}},
+	"arima":   Enzyme{"Arima", []RestrictionSite{{"GATC", 0}, {"GANTC", 1}}},
+}
+
+// ParseEnzyme returns the Enzyme with the specified name, e.g. HindIII, DpnII or Arima,
+// or otherwise parses a comma separated list of sites with the cut marked by a caret,
+// e.g. "^GATC,G^ANTC".
+func ParseEnzyme(spec string) (Enzyme, error) {
+
+	if e, ok := Enzymes[strings.ToLower(spec)]; ok {
+		return e, nil
+	}
+
+	e := Enzyme{Name: spec}
+	for _, s := range strings.Split(spec, ",") {
+		cut := strings.Index(s, "^")
+		motif := strings.ToUpper(strings.Replace(s, "^", "", 1))
+		if cut < 0 || len(motif) == 0 || strings.Trim(motif, "ACGTN") != "" {
+			return Enzyme{}, fmt.Errorf("Unrecognized enzyme or malformed restriction site: %s", s)
+		}
+		e.Sites = append(e.Sites, RestrictionSite{motif, cut})
+	}
+
+	return e, nil
+
+}
+
+// matches determines whether the motif of a restriction site occurs in seq at offset i.
+func (rs RestrictionSite) matches(seq string, i int) bool {
+	if i+len(rs.Motif) > len(seq) {
+		return false
+	}
+	for j := 0; j < len(rs.Motif); j++ {
+		if rs.Motif[j] != 'N' && rs.Motif[j] != seq[i+j] {
+			return false
+		}
+	}
+	return true
+}
+
+// Digest is an in-silico restriction digest of a reference assembly, storing for each
+// contig the sorted positions at which it is cut and thereby the restriction fragments
+// into which it is divided.
+type Digest struct {
+
+	// The name of the enzyme used to produce the digest
+	Enzyme string
+
+	// For each contig, the sorted 0-based offsets of the first base following each cut
+	cuts map[string][]int
+
+	// The length of each contig
+	lengths map[string]int
+}
+
+// DigestFasta performs an in-silico digest of a (possibly compressed) FASTA file with
+// the specified enzyme.
+func DigestFasta(fastaPath string, e Enzyme) (*Digest, error) {
+
+	in, err := OpenInput(fastaPath)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	d := &Digest{e.Name, map[string][]int{}, map[string]int{}}
+
+	maxLen := 0
+	for _, site := range e.Sites {
+		if len(site.Motif) > maxLen {
+			maxLen = len(site.Motif)
+		}
+	}
+
+	// Sites are searched for line by line, carrying over the tail of the previous line
+	// so that sites spanning a line break are found.
+	contig := ""
+	carry := ""
+	offset := 0
+	s := bufio.NewScanner(in)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for s.Scan() {
+		line := s.Text()
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] == '>' {
+			fields := strings.Fields(line[1:])
+			if len(fields) == 0 {
+				return nil, fmt.Errorf("Couldn't digest %s, which has a sequence header with no name", fastaPath)
+			}
+			contig = fields[0]
+			d.cuts[contig] = []int{}
+			d.lengths[contig] = 0
+			carry = ""
+			offset = 0
+			continue
+		}
+
+		buf := carry + strings.ToUpper(line)
+		for i := 0; i < len(buf); i++ {
+			for _, site := range e.Sites {
+				// Sites lying entirely within the carried over sequence were found
+				// when the previous line was searched.
+				if i+len(site.Motif) > len(carry) && site.matches(buf, i) {
+					d.cuts[contig] = append(d.cuts[contig], offset+i+site.Cut)
+				}
+			}
+		}
+		d.lengths[contig] += len(line)
+
+		if keep := maxLen - 1; len(buf) > keep {
+			offset += len(buf) - keep
+			carry = buf[len(buf)-keep:]
+		} else {
+			carry = buf
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	// The sites of a cocktail of enzymes are found out of order and may coincide.
+	for contig, cuts := range d.cuts {
+		sort.Ints(cuts)
+		uniq := []int{}
+		for i, c := range cuts {
+			if i == 0 || c != cuts[i-1] {
+				uniq = append(uniq, c)
+			}
+		}
+		d.cuts[contig] = uniq
+	}
+
+	return d, nil
+
+}
+
+// Fragment returns the index of the restriction fragment of a contig containing the
+// 1-based position pos, along with the 1-based start and end positions of the fragment.
+// The index is -1 if the contig is not present in the digest.
+func (d *Digest) Fragment(contig string, pos int) (int, int, int) {
+
+	cuts, ok := d.cuts[contig]
+	if !ok {
+		return -1, 0, 0
+	}
+
+	// The fragment index is the number of cuts preceding the 0-based offset pos-1
+	i := sort.SearchInts(cuts, pos)
+	start, end := 1, d.lengths[contig]
+	if i > 0 {
+		start = cuts[i-1] + 1
+	}
+	if i < len(cuts) {
+		end = cuts[i]
+	}
+	return i, start, end
+
+}
+
+// NumFragments returns the number of restriction fragments into which a contig is cut.
+func (d *Digest) NumFragments(contig string) int {
+	if _, ok := d.cuts[contig]; !ok {
+		return 0
+	}
+	return len(d.cuts[contig]) + 1
+}
@@ -0,0 +1,75 @@
+package util
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestDigestFasta(t *testing.T) {
+
+	path := "/tmp/lxy/test/testdigest.fa"
+	MkdirForFile(path)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A HindIII site within the first line and another spanning the line break
+	// between the second and third lines.
+	f.WriteString(">chr1 test\nACGTAAGCTTACGTACGTAC\nGTACGTAAGC\nTTACGTACGT\n>chr2\nacgtacgt\n>chr3\nGATCGAATCA\n")
+	f.Close()
+
+	d, err := DigestFasta(path, Enzymes["hindiii"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cutsKey := map[string][]int{"chr1": []int{5, 27}, "chr2": []int{}, "chr3": []int{}}
+	if !reflect.DeepEqual(d.cuts, cutsKey) {
+		t.Errorf("DigestFasta(%s, HindIII) yielded cuts %v, expected %v", path, d.cuts, cutsKey)
+	}
+
+	fragmentTests := []struct {
+		contig string
+		pos    int
+		frag   []int
+	}{
+		{"chr1", 1, []int{0, 1, 5}},
+		{"chr1", 5, []int{0, 1, 5}},
+		{"chr1", 6, []int{1, 6, 27}},
+		{"chr1", 28, []int{2, 28, 40}},
+		{"chr2", 3, []int{0, 1, 8}},
+		{"chr4", 3, []int{-1, 0, 0}},
+	}
+	for _, test := range fragmentTests {
+		i, start, end := d.Fragment(test.contig, test.pos)
+		if !reflect.DeepEqual([]int{i, start, end}, test.frag) {
+			t.Errorf("Digest.Fragment(%s, %d) yielded %v, expected %v", test.contig, test.pos, []int{i, start, end}, test.frag)
+		}
+	}
+
+	arima, err := ParseEnzyme("Arima")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _ = DigestFasta(path, arima)
+	if !reflect.DeepEqual(d.cuts["chr3"], []int{0, 5}) {
+		t.Errorf("DigestFasta(%s, Arima) yielded cuts %v on chr3, expected [0 5]", path, d.cuts["chr3"])
+	}
+
+	custom, err := ParseEnzyme("^gatc,G^ANTC")
+	if err != nil || !reflect.DeepEqual(custom.Sites, arima.Sites) {
+		t.Errorf("ParseEnzyme(^gatc,G^ANTC) yielded %v, %v", custom.Sites, err)
+	}
+	if _, err := ParseEnzyme("GATC"); err == nil {
+		t.Errorf("ParseEnzyme(GATC) did not return an error for a site without a cut")
+	}
+
+	writeTestFile(t, path, ">chr1\nAAGCTT\n>\nACGT\n")
+	if _, err := DigestFasta(path, Enzymes["hindiii"]); err == nil {
+		t.Errorf("DigestFasta(%s, HindIII) did not return an error for a header with no name", path)
+	}
+
+	os.Remove(path)
+
+}
@@ -0,0 +1,292 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// FaiRecord is an entry of a FASTA index (.fai) as written by samtools faidx, locating
+// the sequence of a contig within an uncompressed FASTA file.
+type FaiRecord struct {
+
+	// The name of the contig, the header line up to the first whitespace
+	Name string
+
+	// The number of bases in the contig
+	Length int
+
+	// The byte offset of the first base of the contig in the file
+	Offset int64
+
+	// The number of bases on each full line of the contig
+	LineBases int
+
+	// The number of bytes on each full line of the contig, including the line ending
+	LineWidth int
+}
+
+// String returns the tab-delimited .fai line of the record.
+func (r FaiRecord) String() string {
+	return fmt.Sprintf("%s\t%d\t%d\t%d\t%d", r.Name, r.Length, r.Offset, r.LineBases, r.LineWidth)
+}
+
+// offset returns the byte offset in the file of a 0-based position of the contig.
+func (r FaiRecord) offset(pos int) int64 {
+	return r.Offset + int64(pos/r.LineBases)*int64(r.LineWidth) + int64(pos%r.LineBases)
+}
+
+// BuildFai indexes a FASTA file, returning an index record for each contig in the order
+// in which they appear. An error is returned if the file is compressed, as offsets into
+// it cannot be sought, or if the lines of a contig other than its last differ in length.
+func BuildFai(fastaPath string) ([]FaiRecord, error) {
+
+	f, err := os.Open(fastaPath)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't open input file (%s) for reading: %s", fastaPath, err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	if magic, _ := r.Peek(len(gzipMagic)); string(magic) == string(gzipMagic) {
+		return nil, fmt.Errorf("Couldn't index %s, compressed FASTA files cannot be indexed", fastaPath)
+	}
+
+	index := []FaiRecord{}
+	var offset int64
+	var rec *FaiRecord
+	short := false // whether a line shorter than the first of the contig has been seen
+	for lineNum := 1; ; lineNum++ {
+
+		line, err := r.ReadString('\n')
+		width := len(line)
+		offset += int64(width)
+		if width == 0 && err != nil {
+			break
+		}
+		bases := len(strings.TrimRight(line, "\r\n"))
+
+		switch {
+		case strings.HasPrefix(line, ">"):
+			fields := strings.Fields(line[1:])
+			if len(fields) == 0 {
+				return nil, fmt.Errorf("Couldn't index %s, the header at line %d has no name", fastaPath, lineNum)
+			}
+			index = append(index, FaiRecord{fields[0], 0, offset, 0, 0})
+			rec = &index[len(index)-1]
+			short = false
+		case bases == 0 && rec != nil && rec.Length == 0:
+			rec.Offset = offset
+		case bases == 0:
+			short = rec != nil
+		case rec == nil:
+			return nil, fmt.Errorf("Couldn't index %s, sequence precedes the first header at line %d", fastaPath, lineNum)
+		default:
+			if rec.LineBases == 0 {
+				rec.LineBases, rec.LineWidth = bases, width
+			} else if short || bases > rec.LineBases || (bases == rec.LineBases && width != rec.LineWidth && err != io.EOF) {
+				return nil, fmt.Errorf("Couldn't index %s, the lines of contig %s differ in length at line %d", fastaPath, rec.Name, lineNum)
+			}
+			short = bases < rec.LineBases
+			rec.Length += bases
+		}
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+	}
+
+	return index, nil
+
+}
+
+// ReadFai reads a FASTA index (.fai) file.
+func ReadFai(path string) ([]FaiRecord, error) {
+
+	in, err := OpenInput(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	index := []FaiRecord{}
+	s := bufio.NewScanner(in)
+	for line := 1; s.Scan(); line++ {
+		fields := strings.Split(s.Text(), "\t")
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("Couldn't parse line %d of FASTA index %s", line, path)
+		}
+		rec := FaiRecord{Name: fields[0]}
+		var e1, e2, e3, e4 error
+		rec.Length, e1 = strconv.Atoi(fields[1])
+		rec.Offset, e2 = strconv.ParseInt(fields[2], 10, 64)
+		rec.LineBases, e3 = strconv.Atoi(fields[3])
+		rec.LineWidth, e4 = strconv.Atoi(fields[4])
+		if e1 != nil || e2 != nil || e3 != nil || e4 != nil {
+			return nil, fmt.Errorf("Couldn't parse line %d of FASTA index %s", line, path)
+		}
+		index = append(index, rec)
+	}
+
+	return index, s.Err()
+
+}
+
+// WriteFai writes a FASTA index (.fai) file.
+func WriteFai(path string, index []FaiRecord) error {
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(out)
+	for _, rec := range index {
+		w.WriteString(rec.String() + "\n")
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+
+}
+
+// FastaReader provides random access to the sequences of an indexed FASTA file.
+type FastaReader struct {
+	f      *os.File
+	index  []FaiRecord
+	byName map[string]int
+}
+
+// OpenFasta opens an uncompressed FASTA file for random access using its index, the file
+// of the same path with ".fai" appended. If there is no index, or it is older than the
+// FASTA file, the file is indexed and the index written, if possible, for later use.
+func OpenFasta(path string) (*FastaReader, error) {
+
+	faiPath := path + ".fai"
+	var index []FaiRecord
+	fi, err1 := os.Stat(path)
+	if err1 != nil {
+		return nil, fmt.Errorf("Couldn't open input file (%s) for reading: %s", path, err1)
+	}
+	if ii, err := os.Stat(faiPath); err == nil && !ii.ModTime().Before(fi.ModTime()) {
+		if index, err = ReadFai(faiPath); err != nil {
+			return nil, err
+		}
+	} else {
+		if index, err = BuildFai(path); err != nil {
+			return nil, err
+		}
+		WriteFai(faiPath, index)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't open input file (%s) for reading: %s", path, err)
+	}
+
+	fr := &FastaReader{f, index, make(map[string]int, len(index))}
+	for i, rec := range index {
+		fr.byName[rec.Name] = i
+	}
+
+	return fr, nil
+
+}
+
+// Index returns the index records of the contigs, in the order of the file.
+func (fr *FastaReader) Index() []FaiRecord {
+	return fr.index
+}
+
+// Contigs returns the names of the contigs, in the order of the file.
+func (fr *FastaReader) Contigs() []string {
+	names := make([]string, len(fr.index))
+	for i, rec := range fr.index {
+		names[i] = rec.Name
+	}
+	return names
+}
+
+// Length returns the length of a contig, and false if there is no such contig.
+func (fr *FastaReader) Length(contig string) (int, bool) {
+	i, ok := fr.byName[contig]
+	if !ok {
+		return 0, false
+	}
+	return fr.index[i].Length, true
+}
+
+// Fetch returns the sequence of a contig between the 0-based, half-open coordinates
+// start and end, e.g. Fetch("chr1", 0, 10) for its first ten bases. An error is returned
+// for unknown contigs and coordinates outside of the contig.
+func (fr *FastaReader) Fetch(contig string, start, end int) (string, error) {
+
+	i, ok := fr.byName[contig]
+	if !ok {
+		return "", fmt.Errorf("Couldn't fetch %s:%d-%d, no such contig", contig, start, end)
+	}
+	rec := fr.index[i]
+	if start < 0 || end > rec.Length || start > end {
+		return "", fmt.Errorf("Couldn't fetch %s:%d-%d, outside of the contig of length %d", contig, start, end, rec.Length)
+	}
+	if start == end {
+		return "", nil
+	}
+
+	// Read the bytes spanning the region, including line endings, and remove the line
+	// endings.
+	from, to := rec.offset(start), rec.offset(end-1)+1
+	b := make([]byte, to-from)
+	if _, err := fr.f.ReadAt(b, from); err != nil {
+		return "", fmt.Errorf("Couldn't fetch %s:%d-%d: %s", contig, start, end, err)
+	}
+
+	seq := make([]byte, 0, end-start)
+	for _, c := range b {
+		if c != '\n' && c != '\r' {
+			seq = append(seq, c)
+		}
+	}
+
+	return string(seq), nil
+
+}
+
+// Close closes the underlying file.
+func (fr *FastaReader) Close() error {
+	return fr.f.Close()
+}
+
+// ParseRegion parses a region given as "contig" or "contig:start-end" with 1-based,
+// inclusive coordinates, as accepted by samtools faidx, returning the contig and the
+// 0-based, half-open coordinates of the region, see FastaReader.Fetch. An end of -1 is
+// returned if none is given, meaning the end of the contig.
+func ParseRegion(region string) (string, int, int, error) {
+
+	i := strings.LastIndex(region, ":")
+	if i < 0 {
+		return region, 0, -1, nil
+	}
+
+	bounds := strings.SplitN(strings.Replace(region[i+1:], ",", "", -1), "-", 2)
+	start, err1 := strconv.Atoi(bounds[0])
+	end, err2 := -1, error(nil)
+	if len(bounds) == 2 {
+		end, err2 = strconv.Atoi(bounds[1])
+	}
+	if err1 != nil || err2 != nil || start < 1 || (end >= 0 && end < start) {
+		return "", 0, 0, fmt.Errorf("Couldn't parse region %s, expected contig:start-end", region)
+	}
+
+	return region[:i], start - 1, end, nil
+
+}
@@ -0,0 +1,165 @@
+package util
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+const testFasta = ">chr1 first contig\nACGTACGTAC\nGTACGTACGT\nAAC\n>chr2\nGGGGCCCCAA\n\n>chr3\r\nACGTA\r\nCG\r\n"
+
+func writeTestFile(t *testing.T, path, contents string) {
+	MkdirForFile(path)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(contents)
+	f.Close()
+}
+
+func TestFai(t *testing.T) {
+
+	path := "/tmp/lxy/test/testfai.fa"
+	writeTestFile(t, path, testFasta)
+
+	index, err := BuildFai(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []FaiRecord{
+		{"chr1", 23, 19, 10, 11},
+		{"chr2", 10, 51, 10, 11},
+		{"chr3", 7, 70, 5, 7},
+	}
+	if !reflect.DeepEqual(index, expected) {
+		t.Errorf("Expected index %v, got %v", expected, index)
+	}
+
+	if err := WriteFai(path+".fai", index); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadFai(path + ".fai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, expected) {
+		t.Errorf("Expected index read %v, got %v", expected, read)
+	}
+
+	// Lines of differing lengths within a contig can't be indexed.
+	writeTestFile(t, path, ">chr1\nACGT\nAC\nACGT\n")
+	if _, err := BuildFai(path); err == nil {
+		t.Errorf("Expected an error indexing a contig with a short inner line")
+	}
+	writeTestFile(t, path, ">chr1\nACGT\nACGTA\n")
+	if _, err := BuildFai(path); err == nil {
+		t.Errorf("Expected an error indexing a contig with a long line")
+	}
+	writeTestFile(t, path, ">\nACGT\n")
+	if _, err := BuildFai(path); err == nil {
+		t.Errorf("Expected an error indexing a contig with no name")
+	}
+
+	// A final line without a line ending is indexed.
+	writeTestFile(t, path, ">chr1\nACGT\nACGT")
+	if index, err := BuildFai(path); err != nil || index[0].Length != 8 {
+		t.Errorf("Expected a contig of length 8, got %v, %v", index, err)
+	}
+
+}
+
+func TestFetch(t *testing.T) {
+
+	path := "/tmp/lxy/test/testfetch.fa"
+	writeTestFile(t, path, testFasta)
+	os.Remove(path + ".fai")
+
+	fr, err := OpenFasta(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fr.Close()
+
+	if _, err := os.Stat(path + ".fai"); err != nil {
+		t.Errorf("Expected the index to be written: %s", err)
+	}
+	if contigs := fr.Contigs(); !reflect.DeepEqual(contigs, []string{"chr1", "chr2", "chr3"}) {
+		t.Errorf("Unexpected contigs %v", contigs)
+	}
+	if l, ok := fr.Length("chr2"); !ok || l != 10 {
+		t.Errorf("Expected chr2 of length 10, got %d", l)
+	}
+	if _, ok := fr.Length("chr4"); ok {
+		t.Errorf("Expected no length for an unknown contig")
+	}
+
+	cases := []struct {
+		contig     string
+		start, end int
+		seq        string
+	}{
+		{"chr1", 0, 23, "ACGTACGTACGTACGTACGTAAC"},
+		{"chr1", 8, 12, "ACGT"},
+		{"chr1", 10, 10, ""},
+		{"chr1", 19, 23, "TAAC"},
+		{"chr2", 0, 10, "GGGGCCCCAA"},
+		{"chr3", 3, 7, "TACG"},
+	}
+	for _, c := range cases {
+		seq, err := fr.Fetch(c.contig, c.start, c.end)
+		if err != nil || seq != c.seq {
+			t.Errorf("Expected %s:%d-%d to be %s, got %s (%v)", c.contig, c.start, c.end, c.seq, seq, err)
+		}
+	}
+
+	for _, c := range []struct {
+		contig     string
+		start, end int
+	}{{"chr4", 0, 1}, {"chr1", -1, 2}, {"chr1", 20, 24}, {"chr1", 5, 4}} {
+		if _, err := fr.Fetch(c.contig, c.start, c.end); err == nil {
+			t.Errorf("Expected an error fetching %s:%d-%d", c.contig, c.start, c.end)
+		}
+	}
+
+	// Compressed files can't be opened for random access.
+	gzPath := "/tmp/lxy/test/testfetch.fa.gz"
+	out, err := CreateOutput(gzPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Write([]byte(testFasta))
+	out.Close()
+	if _, err := OpenFasta(gzPath); err == nil {
+		t.Errorf("Expected an error opening a compressed fasta")
+	}
+
+}
+
+func TestParseRegion(t *testing.T) {
+
+	cases := []struct {
+		region     string
+		contig     string
+		start, end int
+	}{
+		{"chr1", "chr1", 0, -1},
+		{"chr1:1-10", "chr1", 0, 10},
+		{"chr1:1,001-2,000", "chr1", 1000, 2000},
+		{"chr1:5", "chr1", 4, -1},
+		{"HLA:A:11-20", "HLA:A", 10, 20},
+	}
+	for _, c := range cases {
+		contig, start, end, err := ParseRegion(c.region)
+		if err != nil || contig != c.contig || start != c.start || end != c.end {
+			t.Errorf("Expected %s to parse as %s %d %d, got %s %d %d (%v)", c.region, c.contig, c.start, c.end, contig, start, end, err)
+		}
+	}
+
+	for _, region := range []string{"chr1:0-10", "chr1:10-5", "chr1:a-b"} {
+		if _, _, _, err := ParseRegion(region); err == nil {
+			t.Errorf("Expected an error parsing %s", region)
+		}
+	}
+
+}
@@ -0,0 +1,278 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// FastqRecord is a single read of a FASTQ file.
+type FastqRecord struct {
+
+	// The header line without the leading "@", the read name optionally followed by a
+	// comment, e.g. "read1/1" or "read1 1:N:0:ATCACG"
+	Header string
+
+	// The read sequence
+	Seq string
+
+	// The ASCII encoded base qualities, one per base of the sequence
+	Qual string
+}
+
+// Name returns the name of the read, the header up to the first whitespace, with any
+// "/1" or "/2" mate suffix removed such that the names of the two reads of a pair match.
+func (r FastqRecord) Name() string {
+	name := r.Header
+	if i := strings.IndexAny(name, " \t"); i >= 0 {
+		name = name[:i]
+	}
+	return trimMateSuffix(name)
+}
+
+// trimMateSuffix removes a "/1" or "/2" mate suffix from a read name.
+func trimMateSuffix(name string) string {
+	if strings.HasSuffix(name, "/1") || strings.HasSuffix(name, "/2") {
+		return name[:len(name)-2]
+	}
+	return name
+}
+
+// String returns the four line FASTQ representation of the record.
+func (r FastqRecord) String() string {
+	return "@" + r.Header + "\n" + r.Seq + "\n+\n" + r.Qual + "\n"
+}
+
+// FastqReader iterates over the records of a plain or gzipped FASTQ file in the manner
+// of a bufio.Scanner, with Scan advancing to the next record and Record returning it.
+type FastqReader struct {
+	in   io.Closer
+	s    *bufio.Scanner
+	rec  FastqRecord
+	line int
+	err  error
+}
+
+// OpenFastq opens a plain, gzip or BGZF compressed FASTQ file for reading.
+func OpenFastq(path string) (*FastqReader, error) {
+	in, err := openInput(path)
+	if err != nil {
+		return nil, err
+	}
+	fr := &FastqReader{in: in, s: bufio.NewScanner(in)}
+	fr.s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return fr, nil
+}
+
+// next returns the next line of the file, skipping blank lines between records if
+// skipBlank is true.
+func (fr *FastqReader) next(skipBlank bool) (string, bool) {
+	for fr.s.Scan() {
+		fr.line += 1
+		line := strings.TrimRight(fr.s.Text(), "\r")
+		if skipBlank && len(line) == 0 {
+			continue
+		}
+		return line, true
+	}
+	return "", false
+}
+
+// Scan advances to the next record, returning false at the end of the input or when a
+// read error or malformed record is encountered, see Err.
+func (fr *FastqReader) Scan() bool {
+
+	if fr.err != nil {
+		return false
+	}
+
+	header, ok := fr.next(true)
+	if !ok {
+		fr.err = fr.s.Err()
+		return false
+	}
+
+	seq, ok1 := fr.next(false)
+	plus, ok2 := fr.next(false)
+	qual, ok3 := fr.next(false)
+	switch {
+	case !strings.HasPrefix(header, "@"):
+		fr.err = fmt.Errorf("Malformed FASTQ record at line %d, expected a header beginning with @", fr.line)
+	case !ok1 || !ok2 || !ok3:
+		fr.err = fmt.Errorf("Truncated FASTQ record at line %d", fr.line)
+	case !strings.HasPrefix(plus, "+"):
+		fr.err = fmt.Errorf("Malformed FASTQ record at line %d, expected a separator line beginning with +", fr.line-1)
+	case len(seq) != len(qual):
+		fr.err = fmt.Errorf("Malformed FASTQ record at line %d, sequence and quality lengths differ", fr.line)
+	}
+	if fr.err != nil {
+		return false
+	}
+
+	fr.rec = FastqRecord{header[1:], seq, qual}
+	return true
+
+}
+
+// Record returns the current record.
+func (fr *FastqReader) Record() FastqRecord {
+	return fr.rec
+}
+
+// Err returns the first read error or malformed record encountered by Scan.
+func (fr *FastqReader) Err() error {
+	return fr.err
+}
+
+// Close closes the underlying file.
+func (fr *FastqReader) Close() error {
+	return fr.in.Close()
+}
+
+// FastqWriter writes records to a FASTQ file, compressed if its path ends in ".gz", see
+// CreateOutput.
+type FastqWriter struct {
+	out io.WriteCloser
+	w   *bufio.Writer
+}
+
+// CreateFastq creates a FASTQ file for writing.
+func CreateFastq(path string) (*FastqWriter, error) {
+	out, err := CreateOutput(path)
+	if err != nil {
+		return nil, err
+	}
+	return &FastqWriter{out, bufio.NewWriter(out)}, nil
+}
+
+// Write writes a single record.
+func (fw *FastqWriter) Write(r FastqRecord) error {
+	_, err := fw.w.WriteString(r.String())
+	return err
+}
+
+// Close flushes buffered records and closes the underlying file.
+func (fw *FastqWriter) Close() error {
+	if err := fw.w.Flush(); err != nil {
+		fw.out.Close()
+		return err
+	}
+	return fw.out.Close()
+}
+
+// InterleaveFastq writes the reads of a pair of R1 and R2 FASTQ files to a single file,
+// each R1 read followed by its mate, as expected by aligners given interleaved input,
+// e.g. bwa mem -p. An error is returned if the names of mates differ or if one file has
+// more reads than the other.
+func InterleaveFastq(r1Path, r2Path, outPath string) error {
+
+	in1, err := OpenFastq(r1Path)
+	if err != nil {
+		return err
+	}
+	defer in1.Close()
+	in2, err := OpenFastq(r2Path)
+	if err != nil {
+		return err
+	}
+	defer in2.Close()
+
+	out, err := CreateFastq(outPath)
+	if err != nil {
+		return err
+	}
+
+	for n := 1; ; n++ {
+
+		ok1, ok2 := in1.Scan(), in2.Scan()
+		if !ok1 || !ok2 {
+			for _, e := range []error{in1.Err(), in2.Err()} {
+				if e != nil {
+					out.Close()
+					return e
+				}
+			}
+			if ok1 != ok2 {
+				out.Close()
+				return fmt.Errorf("Couldn't interleave %s and %s, which have different numbers of reads", r1Path, r2Path)
+			}
+			break
+		}
+
+		r1, r2 := in1.Record(), in2.Record()
+		if r1.Name() != r2.Name() {
+			out.Close()
+			return fmt.Errorf("Couldn't interleave %s and %s, read %d is named %s and %s", r1Path, r2Path, n, r1.Name(), r2.Name())
+		}
+		if err := out.Write(r1); err != nil {
+			out.Close()
+			return err
+		}
+		if err := out.Write(r2); err != nil {
+			out.Close()
+			return err
+		}
+
+	}
+
+	return out.Close()
+
+}
+
+// DeinterleaveFastq splits an interleaved FASTQ file, see InterleaveFastq, into R1 and
+// R2 files. An error is returned if the names of consecutive mates differ or if the
+// file has an odd number of reads.
+func DeinterleaveFastq(inPath, r1Path, r2Path string) error {
+
+	in, err := OpenFastq(inPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out1, err := CreateFastq(r1Path)
+	if err != nil {
+		return err
+	}
+	out2, err := CreateFastq(r2Path)
+	if err != nil {
+		out1.Close()
+		return err
+	}
+	fail := func(err error) error {
+		out1.Close()
+		out2.Close()
+		return err
+	}
+
+	for n := 1; in.Scan(); n++ {
+		r1 := in.Record()
+		if !in.Scan() {
+			if in.Err() != nil {
+				return fail(in.Err())
+			}
+			return fail(fmt.Errorf("Couldn't deinterleave %s, read %s has no mate", inPath, r1.Name()))
+		}
+		r2 := in.Record()
+		if r1.Name() != r2.Name() {
+			return fail(fmt.Errorf("Couldn't deinterleave %s, pair %d is named %s and %s", inPath, n, r1.Name(), r2.Name()))
+		}
+		if err := out1.Write(r1); err != nil {
+			return fail(err)
+		}
+		if err := out2.Write(r2); err != nil {
+			return fail(err)
+		}
+	}
+	if in.Err() != nil {
+		return fail(in.Err())
+	}
+
+	if err := out1.Close(); err != nil {
+		out2.Close()
+		return err
+	}
+	return out2.Close()
+
+}
@@ -0,0 +1,129 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func readFastq(t *testing.T, path string) []FastqRecord {
+	in, err := OpenFastq(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	records := []FastqRecord{}
+	for in.Scan() {
+		records = append(records, in.Record())
+	}
+	if in.Err() != nil {
+		t.Error(in.Err())
+	}
+	return records
+}
+
+func writeFastq(t *testing.T, path string, records []FastqRecord) {
+	out, err := CreateFastq(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range records {
+		out.Write(r)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFastqIO(t *testing.T) {
+
+	key := []FastqRecord{{"readid-1.1", "ATG", "EEE"}, {"readid-1.2", "GTA", "EEE"}}
+	records := readFastq(t, filepath.Join(cwd(t), "_testdata", "toy.fastq"))
+	if len(records) < 2 || !reflect.DeepEqual(records[:2], key) {
+		t.Errorf("OpenFastq() of toy.fastq yielded records %v", records)
+	}
+
+	// Interleaving and deinterleaving gzipped files round trips.
+	dir := "/tmp/lxy/test/fastq"
+	os.MkdirAll(dir, 0777)
+	defer os.RemoveAll(dir)
+
+	r1 := []FastqRecord{{"read1/1", "ACGT", "IIII"}, {"read2 1:N:0:ATCACG", "GGCC", "ABCD"}}
+	r2 := []FastqRecord{{"read1/2", "TTTT", "IIII"}, {"read2 2:N:0:ATCACG", "CCGG", "DCBA"}}
+	writeFastq(t, dir+"/r1.fq.gz", r1)
+	writeFastq(t, dir+"/r2.fq", r2)
+
+	if err := InterleaveFastq(dir+"/r1.fq.gz", dir+"/r2.fq", dir+"/il.fq.gz"); err != nil {
+		t.Fatal(err)
+	}
+	interleaved := readFastq(t, dir+"/il.fq.gz")
+	if !reflect.DeepEqual(interleaved, []FastqRecord{r1[0], r2[0], r1[1], r2[1]}) {
+		t.Errorf("InterleaveFastq() wrote %v", interleaved)
+	}
+
+	if err := DeinterleaveFastq(dir+"/il.fq.gz", dir+"/d1.fq", dir+"/d2.fq"); err != nil {
+		t.Fatal(err)
+	}
+	if d1, d2 := readFastq(t, dir+"/d1.fq"), readFastq(t, dir+"/d2.fq"); !reflect.DeepEqual(d1, r1) || !reflect.DeepEqual(d2, r2) {
+		t.Errorf("DeinterleaveFastq() wrote %v and %v", d1, d2)
+	}
+
+	// Mates must match and malformed records are reported.
+	writeFastq(t, dir+"/swapped.fq", []FastqRecord{r2[1], r2[0]})
+	if err := InterleaveFastq(dir+"/r1.fq.gz", dir+"/swapped.fq", dir+"/bad.fq"); err == nil {
+		t.Errorf("InterleaveFastq() of mismatched reads yielded no error")
+	}
+	f, _ := os.Create(dir + "/bad.fq")
+	f.WriteString("@read1\nACGT\n+\nIII\n")
+	f.Close()
+	in, _ := OpenFastq(dir + "/bad.fq")
+	if in.Scan() || in.Err() == nil {
+		t.Errorf("FastqReader.Scan() of a record with a short quality string yielded no error")
+	}
+	in.Close()
+
+}
+
+func TestTrimJunction(t *testing.T) {
+
+	dpnii, _ := ParseEnzyme("dpnii")
+	hindiii, _ := ParseEnzyme("hindiii")
+	arima, _ := ParseEnzyme("arima")
+
+	junctions := func(e Enzyme) []string {
+		motifs := []string{}
+		for _, j := range e.Junctions() {
+			motifs = append(motifs, j.Motif)
+		}
+		return motifs
+	}
+	if j := junctions(dpnii); !reflect.DeepEqual(j, []string{"GATCGATC"}) {
+		t.Errorf("DpnII junctions %v, expected GATCGATC", j)
+	}
+	if j := junctions(hindiii); !reflect.DeepEqual(j, []string{"AAGCTAGCTT"}) {
+		t.Errorf("HindIII junctions %v, expected AAGCTAGCTT", j)
+	}
+	if j := junctions(arima); !reflect.DeepEqual(j, []string{"GATCGATC", "GATCANTC", "GANTGATC", "GANTANTC"}) {
+		t.Errorf("Arima junctions %v", j)
+	}
+
+	tests := []struct {
+		e        Enzyme
+		seq, key string
+		junction string
+	}{
+		{dpnii, "AAAAGATCGATCTTTT", "AAAAGATC", "GATCGATC"},
+		{dpnii, "AAAAGATCTTTT", "AAAAGATCTTTT", ""},
+		{hindiii, "CCAAGCTAGCTTGG", "CCAAGCT", "AAGCTAGCTT"},
+		{arima, "CCGAATGATCGATC", "CCGAAT", "GANTGATC"},
+	}
+	for _, test := range tests {
+		r := FastqRecord{"read", test.seq, test.seq}
+		trimmed, junction := TrimJunction(r, test.e.Junctions())
+		if trimmed.Seq != test.key || trimmed.Qual != test.key || junction != test.junction {
+			t.Errorf("TrimJunction(%s) with %s yielded %s at %q, expected %s at %q", test.seq, test.e.Name, trimmed.Seq, junction, test.key, test.junction)
+		}
+	}
+
+}
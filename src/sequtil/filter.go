@@ -0,0 +1,321 @@
+package util
+
+import (
+	"fmt"
+	"github.com/codegangsta/cli"
+	"strings"
+)
+
+// PairFilter decides whether a read pair, given as the two alignments sharing a qname,
+// should contribute to a links object.
+type PairFilter interface {
+
+	// Accept returns true if the pair passes the filter.
+	Accept(a1, a2 Alignment) bool
+
+	// Name returns a short description of the filter for use in run summaries.
+	Name() string
+}
+
+// SAM flag bits commonly excluded when counting Hi-C contacts, for use with
+// ExcludeFlags, e.g. ExcludeFlags(FlagSecondary | FlagQCFail | FlagDuplicate).
+const (
+	FlagUnmapped      = 0x4
+	FlagSecondary     = 0x100
+	FlagQCFail        = 0x200
+	FlagDuplicate     = 0x400
+	FlagSupplementary = 0x800
+)
+
+type minMAPQFilter struct {
+	mapq int
+}
+
+// MinMAPQ returns a PairFilter rejecting pairs in which either alignment has a mapping
+// quality below mapq.
+func MinMAPQ(mapq int) PairFilter {
+	return minMAPQFilter{mapq}
+}
+
+func (f minMAPQFilter) Accept(a1, a2 Alignment) bool {
+	return a1.mapq >= f.mapq && a2.mapq >= f.mapq
+}
+
+func (f minMAPQFilter) Name() string {
+	return fmt.Sprintf("mapq<%d", f.mapq)
+}
+
+type excludeFlagsFilter struct {
+	mask int
+}
+
+// ExcludeFlags returns a PairFilter rejecting pairs in which either alignment has any of
+// the bits of mask set in its SAM flag, in the manner of samtools view -F.
+func ExcludeFlags(mask int) PairFilter {
+	return excludeFlagsFilter{mask}
+}
+
+func (f excludeFlagsFilter) Accept(a1, a2 Alignment) bool {
+	return a1.flag.code&f.mask == 0 && a2.flag.code&f.mask == 0
+}
+
+func (f excludeFlagsFilter) Name() string {
+	return fmt.Sprintf("flags&0x%x", f.mask)
+}
+
+type requireFlagsFilter struct {
+	mask int
+}
+
+// RequireFlags returns a PairFilter rejecting pairs in which either alignment lacks any
+// of the bits of mask in its SAM flag, in the manner of samtools view -f.
+func RequireFlags(mask int) PairFilter {
+	return requireFlagsFilter{mask}
+}
+
+func (f requireFlagsFilter) Accept(a1, a2 Alignment) bool {
+	return a1.flag.code&f.mask == f.mask && a2.flag.code&f.mask == f.mask
+}
+
+func (f requireFlagsFilter) Name() string {
+	return fmt.Sprintf("!flags&0x%x", f.mask)
+}
+
+type insertDistanceFilter struct {
+	min int
+	max int
+}
+
+// InsertDistance returns a PairFilter rejecting pairs aligned to the same reference
+// sequence whose alignment start positions are less than min or more than max bases
+// apart. A max of zero or less means no upper limit. Pairs aligned to different
+// reference sequences have no insert distance and are always accepted, use CisOnly to
+// exclude them.
+func InsertDistance(min, max int) PairFilter {
+	return insertDistanceFilter{min, max}
+}
+
+func (f insertDistanceFilter) Accept(a1, a2 Alignment) bool {
+	if a1.rname != a2.rname {
+		return true
+	}
+	d := a1.pos - a2.pos
+	if d < 0 {
+		d = -d
+	}
+	return d >= f.min && (f.max <= 0 || d <= f.max)
+}
+
+func (f insertDistanceFilter) Name() string {
+	if f.max <= 0 {
+		return fmt.Sprintf("distance<%d", f.min)
+	}
+	return fmt.Sprintf("distance!=[%d,%d]", f.min, f.max)
+}
+
+type cisFilter struct {
+	cis bool
+}
+
+// CisOnly returns a PairFilter accepting only pairs aligned to the same reference sequence.
+func CisOnly() PairFilter {
+	return cisFilter{true}
+}
+
+// TransOnly returns a PairFilter accepting only pairs aligned to different reference
+// sequences.
+func TransOnly() PairFilter {
+	return cisFilter{false}
+}
+
+func (f cisFilter) Accept(a1, a2 Alignment) bool {
+	return (a1.rname == a2.rname) == f.cis
+}
+
+func (f cisFilter) Name() string {
+	if f.cis {
+		return "trans"
+	}
+	return "cis"
+}
+
+// PairFilterChain applies a series of PairFilters in order, counting for each filter
+// the number of pairs it was the first to reject. A nil *PairFilterChain accepts
+// every pair.
+type PairFilterChain struct {
+	filters  []PairFilter
+	rejected []int
+	passed   int
+}
+
+// NewPairFilterChain constructs a PairFilterChain from the provided filters, which are
+// applied in the order given.
+func NewPairFilterChain(filters ...PairFilter) *PairFilterChain {
+	return &PairFilterChain{filters, make([]int, len(filters)), 0}
+}
+
+// Add appends a filter to the end of the chain.
+func (c *PairFilterChain) Add(f PairFilter) {
+	c.filters = append(c.filters, f)
+	c.rejected = append(c.rejected, 0)
+}
+
+// AddBeforeDuplicates adds a filter to the chain ahead of any Deduplicator, such that
+// duplicates are still identified last, among the pairs passing every other filter, see
+// PairFilterFromContext.
+func (c *PairFilterChain) AddBeforeDuplicates(f PairFilter) {
+	i := len(c.filters)
+	for j, g := range c.filters {
+		if _, ok := g.(*Deduplicator); ok {
+			i = j
+			break
+		}
+	}
+	c.filters = append(c.filters[:i], append([]PairFilter{f}, c.filters[i:]...)...)
+	c.rejected = append(c.rejected[:i], append([]int{0}, c.rejected[i:]...)...)
+}
+
+// Accept returns true if the pair passes every filter in the chain, otherwise recording
+// a rejection against the first filter it failed.
+func (c *PairFilterChain) Accept(a1, a2 Alignment) bool {
+	if c == nil {
+		return true
+	}
+	for i, f := range c.filters {
+		if !f.Accept(a1, a2) {
+			c.rejected[i] += 1
+			return false
+		}
+	}
+	c.passed += 1
+	return true
+}
+
+// Passed returns the number of pairs accepted by the chain.
+func (c *PairFilterChain) Passed() int {
+	if c == nil {
+		return 0
+	}
+	return c.passed
+}
+
+// Rejected returns the number of pairs rejected by each filter in the chain, keyed by
+// filter name.
+func (c *PairFilterChain) Rejected() map[string]int {
+	ret := map[string]int{}
+	if c == nil {
+		return ret
+	}
+	for i, f := range c.filters {
+		ret[f.Name()] += c.rejected[i]
+	}
+	return ret
+}
+
+// Summary returns a human readable account of the number of pairs that passed the chain
+// and the number rejected by each filter, along with the summary of any filter that
+// provides one, e.g. the duplication rate of a Deduplicator.
+func (c *PairFilterChain) Summary() string {
+	if c == nil {
+		return ""
+	}
+	lines := []string{}
+	total := c.passed
+	for i, f := range c.filters {
+		total += c.rejected[i]
+		lines = append(lines, fmt.Sprintf("\trejected %d pairs with %s\n", c.rejected[i], f.Name()))
+		if r, ok := f.(interface {
+			Summary() string
+		}); ok {
+			lines = append(lines, "\t"+r.Summary())
+		}
+	}
+	return fmt.Sprintf("%d of %d read pairs passed filters\n", c.passed, total) + strings.Join(lines, "")
+}
+
+// PairFilterFlags returns the command line flags with which commands that build links
+// from alignments configure their PairFilterChain, see PairFilterFromContext.
+func PairFilterFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.IntFlag{
+			Name:  "minMapq",
+			Value: 0,
+			Usage: "Minimum mapping quality of both reads of a pair.",
+		},
+		cli.IntFlag{
+			Name:  "excludeFlags",
+			Value: 0,
+			Usage: "Skip pairs where either read has any of these SAM flag bits set, e.g. 0xF04 for unmapped, secondary, QC fail, duplicate and supplementary.",
+		},
+		cli.IntFlag{
+			Name:  "requireFlags",
+			Value: 0,
+			Usage: "Skip pairs where either read lacks any of these SAM flag bits.",
+		},
+		cli.IntFlag{
+			Name:  "minDistance",
+			Value: 0,
+			Usage: "Minimum distance in bp between the reads of a pair aligned to the same contig.",
+		},
+		cli.IntFlag{
+			Name:  "maxDistance",
+			Value: 0,
+			Usage: "Maximum distance in bp between the reads of a pair aligned to the same contig, 0 for no limit.",
+		},
+		cli.BoolFlag{
+			Name:  "cisOnly",
+			Usage: "Only count pairs aligned to the same contig.",
+		},
+		cli.BoolFlag{
+			Name:  "transOnly",
+			Usage: "Only count pairs aligned to different contigs.",
+		},
+		cli.BoolFlag{
+			Name:  "keepDuplicates",
+			Usage: "Count PCR and optical duplicate pairs rather than removing them.",
+		},
+		cli.IntFlag{
+			Name:  "dupTolerance",
+			Value: 0,
+			Usage: "Number of bp by which the positions of the reads of duplicate pairs may differ.",
+		},
+	}
+}
+
+// PairFilterFromContext constructs a PairFilterChain from the flags given by
+// PairFilterFlags, including only those filters that were enabled. Duplicate pairs are
+// removed unless --keepDuplicates is given.
+func PairFilterFromContext(c *cli.Context) (*PairFilterChain, error) {
+
+	if c.Bool("cisOnly") && c.Bool("transOnly") {
+		return nil, fmt.Errorf("--cisOnly and --transOnly are mutually exclusive")
+	}
+
+	chain := NewPairFilterChain()
+	if c.Int("excludeFlags") != 0 {
+		chain.Add(ExcludeFlags(c.Int("excludeFlags")))
+	}
+	if c.Int("requireFlags") != 0 {
+		chain.Add(RequireFlags(c.Int("requireFlags")))
+	}
+	if c.Int("minMapq") > 0 {
+		chain.Add(MinMAPQ(c.Int("minMapq")))
+	}
+	if c.Bool("cisOnly") {
+		chain.Add(CisOnly())
+	}
+	if c.Bool("transOnly") {
+		chain.Add(TransOnly())
+	}
+	if c.Int("minDistance") > 0 || c.Int("maxDistance") > 0 {
+		chain.Add(InsertDistance(c.Int("minDistance"), c.Int("maxDistance")))
+	}
+	// Duplicates are identified last so that the duplication rate is that of the
+	// pairs which would otherwise be counted.
+	if !c.Bool("keepDuplicates") {
+		chain.Add(NewDeduplicator(c.Int("dupTolerance")))
+	}
+
+	return chain, nil
+
+}
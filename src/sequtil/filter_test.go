@@ -0,0 +1,80 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPairFilterChain(t *testing.T) {
+
+	read := func(rname string, pos, mapq, flag int) Alignment {
+		return Alignment{"readid", newSAMFlag(flag), rname, pos, mapq, CIGAR{CIGARCode{3, "M"}}, "*", 0, 0, "ATG", "III", nil}
+	}
+
+	pairs := [][]Alignment{
+		{read("chr1", 100, 60, 65), read("chr1", 5000, 60, 129)},    // passes
+		{read("chr1", 100, 60, 65), read("chr2", 5000, 60, 129)},    // trans
+		{read("chr1", 100, 10, 65), read("chr1", 5000, 60, 129)},    // low mapq
+		{read("chr1", 100, 60, 1089), read("chr1", 5000, 60, 1153)}, // duplicate
+		{read("chr1", 100, 60, 65), read("chr1", 150, 60, 129)},     // too close
+		{read("chr1", 100, 60, 2113), read("chr1", 9000, 60, 129)},  // supplementary
+		{read("chr1", 100, 60, 65), read("chr1", 2000000, 60, 129)}, // too far
+		{read("chr1", 100, 60, 1089), read("chr1", 5000, 10, 1153)}, // duplicate, counted once
+	}
+
+	chain := NewPairFilterChain(
+		ExcludeFlags(FlagDuplicate|FlagSupplementary),
+		MinMAPQ(30),
+		CisOnly(),
+		InsertDistance(1000, 1000000),
+	)
+
+	accepted := []bool{}
+	for _, p := range pairs {
+		accepted = append(accepted, chain.Accept(p[0], p[1]))
+	}
+
+	acceptedKey := []bool{true, false, false, false, false, false, false, false}
+	if !reflect.DeepEqual(accepted, acceptedKey) {
+		t.Errorf("PairFilterChain.Accept yielded %v, expected %v", accepted, acceptedKey)
+	}
+
+	rejectedKey := map[string]int{"flags&0xc00": 3, "mapq<30": 1, "trans": 1, "distance!=[1000,1000000]": 2}
+	if !reflect.DeepEqual(chain.Rejected(), rejectedKey) {
+		t.Errorf("PairFilterChain.Rejected() yielded %v, expected %v", chain.Rejected(), rejectedKey)
+	}
+	if chain.Passed() != 1 {
+		t.Errorf("PairFilterChain.Passed() yielded %d, expected 1", chain.Passed())
+	}
+
+	// Filters added before duplicates precede the Deduplicator, which remains last.
+	dedup := NewPairFilterChain(MinMAPQ(30), NewDeduplicator(0))
+	dedup.AddBeforeDuplicates(CisOnly())
+	if _, ok := dedup.filters[2].(*Deduplicator); !ok || dedup.filters[1].Name() != "trans" || len(dedup.rejected) != 3 {
+		t.Errorf("PairFilterChain.AddBeforeDuplicates() didn't add a filter ahead of the Deduplicator")
+	}
+	dedup.Accept(pairs[1][0], pairs[1][1])
+	if dedup.Rejected()["trans"] != 1 {
+		t.Errorf("PairFilterChain.AddBeforeDuplicates() yielded rejections %v", dedup.Rejected())
+	}
+
+	// A nil chain accepts everything.
+	var none *PairFilterChain
+	for _, p := range pairs {
+		if !none.Accept(p[0], p[1]) {
+			t.Errorf("nil PairFilterChain rejected a pair")
+		}
+	}
+
+	// Trans pairs are only accepted by TransOnly, and are never rejected on distance.
+	if !TransOnly().Accept(pairs[1][0], pairs[1][1]) || TransOnly().Accept(pairs[0][0], pairs[0][1]) {
+		t.Errorf("TransOnly() did not accept only the trans pair")
+	}
+	if !InsertDistance(1000, 2000).Accept(pairs[1][0], pairs[1][1]) {
+		t.Errorf("InsertDistance() rejected a trans pair")
+	}
+	if !RequireFlags(0x40).Accept(pairs[0][0], pairs[0][0]) || RequireFlags(0x40).Accept(pairs[0][0], pairs[0][1]) {
+		t.Errorf("RequireFlags(0x40) did not require the flag of both reads")
+	}
+
+}
@@ -0,0 +1,154 @@
+package util
+
+import (
+	"sort"
+)
+
+// FrozenDenseMaxSize is the largest number of entity ids for which a FrozenLinks object
+// stores its values as a dense triangular matrix, larger sets of links being stored in
+// compressed sparse row (CSR) form. A dense matrix of this size takes 16MB.
+const FrozenDenseMaxSize = 2048
+
+// FrozenLinks is an immutable snapshot of a Links object, see Links.Freeze, providing
+// fast indexed access to association values for optimizers that look them up many
+// times, such as the genetic algorithms of scaffolding and phasing.
+//
+// Values are indexed by the integer ids of the entities of the Links object. For up to
+// FrozenDenseMaxSize ids they are held in a packed upper triangular matrix, and
+// otherwise in CSR form, each row holding the sorted ids of the entities linked to an
+// entity, and the values of those links, in both directions.
+type FrozenLinks struct {
+
+	// The number of entities, see Links.Size
+	size int
+
+	// The number of rows and columns of the matrix, one more than the largest id
+	n int
+
+	// The packed upper triangular matrix, if dense
+	dense []float64
+
+	// The CSR matrix, if sparse, the columns and values of row i being those in
+	// [rowStart[i], rowStart[i+1])
+	rowStart []int
+	cols     []int
+	vals     []float64
+}
+
+// Freeze returns an immutable snapshot of the links, see FrozenLinks. Links added to
+// the Links object after it is frozen are not reflected in the snapshot.
+func (l *Links) Freeze() *FrozenLinks {
+
+	n := 0
+	for id := range l.idKeyRev {
+		if id >= n {
+			n = id + 1
+		}
+	}
+	f := &FrozenLinks{size: l.Size(), n: n}
+
+	if n <= FrozenDenseMaxSize {
+		f.dense = make([]float64, n*(n+1)/2)
+		for id1, sub := range l.data {
+			for id2, v := range sub {
+				if id1 < n && id2 < n {
+					f.dense[f.denseIndex(id1, id2)] = v
+				}
+			}
+		}
+		return f
+	}
+
+	// Count the entries of each row, each link appearing in the rows of both entities,
+	// and fill the rows in sorted column order.
+	counts := make([]int, n+1)
+	for id1, sub := range l.data {
+		for id2 := range sub {
+			if id1 < n && id2 < n {
+				counts[id1] += 1
+				if id1 != id2 {
+					counts[id2] += 1
+				}
+			}
+		}
+	}
+	f.rowStart = make([]int, n+1)
+	for i := 0; i < n; i++ {
+		f.rowStart[i+1] = f.rowStart[i] + counts[i]
+	}
+	f.cols = make([]int, f.rowStart[n])
+	f.vals = make([]float64, f.rowStart[n])
+	next := make([]int, n)
+	copy(next, f.rowStart[:n])
+	for id1, sub := range l.data {
+		for id2, v := range sub {
+			if id1 >= n || id2 >= n {
+				continue
+			}
+			f.cols[next[id1]], f.vals[next[id1]] = id2, v
+			next[id1] += 1
+			if id1 != id2 {
+				f.cols[next[id2]], f.vals[next[id2]] = id1, v
+				next[id2] += 1
+			}
+		}
+	}
+	for i := 0; i < n; i++ {
+		sort.Sort(csrRow{f.cols[f.rowStart[i]:f.rowStart[i+1]], f.vals[f.rowStart[i]:f.rowStart[i+1]]})
+	}
+
+	return f
+
+}
+
+// csrRow sorts the columns of a row of a CSR matrix along with their values.
+type csrRow struct {
+	cols []int
+	vals []float64
+}
+
+func (r csrRow) Len() int           { return len(r.cols) }
+func (r csrRow) Less(i, j int) bool { return r.cols[i] < r.cols[j] }
+func (r csrRow) Swap(i, j int) {
+	r.cols[i], r.cols[j] = r.cols[j], r.cols[i]
+	r.vals[i], r.vals[j] = r.vals[j], r.vals[i]
+}
+
+// denseIndex returns the index in the packed upper triangular matrix of the value of a
+// pair of ids, in either order.
+func (f *FrozenLinks) denseIndex(id1, id2 int) int {
+	if id1 > id2 {
+		id1, id2 = id2, id1
+	}
+	return id1*f.n - id1*(id1-1)/2 + id2 - id1
+}
+
+// Get returns the association value for a pair of entity ids, which is zero if they
+// are not linked or either id is unknown.
+func (f *FrozenLinks) Get(id1, id2 int) float64 {
+
+	if id1 < 0 || id2 < 0 || id1 >= f.n || id2 >= f.n {
+		return 0
+	}
+	if f.dense != nil {
+		return f.dense[f.denseIndex(id1, id2)]
+	}
+
+	start, end := f.rowStart[id1], f.rowStart[id1+1]
+	i := start + sort.SearchInts(f.cols[start:end], id2)
+	if i < end && f.cols[i] == id2 {
+		return f.vals[i]
+	}
+	return 0
+
+}
+
+// Size returns the number of entities, see Links.Size.
+func (f *FrozenLinks) Size() int {
+	return f.size
+}
+
+// Dense returns whether the values are stored as a dense matrix rather than in CSR form.
+func (f *FrozenLinks) Dense() bool {
+	return f.dense != nil
+}
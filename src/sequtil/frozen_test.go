@@ -0,0 +1,98 @@
+package util
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestFreeze(t *testing.T) {
+
+	// Links small enough to be dense and large enough to be sparse give the same values
+	// as the links they were frozen from.
+	for _, size := range []int{50, FrozenDenseMaxSize + 50} {
+
+		rng := rand.New(rand.NewSource(1))
+		links := NewLinks()
+		for i := 0; i < size; i++ {
+			links.ID(fmt.Sprintf("ctg%d", i))
+		}
+		for i := 0; i < 20*size; i++ {
+			links.Add(rng.Intn(size), rng.Intn(size), float64(rng.Intn(10)-3))
+		}
+
+		frozen := links.Freeze()
+		if frozen.Dense() != (size <= FrozenDenseMaxSize) {
+			t.Errorf("Expected links of %d entities to be frozen as dense %t", size, size <= FrozenDenseMaxSize)
+		}
+		if frozen.Size() != size {
+			t.Errorf("Expected frozen links of %d entities, got %d", size, frozen.Size())
+		}
+
+		for i := 0; i < 20000; i++ {
+			id1, id2 := rng.Intn(size), rng.Intn(size)
+			if i%10 == 0 {
+				id2 = id1
+			}
+			expected, _ := links.Get(id1, id2)
+			if v := frozen.Get(id1, id2); v != expected {
+				t.Fatalf("Expected frozen %d-%d to be %f, got %f", id1, id2, expected, v)
+			}
+		}
+		for _, ids := range [][2]int{{-1, 0}, {0, size}, {size + 10, 1}} {
+			if v := frozen.Get(ids[0], ids[1]); v != 0 {
+				t.Errorf("Expected unknown ids %v to have value 0, got %f", ids, v)
+			}
+		}
+
+		// The frozen links don't change with the links.
+		before := frozen.Get(0, 1)
+		links.Add(0, 1, 100)
+		if v := frozen.Get(0, 1); v != before {
+			t.Errorf("Expected frozen links to be unchanged, got %f rather than %f", v, before)
+		}
+
+	}
+
+	if v := (&FrozenLinks{}).Get(0, 0); v != 0 {
+		t.Errorf("Expected empty frozen links to have value 0, got %f", v)
+	}
+
+}
+
+// BenchmarkGet compares looking up values in links with doing so in the dense and
+// sparse links frozen from them, as the scoring of scaffoldings and phasings does.
+func BenchmarkGet(b *testing.B) {
+
+	for _, size := range []int{500, FrozenDenseMaxSize + 500} {
+
+		rng := rand.New(rand.NewSource(1))
+		links := NewLinks()
+		for i := 0; i < size; i++ {
+			links.ID(fmt.Sprintf("ctg%d", i))
+		}
+		for i := 0; i < 20*size; i++ {
+			links.Add(rng.Intn(size), rng.Intn(size), float64(rng.Intn(10)))
+		}
+		frozen := links.Freeze()
+		ids := make([][2]int, 4096)
+		for i := range ids {
+			ids[i] = [2]int{rng.Intn(size), rng.Intn(size)}
+		}
+
+		b.Run(fmt.Sprintf("Links/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				p := ids[i%len(ids)]
+				links.Get(p[0], p[1])
+			}
+		})
+		b.Run(fmt.Sprintf("FrozenLinks/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				p := ids[i%len(ids)]
+				frozen.Get(p[0], p[1])
+			}
+		})
+
+	}
+
+}
@@ -0,0 +1,172 @@
+package util
+
+import (
+	"fmt"
+	"io"
+)
+
+// PairClass is the category of a Hi-C read pair according to the restriction fragments
+// to which its reads align, see ClassifyPair.
+type PairClass int
+
+const (
+	// PairValid is a ligation product of two distinct restriction fragments.
+	PairValid PairClass = iota
+
+	// PairDanglingEnd is an unligated fragment, with the reads facing each other
+	// within a single restriction fragment.
+	PairDanglingEnd
+
+	// PairSelfCircle is a fragment ligated to itself, with the reads facing away from
+	// each other within a single restriction fragment.
+	PairSelfCircle
+
+	// PairReligation is a pair of adjacent fragments ligated back together at the
+	// site at which they were cut, indistinguishable from an undigested molecule.
+	PairReligation
+
+	// PairSameStrand is a pair with both reads on the same strand of a single
+	// restriction fragment, which no ligation product can produce.
+	PairSameStrand
+
+	// PairUnassigned is a pair with a read on a contig absent from the digest.
+	PairUnassigned
+)
+
+// pairClassNames gives the name of each PairClass, in order.
+var pairClassNames = []string{"valid", "dangling_end", "self_circle", "religation", "same_strand", "unassigned"}
+
+// String returns the name of the pair class, e.g. "dangling_end".
+func (c PairClass) String() string {
+	return pairClassNames[c]
+}
+
+// fivePrime returns the 1-based reference position of the 5' end of an aligned read,
+// which for reads aligned to the reverse strand is the end of the alignment.
+func fivePrime(a Alignment) int {
+	if a.flag.reversecomp {
+		return a.pos + a.cigar.refLength() - 1
+	}
+	return a.pos
+}
+
+// ClassifyPair determines whether a read pair is a valid Hi-C ligation product or one of
+// the common artifacts of the protocol on the basis of the restriction fragments
+// containing the 5' end of each read and the orientations of the reads.
+func (d *Digest) ClassifyPair(a1, a2 Alignment) PairClass {
+
+	f1, _, _ := d.Fragment(a1.rname, fivePrime(a1))
+	f2, _, _ := d.Fragment(a2.rname, fivePrime(a2))
+	if f1 < 0 || f2 < 0 {
+		return PairUnassigned
+	}
+
+	if a1.rname != a2.rname {
+		return PairValid
+	}
+
+	// Order the reads along the contig so that orientation can be determined.
+	if fivePrime(a2) < fivePrime(a1) {
+		a1, a2 = a2, a1
+		f1, f2 = f2, f1
+	}
+	inward := !a1.flag.reversecomp && a2.flag.reversecomp
+	outward := a1.flag.reversecomp && !a2.flag.reversecomp
+
+	switch {
+	case f1 == f2 && inward:
+		return PairDanglingEnd
+	case f1 == f2 && outward:
+		return PairSelfCircle
+	case f1 == f2:
+		return PairSameStrand
+	case f2-f1 == 1 && inward:
+		return PairReligation
+	}
+
+	return PairValid
+
+}
+
+// HiCQC tabulates the classes of Hi-C read pairs, see ClassifyPair. It is a PairFilter
+// accepting only valid pairs, such that it can both count and filter the pairs passed
+// to the link builders in a single pass.
+type HiCQC struct {
+	digest *Digest
+	counts []int
+}
+
+// NewHiCQC constructs a HiCQC classifying pairs according to the provided digest.
+func NewHiCQC(d *Digest) *HiCQC {
+	return &HiCQC{d, make([]int, len(pairClassNames))}
+}
+
+// Accept classifies and counts a pair, returning true if it is a valid ligation product.
+func (qc *HiCQC) Accept(a1, a2 Alignment) bool {
+	c := qc.digest.ClassifyPair(a1, a2)
+	qc.counts[c] += 1
+	return c == PairValid
+}
+
+// Name describes the pairs rejected by the HiCQC filter.
+func (qc *HiCQC) Name() string {
+	return "invalid Hi-C pair"
+}
+
+// Count returns the number of pairs of the specified class that have been seen.
+func (qc *HiCQC) Count(c PairClass) int {
+	return qc.counts[c]
+}
+
+// Write writes a tab-delimited report of the number and fraction of pairs in each class.
+func (qc *HiCQC) Write(out io.Writer) error {
+
+	total := 0
+	for _, n := range qc.counts {
+		total += n
+	}
+
+	if _, err := fmt.Fprintf(out, "class\tcount\tfraction\n"); err != nil {
+		return err
+	}
+	for c, n := range qc.counts {
+		fraction := 0.0
+		if total > 0 {
+			fraction = float64(n) / float64(total)
+		}
+		if _, err := fmt.Fprintf(out, "%s\t%d\t%f\n", PairClass(c), n, fraction); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(out, "total\t%d\t%f\n", total, 1.0)
+	return err
+
+}
+
+// ClassifyPairsFromSam classifies each read pair of an alignments file,
+// tabulating the results in qc. Only pairs passing the filter, which may be nil, are
+// classified, qc being added to the filter ahead of any Deduplicator such that
+// duplicates are identified among the valid pairs, see AddBeforeDuplicates.
+func ClassifyPairsFromSam(samPath string, qc *HiCQC, filter *PairFilterChain) error {
+
+	if filter == nil {
+		filter = NewPairFilterChain()
+	}
+	filter.AddBeforeDuplicates(qc)
+
+	in, err := openGroupedAlignments(samPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	pairs := newPairScanner(in)
+	for pairs.Scan() {
+		filter.Accept(pairs.Pair())
+	}
+	fmt.Print(pairs.Summary())
+	fmt.Print(filter.Summary())
+
+	return in.Err()
+
+}
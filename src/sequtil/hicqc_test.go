@@ -0,0 +1,55 @@
+package util
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestClassifyPair(t *testing.T) {
+
+	// Fragments of chr1 are [1,100], [101,200] and [201,1000]
+	d := &Digest{"test", map[string][]int{"chr1": []int{100, 200}, "chr2": []int{}}, map[string]int{"chr1": 1000, "chr2": 500}}
+
+	read := func(rname string, pos, flag int) Alignment {
+		return Alignment{"readid", newSAMFlag(flag), rname, pos, 60, CIGAR{CIGARCode{10, "M"}}, "*", 0, 0, "ACGTACGTAC", "*", nil}
+	}
+
+	tests := []struct {
+		a1, a2 Alignment
+		class  PairClass
+	}{
+		{read("chr1", 10, 0), read("chr1", 50, 16), PairDanglingEnd},
+		{read("chr1", 50, 16), read("chr1", 10, 0), PairDanglingEnd},
+		{read("chr1", 10, 16), read("chr1", 50, 0), PairSelfCircle},
+		{read("chr1", 10, 0), read("chr1", 50, 0), PairSameStrand},
+		{read("chr1", 50, 0), read("chr1", 150, 16), PairReligation},
+		{read("chr1", 50, 16), read("chr1", 150, 0), PairValid},
+		{read("chr1", 50, 0), read("chr1", 250, 16), PairValid},
+		{read("chr1", 50, 0), read("chr2", 50, 0), PairValid},
+		{read("chr1", 50, 0), read("chr3", 50, 0), PairUnassigned},
+		// The 5' end of a reverse strand read at 95 lies in the second fragment
+		{read("chr1", 50, 0), read("chr1", 95, 16), PairReligation},
+	}
+
+	qc := NewHiCQC(d)
+	for _, test := range tests {
+		if c := d.ClassifyPair(test.a1, test.a2); c != test.class {
+			t.Errorf("ClassifyPair(%s, %s) yielded %s, expected %s", test.a1, test.a2, c, test.class)
+		}
+		if qc.Accept(test.a1, test.a2) != (test.class == PairValid) {
+			t.Errorf("HiCQC.Accept(%s, %s) did not accept only valid pairs", test.a1, test.a2)
+		}
+	}
+
+	if qc.Count(PairValid) != 3 || qc.Count(PairReligation) != 2 || qc.Count(PairDanglingEnd) != 2 {
+		t.Errorf("HiCQC did not count pairs by class, yielded %v", qc.counts)
+	}
+
+	var out bytes.Buffer
+	qc.Write(&out)
+	if !strings.Contains(out.String(), "valid\t3\t0.300000\n") || !strings.Contains(out.String(), "total\t10\t") {
+		t.Errorf("HiCQC.Write yielded unexpected report:\n%s", out.String())
+	}
+
+}
@@ -3,7 +3,8 @@ package util
 import (
 	"bufio"
 	"fmt"
-	"os"
+	"io"
+	"sort"
 	"strconv"
 	"strings"
 	//"github.com/gonum/matrix/mat64"
@@ -25,6 +26,10 @@ type Links struct {
 	// The entity ID / entity ID mapping, storing a real number association
 	// value between the two.
 	data map[int]map[int]float64
+
+	// The sequence dictionary of the reference whose contigs are the entities, if they
+	// are contigs and it is known, see SetReference.
+	refs *RefDict
 }
 
 // NewLinks instantiates a new entity link object
@@ -177,8 +182,43 @@ func (l *Links) Add(id1, id2 int, val float64) error {
 }
 
 // AddMulti takes a partial set of variant or block links and adds them to the referenced links object.
-func (l *Links) AddMulti() {
+//
+// Entities are matched by name, those not yet tracked in the referenced Links object being
+// assigned new ids in the order of their ids in the partial set.
+func (l *Links) AddMulti(partial Links) {
+
+	// Map each integer id of the partial set to the corresponding id of the referenced
+	// Links object, adding entities in id order so that the assignment is deterministic.
+	ids := make(map[int]int, len(partial.idKeyRev))
+	for _, id := range partial.sortedIDs() {
+		ids[id] = l.ID(partial.idKeyRev[id])
+	}
+
+	// Add each association value of the partial set to the corresponding value.
+	for k, sub := range partial.data {
+		for k2, v := range sub {
+			l.Add(ids[k], ids[k2], v)
+		}
+	}
+
+}
 
+// sortedIDs returns the integer ids of the entities in a Links object in ascending order.
+func (l *Links) sortedIDs() []int {
+	ids := l.IntIDs()
+	sort.Ints(ids)
+	return ids
+}
+
+// copyEntities returns an empty Links object with the same entities and ids as this
+// one, and the same reference.
+func (l *Links) copyEntities() Links {
+	c := NewLinks()
+	for name, id := range l.idKey {
+		c.idKey[name], c.idKeyRev[id] = id, name
+	}
+	c.maxid, c.refs = l.maxid, l.refs
+	return c
 }
 
 // Get returns the association value for a pair of entity ids from a
@@ -220,6 +260,29 @@ func (l *Links) Get(id1, id2 int) (float64, error) {
 
 }
 
+// SetReference sets the sequence dictionary of the reference assembly whose contigs are
+// linked, giving the length of each entity, see Length. The dictionary is recorded in
+// the header of the written links, see Write.
+func (l *Links) SetReference(refs *RefDict) {
+	l.refs = refs
+}
+
+// Reference returns the sequence dictionary of the reference assembly whose contigs are
+// linked, or nil if it is not known.
+func (l *Links) Reference() *RefDict {
+	return l.refs
+}
+
+// Length returns the length of the contig with the specified id, and false if the id is
+// unknown or the reference is not, see SetReference.
+func (l *Links) Length(id int) (int, bool) {
+	name, ok := l.idKeyRev[id]
+	if !ok || l.refs == nil {
+		return 0, false
+	}
+	return l.refs.Length(name)
+}
+
 // Print prints the full set of entity links in a Links object as
 // intID1, intID2, value triplets.
 func (l *Links) Print() {
@@ -237,30 +300,44 @@ func (l *Links) Print() {
 	}
 }
 
-// Write takes an writable os.File pointer and writes the stringID1, stringID2, value
-// triplets for each association value inthe referenced Links object.
-func (l *Links) Write(out *os.File) {
+// Write takes a writer, such as an os.File or the compressed output of CreateOutput,
+// and writes the stringID1, stringID2, value triplets for each association value in
+// the referenced Links object.
+//
+// Entities and their association values are written in the order of their integer ids,
+// such that the output for a given Links object is always the same.
+//
+// If the reference is known, see SetReference, the header also records its source, if
+// any, on a "#reference" line and the length of each of its contigs on a "#length" line,
+// e.g. "#length<TAB>chr1<TAB>248956422", in the order of the reference.
+func (l *Links) Write(out io.Writer) {
 
 	// Compile a header line which will store the mapping between string keys and iteger
 	// keys, with key value separated by ':' and pairs separated by commas
 	// i.e. s:i,s:i,s:i,...
+	ids := l.sortedIDs()
 	header := "#"
-	for k, v := range (*l).idKey {
-		header = header + " " + k + ":" + strconv.Itoa(v)
+	for _, v := range ids {
+		header = header + " " + (*l).idKeyRev[v] + ":" + strconv.Itoa(v)
 	}
 	header += "\n"
-	// Write the header string to the output file.
-	out.WriteString(header)
-
-	// For each key in the top level Links data map
-	for k, _ := range (*l).data {
-		// For each key in the second level Links data map
-		for k2, v := range (*l).data[k] {
-			// Write the string id, string id, float64 triplet to the output file,
-			// delimited by a space.
-			out.WriteString(fmt.Sprintf("%s %s %f\n", l.idKeyRev[k], l.idKeyRev[k2], v))
+	if l.refs != nil {
+		if len(l.refs.Source) > 0 {
+			header += "#reference\t" + l.refs.Source + "\n"
+		}
+		for _, name := range l.refs.Names() {
+			length, _ := l.refs.Length(name)
+			header += fmt.Sprintf("#length\t%s\t%d\n", name, length)
 		}
 	}
+	// Write the header string to the output file.
+	io.WriteString(out, header)
+
+	// Write the string id, string id, float64 triplet of each link to the output file,
+	// delimited by a space.
+	l.Each(func(id1, id2 int, val float64) {
+		fmt.Fprintf(out, "%s %s %f\n", l.idKeyRev[id1], l.idKeyRev[id2], val)
+	})
 }
 
 // Size returns the size of the Links object.
@@ -285,37 +362,31 @@ func (l *Links) Decode(in []int) ([]string, error) {
 	return out, nil
 }
 
+// DecodePhasing maps a phasing of the entities, the phase of each being given in the
+// order of their ids, to their names. The ids need not run from zero, e.g. for links
+// selected from a larger set, see Select.
+//
+// DecodePhasing will return an error if the phasing is not of every entity.
 func (l *Links) DecodePhasing(in []bool) (map[string]bool, error) {
+	ids := l.sortedIDs()
+	if len(in) != len(ids) {
+		return map[string]bool{}, fmt.Errorf("Error decoding links, a phasing of %d entities was given for %d.", len(in), len(ids))
+	}
 	out := make(map[string]bool, len(in))
 	for i, j := range in {
-		val, ok := (*l).idKeyRev[i]
-		if !ok {
-			return map[string]bool{}, fmt.Errorf("Error decoding links, an input id was not recognized.")
-		}
-		out[val] = j
+		out[(*l).idKeyRev[ids[i]]] = j
 	}
 	return out, nil
 
 }
 
-// Subset deletes all elements in a Links object besides those with string ids containing
-// a specified prefix or "tag".
+// Subset keeps only the entities whose names begin with a prefix or "tag" followed by
+// "_", e.g. the variants "X_12345" of chromosome X, and the links between them.
+//
+// Deprecated: use Select, SelectNames or SelectRegexp, which leave the Links object
+// unchanged.
 func (l *Links) Subset(tag string) {
-
-	// For each element in the Links object
-	for s, i := range (*l).idKey {
-
-		// if first part of s doesnt match the tag
-		tagplus := tag + "_"
-		if s[:len(tagplus)] != tagplus {
-			// delete the corresponding entry from both the integer id to string and string
-			// to integer id map.
-			delete((*l).idKeyRev, i)
-			delete((*l).idKey, s)
-		}
-
-	}
-
+	*l = l.Select(func(name string) bool { return strings.HasPrefix(name, tag+"_") })
 }
 
 // TabulateVariantLinks tabulates the in-phase (+1) or out-of-phase (-1) links between
@@ -419,7 +490,15 @@ func (l *Links) TabulateVariantBlockLinks(chrom string, fgp1, fgp2 map[int][]int
 
 }
 
-// LoadLinks loads a set of links from a specified path into a Links object
+// LoadLinks loads a set of links from a specified path into a Links object, along with
+// the sequence dictionary of the reference if it is recorded in the header, see Write.
+// Links in the binary format, see WriteBinary, are detected from their content, and may,
+// like text, be gzipped.
+//
+// The integer id of each entity is that recorded in the file, in the binary format or
+// the "# name:id" header line of the text format, such that ids are stable between
+// writing and reading links. Entities of text links with no such header are assigned ids
+// in the order in which they appear.
 //
 // An error will be returned if no file exists at the specified path or if
 // after reading from this file no links were tabulated (meaning either the file
@@ -429,15 +508,30 @@ func LoadLinks(linksPath string) (Links, error) {
 	// Instantiate a new links object
 	links := NewLinks()
 
-	// Open the input links file for reading, if possible
-	lf, err := os.Open(linksPath)
-	defer lf.Close()
+	// Open the input links file for reading, if possible, decompressing it if it
+	// is gzipped.
+	lf, err := openInput(linksPath)
 	if err != nil {
 		return Links{}, fmt.Errorf("Couldn't open input file with path %s\n", linksPath)
 	}
+	defer lf.Close()
+
+	// If the file starts with the magic number of the binary format, read it as such
+	if magic, _ := lf.Peek(len(binaryLinksMagic)); string(magic) == string(binaryLinksMagic) {
+		links, err := readBinaryLinks(lf)
+		if err != nil {
+			return Links{}, fmt.Errorf("Couldn't read binary links file %s: %s", linksPath, err)
+		}
+		if links.Size() <= 0 {
+			return links, fmt.Errorf("read a binary links file with no entities, resulting in an empty links object.")
+		}
+		return links, nil
+	}
 
-	// Instantiate a new file scanner
+	// Instantiate a new file scanner, allowing for the long header line of links
+	// between many entities
 	s := bufio.NewScanner(lf)
+	s.Buffer(make([]byte, 64*1024), 1024*1024*1024)
 
 	ct := 0
 
@@ -447,7 +541,27 @@ func LoadLinks(linksPath string) (Links, error) {
 		// Get the line
 		line := s.Text()
 		ct += 1
-		if string(line[0]) != "#" {
+		if len(line) == 0 {
+			continue
+		}
+		if ct == 1 && strings.HasPrefix(line, "# ") {
+			// If the first line records the ids of the entities, assign them
+			if err := parseLinksHeader(&links, line); err != nil {
+				return Links{}, fmt.Errorf("Malformed header line in links file %s: %s", linksPath, err)
+			}
+		} else if fields := strings.Split(line, "\t"); fields[0] == "#reference" || fields[0] == "#length" {
+			// If the line records the reference, add it to the sequence dictionary
+			if links.refs == nil {
+				links.refs = NewRefDict("")
+			}
+			if fields[0] == "#reference" && len(fields) == 2 {
+				links.refs.Source = fields[1]
+			} else if length, err := strconv.Atoi(fields[len(fields)-1]); fields[0] == "#length" && len(fields) == 3 && err == nil {
+				links.refs.Add(fields[1], length)
+			} else {
+				return Links{}, fmt.Errorf("Malformed reference line in links file %s: %s", linksPath, line)
+			}
+		} else if string(line[0]) != "#" {
 			// If the line is not a header line
 
 			// Partition the line into space-delimited tokens
@@ -480,6 +594,10 @@ func LoadLinks(linksPath string) (Links, error) {
 
 	}
 
+	if err := s.Err(); err != nil {
+		return Links{}, fmt.Errorf("Couldn't read links file %s: %s", linksPath, err)
+	}
+
 	// If we reach the end of the links file and no links have been tabulated, the file was either
 	// empty or contained only a header, return the empty links object and an error to this effect.
 	if links.Size() <= 0 {
@@ -489,3 +607,33 @@ func LoadLinks(linksPath string) (Links, error) {
 	return links, nil
 
 }
+
+// parseLinksHeader assigns entity ids from the "# name:id" header line of a text links
+// file, see Write. Names may themselves contain ':', the id following the last.
+func parseLinksHeader(l *Links, line string) error {
+
+	for _, pair := range strings.Fields(line[1:]) {
+		i := strings.LastIndex(pair, ":")
+		if i <= 0 {
+			return fmt.Errorf("expected name:id, got %s", pair)
+		}
+		name := pair[:i]
+		id, err := strconv.Atoi(pair[i+1:])
+		if err != nil || id < 0 {
+			return fmt.Errorf("expected name:id, got %s", pair)
+		}
+		if _, ok := l.idKeyRev[id]; ok {
+			return fmt.Errorf("entity id %d appears more than once", id)
+		}
+		if _, ok := l.idKey[name]; ok {
+			return fmt.Errorf("entity %s appears more than once", name)
+		}
+		l.idKey[name], l.idKeyRev[id] = id, name
+		if id >= l.maxid {
+			l.maxid = id + 1
+		}
+	}
+
+	return nil
+
+}
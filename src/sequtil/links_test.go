@@ -100,6 +100,25 @@ func TestAdd(t *testing.T) {
 }
 
 
+func TestAddMulti(t *testing.T) {
+
+	l := NewLinks()
+	l.Add(l.ID("chr1"), l.ID("chr2"), 2)
+
+	partial := NewLinks()
+	partial.Add(partial.ID("chr3"), partial.ID("chr2"), 1)
+	partial.Add(partial.ID("chr2"), partial.ID("chr1"), 1)
+
+	l.AddMulti(partial)
+
+	v12, _ := l.Get(l.ID("chr1"), l.ID("chr2"))
+	v23, _ := l.Get(l.ID("chr2"), l.ID("chr3"))
+	if v12 != 3 || v23 != 1 || l.Size() != 3 {
+		t.Errorf("Links.AddMulti() yielded chr1-chr2 %f and chr2-chr3 %f links, expected 3 and 1", v12, v23)
+	}
+
+}
+
 func TestGet(t *testing.T) {
 
 	l := NewLinks()
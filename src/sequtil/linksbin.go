@@ -0,0 +1,228 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// The binary links format, see WriteBinary, is identified by this magic number, which
+// LoadLinks uses to tell it from the text format, see Write.
+var binaryLinksMagic = []byte("LXYL")
+
+// BinaryLinksVersion is the version of the binary links format written by WriteBinary.
+const BinaryLinksVersion = 1
+
+// BinaryLinksExt is the extension of links files written in the binary format, with or
+// without a ".gz" suffix, see IsBinaryLinksPath.
+const BinaryLinksExt = ".lxyb"
+
+// Flags of the binary links format, recording which optional sections are present.
+const binaryLinksHasReference = 1
+
+// IsBinaryLinksPath determines whether a links file should be written in the binary
+// format, see WriteBinary, rather than as text, from its extension.
+func IsBinaryLinksPath(path string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(strings.TrimSuffix(path, ".gz"), ".bgz"), BinaryLinksExt)
+}
+
+// writeLinks writes links to the output file with the specified path, in the binary
+// format if the path has the binary extension, see IsBinaryLinksPath, and otherwise as
+// text.
+func writeLinks(l *Links, out io.Writer, path string) error {
+	if IsBinaryLinksPath(path) {
+		return l.WriteBinary(out)
+	}
+	l.Write(out)
+	return nil
+}
+
+// WriteLinksFile writes links to a new file at the specified path, in the binary format
+// if the path has the binary extension, see IsBinaryLinksPath, and otherwise as text,
+// compressed according to its extension, see CreateOutput.
+func WriteLinksFile(l *Links, path string) error {
+
+	out, err := CreateOutput(path)
+	if err != nil {
+		return fmt.Errorf("Couldn't open output file (%s) for writing: %s", path, err)
+	}
+	if err := writeLinks(l, out, path); err != nil {
+		out.Close()
+		return fmt.Errorf("Couldn't write links to %s: %s", path, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("Couldn't write links to %s: %s", path, err)
+	}
+	return nil
+
+}
+
+// WriteBinary writes the links in a compact, versioned binary format which, unlike the
+// text format, is read without parsing values and preserves the integer id of each
+// entity. All integers and values are little-endian, strings are prefixed with their
+// length as a uint32, and the file consists of:
+//
+//	magic "LXYL", version (uint32) and flags (uint32, bit 0 set if a reference is present)
+//	the reference, if present, see SetReference: its source (string), number of contigs
+//	(uint32) and each contig's name (string) and length (uint64)
+//	the entity table: the number of entities (uint32) and each entity's id (uint32) and
+//	name (string), in order of id
+//	the values: their number (uint64) and each pair of ids (uint32, the smaller first) and
+//	value (float64), in order of id
+func (l *Links) WriteBinary(out io.Writer) error {
+
+	w := bufio.NewWriter(out)
+	le := binary.LittleEndian
+	buf := make([]byte, 16)
+	putUint32 := func(v int) {
+		le.PutUint32(buf, uint32(v))
+		w.Write(buf[:4])
+	}
+	putString := func(s string) {
+		putUint32(len(s))
+		w.WriteString(s)
+	}
+
+	flags := 0
+	if l.refs != nil {
+		flags |= binaryLinksHasReference
+	}
+	w.Write(binaryLinksMagic)
+	putUint32(BinaryLinksVersion)
+	putUint32(flags)
+
+	if l.refs != nil {
+		putString(l.refs.Source)
+		putUint32(l.refs.Size())
+		for _, name := range l.refs.Names() {
+			length, _ := l.refs.Length(name)
+			putString(name)
+			le.PutUint64(buf, uint64(length))
+			w.Write(buf[:8])
+		}
+	}
+
+	ids := l.sortedIDs()
+	putUint32(len(ids))
+	for _, id := range ids {
+		putUint32(id)
+		putString(l.idKeyRev[id])
+	}
+
+	// Count the values between known entities before writing them.
+	n := 0
+	for id1, sub := range l.data {
+		if _, ok := l.idKeyRev[id1]; !ok {
+			continue
+		}
+		for id2 := range sub {
+			if _, ok := l.idKeyRev[id2]; ok {
+				n += 1
+			}
+		}
+	}
+	le.PutUint64(buf, uint64(n))
+	w.Write(buf[:8])
+
+	l.Each(func(id1, id2 int, val float64) {
+		le.PutUint32(buf, uint32(id1))
+		le.PutUint32(buf[4:], uint32(id2))
+		le.PutUint64(buf[8:], math.Float64bits(val))
+		w.Write(buf)
+	})
+
+	return w.Flush()
+
+}
+
+// readBinaryLinks reads links written in the binary format, see WriteBinary, keeping the
+// integer id of each entity.
+func readBinaryLinks(in io.Reader) (Links, error) {
+
+	links := NewLinks()
+	le := binary.LittleEndian
+	buf := make([]byte, 16)
+	var err error
+	read := func(n int) []byte {
+		if err == nil {
+			if _, err = io.ReadFull(in, buf[:n]); err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+		}
+		if err != nil {
+			return make([]byte, n)
+		}
+		return buf[:n]
+	}
+	getUint32 := func() int {
+		return int(le.Uint32(read(4)))
+	}
+	getString := func() string {
+		n := getUint32()
+		if err != nil {
+			return ""
+		}
+		// The string is copied as it is read rather than allocated from its length, which
+		// is unchecked, such that a corrupt length fails at the end of the file.
+		var b bytes.Buffer
+		if _, err = io.CopyN(&b, in, int64(n)); err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return b.String()
+	}
+
+	if magic := read(4); err == nil && string(magic) != string(binaryLinksMagic) {
+		return Links{}, fmt.Errorf("Not a binary links file")
+	}
+	version, flags := getUint32(), getUint32()
+	if err == nil && version != BinaryLinksVersion {
+		return Links{}, fmt.Errorf("Unsupported binary links version %d, expected %d", version, BinaryLinksVersion)
+	}
+
+	if flags&binaryLinksHasReference != 0 {
+		links.refs = NewRefDict(getString())
+		for i, n := 0, getUint32(); i < n && err == nil; i++ {
+			name := getString()
+			links.refs.Add(name, int(le.Uint64(read(8))))
+		}
+	}
+
+	for i, n := 0, getUint32(); i < n && err == nil; i++ {
+		id, name := getUint32(), getString()
+		if err != nil {
+			break
+		}
+		if _, ok := links.idKeyRev[id]; ok {
+			return Links{}, fmt.Errorf("Entity id %d appears more than once", id)
+		}
+		if _, ok := links.idKey[name]; ok {
+			return Links{}, fmt.Errorf("Entity %s appears more than once", name)
+		}
+		links.idKey[name], links.idKeyRev[id] = id, name
+		if id >= links.maxid {
+			links.maxid = id + 1
+		}
+	}
+
+	n := le.Uint64(read(8))
+	for i := uint64(0); i < n && err == nil; i++ {
+		b := read(16)
+		if err != nil {
+			break
+		}
+		id1, id2 := int(le.Uint32(b)), int(le.Uint32(b[4:]))
+		if e := links.Set(id1, id2, math.Float64frombits(le.Uint64(b[8:]))); e != nil {
+			return Links{}, e
+		}
+	}
+
+	if err != nil {
+		return Links{}, err
+	}
+	return links, nil
+
+}
@@ -0,0 +1,99 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testLinks returns links between entities whose ids differ from the order in which
+// they first appear in written links, with a reference giving their lengths.
+func testLinks() Links {
+	l := NewLinks()
+	for _, name := range []string{"chr3", "chr1", "chr:2", "unlinked"} {
+		l.ID(name)
+	}
+	l.Set(l.ID("chr1"), l.ID("chr:2"), 2.5)
+	l.Set(l.ID("chr3"), l.ID("chr:2"), -1)
+	l.Set(l.ID("chr1"), l.ID("chr1"), 7)
+	refs := NewRefDict("ref.fa")
+	refs.Add("chr1", 1000)
+	refs.Add("chr:2", 500)
+	refs.Add("chr3", 250)
+	l.SetReference(refs)
+	return l
+}
+
+func TestBinaryLinks(t *testing.T) {
+
+	dir := "/tmp/lxy/test/linksbin/"
+	os.RemoveAll(dir)
+	os.MkdirAll(dir, 0755)
+	links := testLinks()
+
+	// Both formats, gzipped or not, are read with the same ids and reference.
+	for _, path := range []string{"links.lxyb", "links.lxyb.gz", "links.txt", "links.txt.gz"} {
+		path = filepath.Join(dir, path)
+		out, err := CreateOutput(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writeLinks(&links, out, path); err != nil {
+			t.Fatal(err)
+		}
+		out.Close()
+
+		loaded, err := LoadLinks(path)
+		if err != nil {
+			t.Errorf("LoadLinks(%s): %s", path, err)
+			continue
+		}
+		if !reflect.DeepEqual(loaded, links) {
+			t.Errorf("LoadLinks(%s) yielded links not matching those written", path)
+		}
+		if l, ok := loaded.Length(loaded.ID("chr:2")); !ok || l != 500 {
+			t.Errorf("LoadLinks(%s) yielded length %d for chr:2, expected 500", path, l)
+		}
+	}
+
+	if !IsBinaryLinksPath("a.lxyb.gz") || IsBinaryLinksPath("a.links") {
+		t.Errorf("IsBinaryLinksPath didn't recognise binary links paths by their extension")
+	}
+
+	// Links with no reference are written without one.
+	noref := NewLinks()
+	noref.Set(noref.ID("a"), noref.ID("b"), 1)
+	path := filepath.Join(dir, "noref.lxyb")
+	out, _ := CreateOutput(path)
+	noref.WriteBinary(out)
+	out.Close()
+	if loaded, err := LoadLinks(path); err != nil || !reflect.DeepEqual(loaded, noref) {
+		t.Errorf("LoadLinks(%s) yielded links not matching those written (%v)", path, err)
+	}
+
+	// Truncated, duplicated and unknown versions are errors.
+	b, err := ioutil.ReadFile(filepath.Join(dir, "links.lxyb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dir, "truncated.lxyb"), string(b[:len(b)-3]))
+	version := append([]byte{}, b...)
+	version[4] = 9
+	writeTestFile(t, filepath.Join(dir, "version.lxyb"), string(version))
+	// A corrupt string length, that of the reference source, isn't allocated.
+	length := append([]byte{}, b...)
+	copy(length[12:16], []byte{0xff, 0xff, 0xff, 0xff})
+	writeTestFile(t, filepath.Join(dir, "length.lxyb"), string(length))
+	for _, name := range []string{"truncated.lxyb", "version.lxyb", "length.lxyb"} {
+		if _, err := LoadLinks(filepath.Join(dir, name)); err == nil {
+			t.Errorf("Expected an error loading %s", name)
+		}
+	}
+	writeTestFile(t, filepath.Join(dir, "duplicate.links"), "# a:0 b:0\na b 1\n")
+	if _, err := LoadLinks(filepath.Join(dir, "duplicate.links")); err == nil {
+		t.Errorf("Expected an error loading links with duplicate ids")
+	}
+
+}
@@ -0,0 +1,513 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/codegangsta/cli"
+)
+
+// LinksCommand returns the command group for working with links files.
+func LinksCommand() cli.Command {
+
+	return cli.Command{
+		Name:  "links",
+		Usage: "A set of utility functions for working with links files.",
+		Subcommands: []cli.Command{
+			cli.Command{
+				Name:   "normalize",
+				Usage:  "Normalize a set of links for the biases of each contig, by balancing their contact matrix or by contig length or restriction site count.",
+				Action: normalizeCommand,
+				Flags: append([]cli.Flag{
+					cli.StringFlag{
+						Name:  "links",
+						Value: "",
+						Usage: "Path to the links file to normalize.",
+					},
+					cli.StringFlag{
+						Name:  "output",
+						Value: "",
+						Usage: "Path to which to write the normalized links, in the binary links format if it has the extension .lxyb, standard output if not given.",
+					},
+					cli.StringFlag{
+						Name:  "bias",
+						Value: "",
+						Usage: "Optional path to which to write the bias of each contig found by balancing, the balanced links being the raw links divided by the product of their biases.",
+					},
+					cli.StringFlag{
+						Name:  "method",
+						Value: BalanceICE,
+						Usage: "Normalization method, one of " + strings.Join(NormalizeMethods, ", ") + ".",
+					},
+					cli.StringFlag{
+						Name:  "ref",
+						Value: "",
+						Usage: "Reference FASTA or .fai giving contig lengths, in place of those recorded in the links, or the FASTA in which to count restriction sites.",
+					},
+				}, NormalizeFlags()...),
+			},
+			cli.Command{
+				Name:   "merge",
+				Usage:  "Merge the links of several files, e.g. of replicate libraries, by summing or averaging their values.",
+				Action: mergeCommand,
+				Flags: []cli.Flag{
+					cli.StringSliceFlag{
+						Name:  "links",
+						Usage: "Path to a links file to merge, given once for each file.",
+					},
+					cli.StringFlag{
+						Name:  "output",
+						Value: "",
+						Usage: "Path to which to write the merged links, in the binary links format if it has the extension .lxyb, standard output if not given.",
+					},
+					cli.BoolFlag{
+						Name:  "mean",
+						Usage: "Whether to average the values of the files rather than summing them.",
+					},
+				},
+			},
+			cli.Command{
+				Name:   "subset",
+				Usage:  "Keep the links between the entities given in a list or matching a regular expression.",
+				Action: subsetCommand,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "links",
+						Value: "",
+						Usage: "Path to the links file to subset.",
+					},
+					cli.StringFlag{
+						Name:  "entities",
+						Value: "",
+						Usage: "Path to a file listing the names of the entities to keep, the first field of each line.",
+					},
+					cli.StringFlag{
+						Name:  "regex",
+						Value: "",
+						Usage: "Regular expression matching the names of the entities to keep, e.g. ^chr1_.",
+					},
+					cli.StringFlag{
+						Name:  "output",
+						Value: "",
+						Usage: "Path to which to write the subset links, in the binary links format if it has the extension .lxyb, standard output if not given.",
+					},
+				},
+			},
+			cli.Command{
+				Name:   "threshold",
+				Usage:  "Drop weak links, whose values are smaller in magnitude than a minimum.",
+				Action: thresholdCommand,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "links",
+						Value: "",
+						Usage: "Path to the links file to threshold.",
+					},
+					cli.Float64Flag{
+						Name:  "min",
+						Value: 1,
+						Usage: "The smallest magnitude of the values of links which are kept.",
+					},
+					cli.StringFlag{
+						Name:  "output",
+						Value: "",
+						Usage: "Path to which to write the remaining links, in the binary links format if it has the extension .lxyb, standard output if not given.",
+					},
+				},
+			},
+			cli.Command{
+				Name:   "split",
+				Usage:  "Split links into a file for each prefix of the entity names, e.g. for each chromosome, dropping links between prefixes.",
+				Action: splitLinksCommand,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "links",
+						Value: "",
+						Usage: "Path to the links file to split.",
+					},
+					cli.StringFlag{
+						Name:  "outstem",
+						Value: "",
+						Usage: "Output path stem, to which the prefix and extension are appended.",
+					},
+					cli.StringFlag{
+						Name:  "sep",
+						Value: "_",
+						Usage: "The separator following the prefix of each entity name.",
+					},
+					cli.BoolFlag{
+						Name:  "binary",
+						Usage: "Whether to write the links in the binary links format, with the extension .lxyb rather than .links.",
+					},
+				},
+			},
+			cli.Command{
+				Name:   "diff",
+				Usage:  "Compare two links files, writing the log2 ratio of the values of the first to those of the second.",
+				Action: diffCommand,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "links1",
+						Value: "",
+						Usage: "Path to the first links file, the numerator of the ratios.",
+					},
+					cli.StringFlag{
+						Name:  "links2",
+						Value: "",
+						Usage: "Path to the second links file, the denominator of the ratios.",
+					},
+					cli.Float64Flag{
+						Name:  "pseudocount",
+						Value: 1,
+						Usage: "Pseudocount added to each value, such that links in only one file have finite ratios.",
+					},
+					cli.BoolFlag{
+						Name:  "scale",
+						Usage: "Whether to scale the values of the second file to the total of the first, as for libraries sequenced to different depths.",
+					},
+					cli.StringFlag{
+						Name:  "output",
+						Value: "",
+						Usage: "Path to which to write the log ratio links, in the binary links format if it has the extension .lxyb, standard output if not given.",
+					},
+				},
+			},
+			cli.Command{
+				Name:   "query",
+				Usage:  "Print the partners of an entity, those it is most strongly linked to first, or the totals of cis and trans links.",
+				Action: queryCommand,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "links",
+						Value: "",
+						Usage: "Path to the links file to query.",
+					},
+					cli.StringFlag{
+						Name:  "entity",
+						Value: "",
+						Usage: "Name of the entity, e.g. a contig, whose partners to print, the totals of cis and trans links being printed if not given.",
+					},
+					cli.IntFlag{
+						Name:  "top",
+						Value: 0,
+						Usage: "Number of partners to print, all if zero.",
+					},
+					cli.StringFlag{
+						Name:  "sep",
+						Value: "",
+						Usage: "The separator following the prefix of each entity name, e.g. the chromosome, links between entities of a prefix being cis, or if empty cis links being those of entities with themselves.",
+					},
+					cli.StringFlag{
+						Name:  "output",
+						Value: "",
+						Usage: "Path to which to write the results, standard output if not given.",
+					},
+				},
+			},
+		},
+	}
+
+}
+
+// BalanceFlags returns the command line flags configuring the convergence of the
+// balancing of links, see BalanceFromContext.
+func BalanceFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.IntFlag{
+			Name:  "balanceIterations",
+			Value: 200,
+			Usage: "Maximum number of iterations with which to balance links.",
+		},
+		cli.Float64Flag{
+			Name:  "balanceTolerance",
+			Value: 1e-5,
+			Usage: "Tolerance of the deviation of the row sums of balanced links from their target.",
+		},
+	}
+}
+
+// NormalizeFlags returns the command line flags configuring the normalization of links,
+// see NormalizeFromContext, other than the method and the reference.
+func NormalizeFlags() []cli.Flag {
+	return append([]cli.Flag{
+		cli.StringFlag{
+			Name:  "enzyme",
+			Value: "",
+			Usage: "Restriction enzyme whose sites are counted to normalize links by site count, e.g. DpnII, HindIII or Arima, or sites such as ^GATC,G^ANTC.",
+		},
+	}, BalanceFlags()...)
+}
+
+// NormalizeFromContext returns the options with which to normalize links by the
+// specified method, configured by the flags of NormalizeFlags and the reference given by
+// the flag ref.
+func NormalizeFromContext(c *cli.Context, method string) (NormalizeOptions, error) {
+	opts := NormalizeOptions{Method: method, Ref: c.String("ref"), Balance: BalanceFromContext(c, method)}
+	if len(c.String("enzyme")) > 0 {
+		enzyme, err := ParseEnzyme(c.String("enzyme"))
+		if err != nil {
+			return opts, err
+		}
+		opts.Enzyme = enzyme
+	}
+	return opts, opts.Check()
+}
+
+// BalanceFromContext returns the options with which to balance links by the specified
+// method, configured by the flags of BalanceFlags.
+func BalanceFromContext(c *cli.Context, method string) BalanceOptions {
+	return BalanceOptions{
+		Method:        method,
+		MaxIterations: c.Int("balanceIterations"),
+		Tolerance:     c.Float64("balanceTolerance"),
+	}
+}
+
+func normalizeCommand(c *cli.Context) {
+
+	if len(c.String("links")) == 0 {
+		fmt.Printf("error: must provide a path to a links file with --links\n")
+		return
+	}
+	links, err := LoadLinks(c.String("links"))
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		return
+	}
+
+	opts, err := NormalizeFromContext(c, c.String("method"))
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		return
+	}
+
+	// The biases are those found by balancing, links normalized otherwise having none.
+	var normalized Links
+	var bias map[int]float64
+	if method := strings.ToLower(opts.Method); method == BalanceICE || method == BalanceKR {
+		normalized, bias, err = links.Balance(opts.Balance)
+	} else if len(c.String("bias")) > 0 {
+		err = fmt.Errorf("Only links normalized by balancing, with %s, have biases to write with --bias", strings.Join(BalanceMethods, " or "))
+	} else {
+		normalized, err = links.Normalize(opts)
+	}
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		return
+	}
+
+	if err := writeLinksOutput(&normalized, c.String("output")); err != nil {
+		fmt.Printf("error: %s\n", err)
+		return
+	}
+
+	if len(c.String("bias")) > 0 {
+		f, err := CreateOutput(c.String("bias"))
+		if err != nil {
+			fmt.Printf("Couldn't open output file (%s) for writing: %s\n", c.String("bias"), err)
+			return
+		}
+		defer closeOutput(f, c.String("bias"))
+		links.WriteBias(f, bias)
+	}
+
+}
+
+// writeLinksOutput writes links to the specified path, in the binary format if it has
+// the binary extension, see IsBinaryLinksPath, or otherwise as text to the path or, if
+// it is empty, to standard output.
+func writeLinksOutput(l *Links, path string) error {
+
+	if len(path) > 0 {
+		return WriteLinksFile(l, path)
+	}
+	return writeLinks(l, os.Stdout, path)
+
+}
+
+func mergeCommand(c *cli.Context) {
+
+	if len(c.StringSlice("links")) < 2 {
+		fmt.Printf("error: must provide at least two links files with --links\n")
+		return
+	}
+
+	all := []*Links{}
+	for _, path := range c.StringSlice("links") {
+		links, err := LoadLinks(path)
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			return
+		}
+		all = append(all, &links)
+	}
+
+	merged, err := MergeLinks(all, c.Bool("mean"))
+	if err == nil {
+		err = writeLinksOutput(&merged, c.String("output"))
+	}
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+	}
+
+}
+
+func subsetCommand(c *cli.Context) {
+
+	if len(c.String("links")) == 0 {
+		fmt.Printf("error: must provide a path to a links file with --links\n")
+		return
+	}
+	if (len(c.String("entities")) == 0) == (len(c.String("regex")) == 0) {
+		fmt.Printf("error: must provide either --entities or --regex\n")
+		return
+	}
+	links, err := LoadLinks(c.String("links"))
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		return
+	}
+
+	var subset Links
+	if len(c.String("regex")) > 0 {
+		re, err := regexp.Compile(c.String("regex"))
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			return
+		}
+		subset = links.SelectRegexp(re)
+	} else {
+		names, err := ReadNames(c.String("entities"))
+		if err != nil {
+			fmt.Printf("error: %s\n", err)
+			return
+		}
+		subset = links.SelectNames(names)
+	}
+
+	if err := writeLinksOutput(&subset, c.String("output")); err != nil {
+		fmt.Printf("error: %s\n", err)
+	}
+
+}
+
+func thresholdCommand(c *cli.Context) {
+
+	if len(c.String("links")) == 0 {
+		fmt.Printf("error: must provide a path to a links file with --links\n")
+		return
+	}
+	links, err := LoadLinks(c.String("links"))
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		return
+	}
+
+	kept := links.Threshold(c.Float64("min"))
+	if err := writeLinksOutput(&kept, c.String("output")); err != nil {
+		fmt.Printf("error: %s\n", err)
+	}
+
+}
+
+func splitLinksCommand(c *cli.Context) {
+
+	if len(c.String("links")) == 0 || len(c.String("outstem")) == 0 {
+		fmt.Printf("error: must provide --links and --outstem\n")
+		return
+	}
+	links, err := LoadLinks(c.String("links"))
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		return
+	}
+
+	ext := ".links"
+	if c.Bool("binary") {
+		ext = BinaryLinksExt
+	}
+	for prefix, part := range links.Split(c.String("sep")) {
+		path := c.String("outstem") + "." + strings.Replace(prefix, string(os.PathSeparator), "_", -1) + ext
+		if err := writeLinksOutput(&part, path); err != nil {
+			fmt.Printf("error: %s\n", err)
+			return
+		}
+	}
+
+}
+
+func diffCommand(c *cli.Context) {
+
+	if len(c.String("links1")) == 0 || len(c.String("links2")) == 0 {
+		fmt.Printf("error: must provide two links files with --links1 and --links2\n")
+		return
+	}
+	links1, err := LoadLinks(c.String("links1"))
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		return
+	}
+	links2, err := LoadLinks(c.String("links2"))
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		return
+	}
+
+	diff, err := DiffLinks(&links1, &links2, c.Float64("pseudocount"), c.Bool("scale"))
+	if err == nil {
+		err = writeLinksOutput(&diff, c.String("output"))
+	}
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+	}
+
+}
+
+func queryCommand(c *cli.Context) {
+
+	if len(c.String("links")) == 0 {
+		fmt.Printf("error: must provide a path to a links file with --links\n")
+		return
+	}
+	links, err := LoadLinks(c.String("links"))
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		return
+	}
+
+	out := io.Writer(os.Stdout)
+	if len(c.String("output")) > 0 {
+		f, err := CreateOutput(c.String("output"))
+		if err != nil {
+			fmt.Printf("Couldn't open output file (%s) for writing: %s\n", c.String("output"), err)
+			return
+		}
+		defer closeOutput(f, c.String("output"))
+		out = f
+	}
+
+	if len(c.String("entity")) == 0 {
+		cis, trans := links.CisTrans(c.String("sep"))
+		fmt.Fprintf(out, "cis\t%f\ntrans\t%f\n", cis, trans)
+		return
+	}
+
+	// Look the entity up without adding it, as ID would.
+	id, ok := links.idKey[c.String("entity")]
+	if !ok {
+		fmt.Printf("error: no entity %s in the links file %s\n", c.String("entity"), c.String("links"))
+		return
+	}
+	// The row sum and top partners are derived from a single scan of the links.
+	neighbors, _ := links.Neighbors(id)
+	self, _ := links.Get(id, id)
+
+	fmt.Fprintf(out, "#entity\t%s\n#self\t%f\n#rowSum\t%f\n#partners\t%d\n", c.String("entity"), self, partnerSum(neighbors), len(neighbors))
+	for _, p := range topPartners(neighbors, c.Int("top")) {
+		fmt.Fprintf(out, "%s\t%f\n", links.idKeyRev[p.ID], p.Value)
+	}
+
+}
@@ -0,0 +1,264 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// Select returns the links between the entities for which keep returns true, given their
+// names, with the same ids and reference as this Links object, which is unchanged. The
+// ids of the selected entities therefore need not run from zero, and are kept when the
+// links are written and loaded, such that consumers, e.g. phasing, see DecodePhasing, take
+// the entities in the order of their ids rather than indexing by them.
+func (l *Links) Select(keep func(name string) bool) Links {
+
+	selected := NewLinks()
+	for name, id := range l.idKey {
+		if keep(name) {
+			selected.idKey[name], selected.idKeyRev[id] = id, name
+		}
+	}
+	selected.maxid, selected.refs = l.maxid, l.refs
+
+	for id1, sub := range l.data {
+		if _, ok := selected.idKeyRev[id1]; !ok {
+			continue
+		}
+		for id2, v := range sub {
+			if _, ok := selected.idKeyRev[id2]; ok {
+				selected.Set(id1, id2, v)
+			}
+		}
+	}
+
+	return selected
+
+}
+
+// SelectNames returns the links between the named entities, see Select. Names of which
+// there is no entity are ignored.
+func (l *Links) SelectNames(names []string) Links {
+	set := map[string]bool{}
+	for _, name := range names {
+		set[name] = true
+	}
+	return l.Select(func(name string) bool { return set[name] })
+}
+
+// SelectRegexp returns the links between the entities whose names match a regular
+// expression, see Select.
+func (l *Links) SelectRegexp(re *regexp.Regexp) Links {
+	return l.Select(re.MatchString)
+}
+
+// ReadNames reads a list of entity names, such as contigs, from the first field of each
+// line of a file. Blank lines and lines beginning with "#" are skipped.
+func ReadNames(path string) ([]string, error) {
+
+	in, err := OpenInput(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	names := []string{}
+	s := bufio.NewScanner(in)
+	for s.Scan() {
+		if fields := strings.Fields(s.Text()); len(fields) > 0 && !strings.HasPrefix(fields[0], "#") {
+			names = append(names, fields[0])
+		}
+	}
+
+	return names, s.Err()
+
+}
+
+// Threshold returns the links whose values are at least min in magnitude, such that the
+// strong negative links between variants out of phase are kept along with strong
+// positive ones. All entities are kept, with the same ids.
+func (l *Links) Threshold(min float64) Links {
+
+	kept := l.copyEntities()
+	for id1, sub := range l.data {
+		for id2, v := range sub {
+			if math.Abs(v) >= min {
+				kept.Set(id1, id2, v)
+			}
+		}
+	}
+
+	return kept
+
+}
+
+// namePrefix returns the part of an entity name preceding the first occurrence of a
+// separator, or the whole name if it has none.
+func namePrefix(name, sep string) string {
+	if i := strings.Index(name, sep); i >= 0 && len(sep) > 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// Split partitions links by the prefix of the names of their entities, the part of each
+// name preceding the first occurrence of a separator, e.g. the chromosome "chr1" of the
+// entities "chr1_1" and "chr1_2" with the separator "_". It returns the links between the
+// entities of each prefix, see Select, while links between entities of different
+// prefixes are dropped.
+func (l *Links) Split(sep string) map[string]Links {
+
+	prefixes := map[string]bool{}
+	for name := range l.idKey {
+		prefixes[namePrefix(name, sep)] = true
+	}
+
+	parts := map[string]Links{}
+	for prefix := range prefixes {
+		p := prefix
+		parts[p] = l.Select(func(name string) bool { return namePrefix(name, sep) == p })
+	}
+
+	return parts
+
+}
+
+// unionEntities returns an empty Links object with the entities of each of a set of
+// links, those of the first keeping their ids and those of the others being assigned
+// new ones in the order of their ids. The reference is that of the first links with
+// one, and an error is returned if the others' differ, see RefDict.Check.
+func unionEntities(links []*Links) (Links, error) {
+
+	union := NewLinks()
+	if len(links) > 0 {
+		union = links[0].copyEntities()
+	}
+	for _, l := range links {
+		for _, id := range l.sortedIDs() {
+			union.ID(l.idKeyRev[id])
+		}
+		if l.refs == nil {
+			continue
+		} else if union.refs == nil {
+			union.refs = l.refs
+		} else if err := union.refs.Check(l.refs); err != nil {
+			return Links{}, fmt.Errorf("Couldn't combine links to different references: %s", err)
+		}
+	}
+
+	return union, nil
+
+}
+
+// MergeLinks combines sets of links, such as those of replicate Hi-C libraries, by
+// summing their values or, if mean is true, averaging them, links absent from a set
+// having a value of zero. Entities are matched by name, those of the first set keeping
+// their ids, see unionEntities.
+func MergeLinks(links []*Links, mean bool) (Links, error) {
+
+	merged, err := unionEntities(links)
+	if err != nil {
+		return Links{}, err
+	}
+
+	for _, l := range links {
+		for id1, sub := range l.data {
+			name1, ok := l.idKeyRev[id1]
+			if !ok {
+				continue
+			}
+			for id2, v := range sub {
+				if name2, ok := l.idKeyRev[id2]; ok {
+					merged.Add(merged.idKey[name1], merged.idKey[name2], v)
+				}
+			}
+		}
+	}
+
+	if mean && len(links) > 0 {
+		for _, sub := range merged.data {
+			for id2 := range sub {
+				sub[id2] /= float64(len(links))
+			}
+		}
+	}
+
+	return merged, nil
+
+}
+
+// DiffLinks compares two sets of links, returning for each pair of entities linked in
+// either the log2 ratio of its value in a to that in b, each with a pseudocount added
+// such that pairs linked in only one set have a finite ratio. If scale is true, the
+// values of b are first scaled to the same total as those of a, as for libraries
+// sequenced to different depths. Entities are matched by name, see unionEntities, and
+// an error is returned if a value plus the pseudocount is not positive.
+func DiffLinks(a, b *Links, pseudocount float64, scale bool) (Links, error) {
+
+	diff, err := unionEntities([]*Links{a, b})
+	if err != nil {
+		return Links{}, err
+	}
+
+	factor := 1.0
+	if scale {
+		totalA, totalB := a.total(), b.total()
+		if totalB == 0 {
+			return Links{}, fmt.Errorf("Couldn't scale links with a total of zero")
+		}
+		factor = totalA / totalB
+	}
+
+	// Tabulate the values of each pair in both sets by the ids of the union.
+	values := map[[2]int][2]float64{}
+	for i, l := range []*Links{a, b} {
+		for id1, sub := range l.data {
+			name1, ok := l.idKeyRev[id1]
+			if !ok {
+				continue
+			}
+			for id2, v := range sub {
+				name2, ok := l.idKeyRev[id2]
+				if !ok {
+					continue
+				}
+				u1, u2 := diff.idKey[name1], diff.idKey[name2]
+				if u1 > u2 {
+					u1, u2 = u2, u1
+				}
+				pair := values[[2]int{u1, u2}]
+				pair[i] = v
+				values[[2]int{u1, u2}] = pair
+			}
+		}
+	}
+
+	for ids, v := range values {
+		va, vb := v[0]+pseudocount, v[1]*factor+pseudocount
+		if va <= 0 || vb <= 0 {
+			return Links{}, fmt.Errorf("Couldn't take the log ratio of %f and %f for %s %s, which must be positive with the pseudocount", v[0], v[1], diff.idKeyRev[ids[0]], diff.idKeyRev[ids[1]])
+		}
+		diff.Set(ids[0], ids[1], math.Log2(va/vb))
+	}
+
+	return diff, nil
+
+}
+
+// total returns the sum of the values of the links between known entities.
+func (l *Links) total() float64 {
+	total := 0.0
+	for id1, sub := range l.data {
+		if _, ok := l.idKeyRev[id1]; !ok {
+			continue
+		}
+		for id2, v := range sub {
+			if _, ok := l.idKeyRev[id2]; ok {
+				total += v
+			}
+		}
+	}
+	return total
+}
@@ -0,0 +1,145 @@
+package util
+
+import (
+	"math"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+)
+
+// linkValues returns the values of a set of links by the names of their entities, the
+// smaller name first.
+func linkValues(l *Links) map[[2]string]float64 {
+	values := map[[2]string]float64{}
+	for id1, sub := range l.data {
+		for id2, v := range sub {
+			a, b := l.idKeyRev[id1], l.idKeyRev[id2]
+			if a > b {
+				a, b = b, a
+			}
+			values[[2]string{a, b}] = v
+		}
+	}
+	return values
+}
+
+func TestSelectLinks(t *testing.T) {
+
+	l := NewLinks()
+	l.Set(l.ID("chr1_1"), l.ID("chr1_2"), 3)
+	l.Set(l.ID("chr1_2"), l.ID("chr2_1"), 2)
+	l.Set(l.ID("chr2_1"), l.ID("chr2_2"), -4)
+	l.ID("chr10_1")
+
+	subset := l.SelectRegexp(regexp.MustCompile("^chr1_"))
+	if v := linkValues(&subset); !reflect.DeepEqual(v, map[[2]string]float64{{"chr1_1", "chr1_2"}: 3}) || subset.Size() != 2 {
+		t.Errorf("SelectRegexp yielded links %v of %d entities", v, subset.Size())
+	}
+	if subset.ID("chr1_2") != l.ID("chr1_2") {
+		t.Errorf("SelectRegexp didn't keep the ids of the entities")
+	}
+
+	dir := "/tmp/lxy/test/linksops/"
+	os.RemoveAll(dir)
+	writeTestFile(t, dir+"names.txt", "# contigs\nchr1_2\tfirst\n\nchr2_1\nchr3_1\n")
+	names, err := ReadNames(dir + "names.txt")
+	if err != nil || !reflect.DeepEqual(names, []string{"chr1_2", "chr2_1", "chr3_1"}) {
+		t.Errorf("ReadNames yielded %v (%v)", names, err)
+	}
+	subset = l.SelectNames(names)
+	if v := linkValues(&subset); !reflect.DeepEqual(v, map[[2]string]float64{{"chr1_2", "chr2_1"}: 2}) {
+		t.Errorf("SelectNames yielded links %v", v)
+	}
+
+	// The deprecated Subset doesn't fail on names shorter than the tag.
+	tagged := l.Select(func(string) bool { return true })
+	tagged.ID("X")
+	tagged.Subset("chr2")
+	if v := linkValues(&tagged); !reflect.DeepEqual(v, map[[2]string]float64{{"chr2_1", "chr2_2"}: -4}) {
+		t.Errorf("Subset yielded links %v", v)
+	}
+
+	kept := l.Threshold(3)
+	if v := linkValues(&kept); !reflect.DeepEqual(v, map[[2]string]float64{{"chr1_1", "chr1_2"}: 3, {"chr2_1", "chr2_2"}: -4}) || kept.Size() != l.Size() {
+		t.Errorf("Threshold yielded links %v", v)
+	}
+
+	parts := l.Split("_")
+	prefixes := []string{}
+	for prefix := range parts {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	if !reflect.DeepEqual(prefixes, []string{"chr1", "chr10", "chr2"}) {
+		t.Errorf("Split yielded prefixes %v", prefixes)
+	}
+	chr2 := parts["chr2"]
+	if v := linkValues(&chr2); !reflect.DeepEqual(v, map[[2]string]float64{{"chr2_1", "chr2_2"}: -4}) {
+		t.Errorf("Split yielded links %v for chr2", v)
+	}
+	// A phasing of the entities of a part is given in the order of their ids, which don't
+	// run from zero.
+	phasing, err := chr2.DecodePhasing([]bool{true, false})
+	if err != nil || !reflect.DeepEqual(phasing, map[string]bool{"chr2_1": true, "chr2_2": false}) {
+		t.Errorf("DecodePhasing yielded %v (%v) for chr2", phasing, err)
+	}
+	if _, err := chr2.DecodePhasing([]bool{true}); err == nil {
+		t.Errorf("Expected an error decoding a phasing of too few entities")
+	}
+
+}
+
+func TestMergeLinks(t *testing.T) {
+
+	a, b := NewLinks(), NewLinks()
+	a.Set(a.ID("c1"), a.ID("c2"), 4)
+	a.Set(a.ID("c2"), a.ID("c3"), 2)
+	b.Set(b.ID("c4"), b.ID("c2"), 6)
+	b.Set(b.ID("c2"), b.ID("c1"), 8)
+
+	merged, err := MergeLinks([]*Links{&a, &b}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[[2]string]float64{{"c1", "c2"}: 12, {"c2", "c3"}: 2, {"c2", "c4"}: 6}
+	if v := linkValues(&merged); !reflect.DeepEqual(v, expected) {
+		t.Errorf("MergeLinks yielded %v, expected %v", v, expected)
+	}
+	if merged.ID("c3") != a.ID("c3") || merged.ID("c4") != 3 {
+		t.Errorf("MergeLinks didn't keep the ids of the first links")
+	}
+	mean, _ := MergeLinks([]*Links{&a, &b}, true)
+	if v, _ := mean.Get(mean.ID("c1"), mean.ID("c2")); v != 6 {
+		t.Errorf("MergeLinks yielded a mean of %f, expected 6", v)
+	}
+
+	// Links to different references can't be combined.
+	refs1, refs2 := NewRefDict("a.fa"), NewRefDict("b.fa")
+	refs1.Add("c1", 100)
+	refs2.Add("c1", 200)
+	a.SetReference(refs1)
+	b.SetReference(refs2)
+	if _, err := MergeLinks([]*Links{&a, &b}, false); err == nil {
+		t.Errorf("Expected an error merging links to different references")
+	}
+	b.SetReference(nil)
+
+	diff, err := DiffLinks(&a, &b, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = map[[2]string]float64{{"c1", "c2"}: math.Log2(5.0 / 9), {"c2", "c3"}: math.Log2(3), {"c2", "c4"}: math.Log2(1.0 / 7)}
+	if v := linkValues(&diff); !reflect.DeepEqual(v, expected) {
+		t.Errorf("DiffLinks yielded %v, expected %v", v, expected)
+	}
+	diff, _ = DiffLinks(&a, &b, 1, true)
+	if v, _ := diff.Get(diff.ID("c1"), diff.ID("c2")); math.Abs(v-math.Log2(5.0/(8*6.0/14+1))) > 1e-12 {
+		t.Errorf("DiffLinks yielded %f for c1 c2 with scaling", v)
+	}
+	if _, err := DiffLinks(&a, &b, 0, false); err == nil {
+		t.Errorf("Expected an error taking the log ratio of zero")
+	}
+
+}
@@ -0,0 +1,131 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Partner is an entity linked to another, with the association value of the link
+// between them, see Links.Neighbors.
+type Partner struct {
+	ID    int
+	Value float64
+}
+
+// Each calls f with each pair of linked entities and their association value, in a
+// deterministic order: by the smaller id of the pair, id1, and then the larger, id2.
+func (l *Links) Each(f func(id1, id2 int, val float64)) {
+	for _, id1 := range l.sortedIDs() {
+		sub, ok := l.data[id1]
+		if !ok {
+			continue
+		}
+		ids2 := make([]int, 0, len(sub))
+		for id2 := range sub {
+			if _, ok := l.idKeyRev[id2]; ok {
+				ids2 = append(ids2, id2)
+			}
+		}
+		sort.Ints(ids2)
+		for _, id2 := range ids2 {
+			f(id1, id2, sub[id2])
+		}
+	}
+}
+
+// Neighbors returns the entities linked to an entity, other than itself, in the order
+// of their ids. As links are stored by the smaller id of each pair, this takes time
+// proportional to the number of links, so several queries of the same entity, e.g. its
+// row sum and top partners, are best derived from a single call.
+//
+// Neighbors will return an error if the entity id is not present in the Links object.
+func (l *Links) Neighbors(id int) ([]Partner, error) {
+
+	if _, ok := l.idKeyRev[id]; !ok {
+		return nil, fmt.Errorf("sequtil/links: cannot find the neighbors of integer id not known to Links object, %d", id)
+	}
+
+	partners := []Partner{}
+	for id1, sub := range l.data {
+		if id1 == id {
+			for id2, v := range sub {
+				if _, ok := l.idKeyRev[id2]; ok && id2 != id {
+					partners = append(partners, Partner{id2, v})
+				}
+			}
+		} else if v, ok := sub[id]; ok {
+			if _, ok := l.idKeyRev[id1]; ok {
+				partners = append(partners, Partner{id1, v})
+			}
+		}
+	}
+	sort.Slice(partners, func(i, j int) bool { return partners[i].ID < partners[j].ID })
+
+	return partners, nil
+
+}
+
+// RowSum returns the weighted degree of an entity, the sum of the values of its links
+// to other entities, see Neighbors, its link to itself being given by Get.
+func (l *Links) RowSum(id int) (float64, error) {
+
+	partners, err := l.Neighbors(id)
+	if err != nil {
+		return 0, err
+	}
+	return partnerSum(partners), nil
+
+}
+
+// partnerSum returns the sum of the values of the links to a set of partners.
+func partnerSum(partners []Partner) float64 {
+	sum := 0.0
+	for _, p := range partners {
+		sum += p.Value
+	}
+	return sum
+}
+
+// TopPartners returns the k entities with the largest links to an entity, other than
+// itself, in descending order of value and, for equal values, ascending order of id, or
+// all of its partners in that order if k is not positive.
+func (l *Links) TopPartners(id, k int) ([]Partner, error) {
+
+	partners, err := l.Neighbors(id)
+	if err != nil {
+		return nil, err
+	}
+	return topPartners(partners, k), nil
+
+}
+
+// topPartners returns the k partners with the largest values, see TopPartners, from a
+// set of partners in the order of their ids, which is unchanged.
+func topPartners(partners []Partner, k int) []Partner {
+	top := append([]Partner{}, partners...)
+	sort.SliceStable(top, func(i, j int) bool { return top[i].Value > top[j].Value })
+	if k > 0 && k < len(top) {
+		top = top[:k]
+	}
+	return top
+}
+
+// CisTrans returns the totals of the values of cis links, between entities whose names
+// share a prefix, and trans links, between entities of different prefixes, each prefix
+// being the part of a name preceding the first occurrence of a separator, see Split.
+// With an empty separator the prefix of each entity is its whole name, such that cis
+// links are those of entities with themselves, e.g. within contigs.
+func (l *Links) CisTrans(sep string) (float64, float64) {
+
+	cis, trans := 0.0, 0.0
+	l.Each(func(id1, id2 int, val float64) {
+		if namePrefix(l.idKeyRev[id1], sep) == namePrefix(l.idKeyRev[id2], sep) {
+			cis += val
+		} else {
+			trans += val
+		}
+	})
+
+	return cis, trans
+
+}
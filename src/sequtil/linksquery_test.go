@@ -0,0 +1,55 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLinksQuery(t *testing.T) {
+
+	l := NewLinks()
+	l.Set(l.ID("chr1_b"), l.ID("chr1_a"), 5)
+	l.Set(l.ID("chr1_a"), l.ID("chr2_a"), 2)
+	l.Set(l.ID("chr1_a"), l.ID("chr2_b"), 5)
+	l.Set(l.ID("chr1_a"), l.ID("chr1_a"), 9)
+	l.Set(l.ID("chr2_a"), l.ID("chr2_b"), 1)
+	l.ID("chr3_a")
+	a := l.ID("chr1_a")
+
+	// Links are visited by the smaller id of each pair and then the larger.
+	visited := [][2]int{}
+	l.Each(func(id1, id2 int, val float64) {
+		visited = append(visited, [2]int{id1, id2})
+	})
+	if expected := [][2]int{{0, 1}, {1, 1}, {1, 2}, {1, 3}, {2, 3}}; !reflect.DeepEqual(visited, expected) {
+		t.Errorf("Each visited %v, expected %v", visited, expected)
+	}
+
+	neighbors, err := l.Neighbors(a)
+	if expected := []Partner{{0, 5}, {2, 2}, {3, 5}}; err != nil || !reflect.DeepEqual(neighbors, expected) {
+		t.Errorf("Neighbors yielded %v (%v), expected %v", neighbors, err, expected)
+	}
+	if sum, err := l.RowSum(a); err != nil || sum != 12 {
+		t.Errorf("RowSum yielded %f (%v), expected 12", sum, err)
+	}
+	if top, err := l.TopPartners(a, 2); err != nil || !reflect.DeepEqual(top, []Partner{{0, 5}, {3, 5}}) {
+		t.Errorf("TopPartners yielded %v (%v)", top, err)
+	}
+	if top, _ := l.TopPartners(a, 0); len(top) != 3 || top[2].ID != 2 {
+		t.Errorf("TopPartners with no limit yielded %v", top)
+	}
+	if neighbors, err := l.Neighbors(l.ID("chr3_a")); err != nil || len(neighbors) != 0 {
+		t.Errorf("Neighbors yielded %v (%v) for an unlinked entity", neighbors, err)
+	}
+	if _, err := l.Neighbors(99); err == nil {
+		t.Errorf("Expected an error for an unknown id")
+	}
+
+	if cis, trans := l.CisTrans("_"); cis != 15 || trans != 7 {
+		t.Errorf("CisTrans yielded %f and %f, expected 15 and 7", cis, trans)
+	}
+	if cis, trans := l.CisTrans(""); cis != 9 || trans != 13 {
+		t.Errorf("CisTrans with no separator yielded %f and %f, expected 9 and 13", cis, trans)
+	}
+
+}
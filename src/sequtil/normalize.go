@@ -0,0 +1,173 @@
+package util
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// The methods by which a Links object may be normalized, see Normalize, in addition to
+// the balancing methods, see Balance.
+const (
+	// Each value is divided by the geometric mean of the lengths of its two contigs
+	NormalizeLength = "length"
+
+	// Each value is divided by the product of the lengths of its two contigs, the
+	// number of pairs of positions between which its reads could have been ligated
+	NormalizeLengthProduct = "lengthProduct"
+
+	// Each value is divided by the product of the numbers of restriction fragments of
+	// its two contigs, one more than the number of restriction sites of each
+	NormalizeSites = "sites"
+)
+
+// NormalizeMethods are the methods accepted by Normalize.
+var NormalizeMethods = append([]string{NormalizeLength, NormalizeLengthProduct, NormalizeSites}, BalanceMethods...)
+
+// NormalizeOptions configures the normalization of links, see Normalize.
+type NormalizeOptions struct {
+
+	// The normalization method, one of NormalizeMethods
+	Method string
+
+	// The path to the reference FASTA or its index (.fai), or any input accepted by
+	// LoadRefDict, giving the lengths of the contigs in place of those recorded in the
+	// links, see Links.Reference. The sites method requires a FASTA file.
+	Ref string
+
+	// The restriction enzyme whose sites are counted by the sites method
+	Enzyme Enzyme
+
+	// The options with which links are balanced by the balancing methods, whose Method
+	// is that above
+	Balance BalanceOptions
+}
+
+// Normalize normalizes the association values of a set of links between contigs, such
+// as Hi-C contact counts, for the biases of each contig, returning the normalized links
+// with the same entities and ids. Links may be balanced, see Balance, or divided by a
+// factor given by the length or the number of restriction sites of each contig, which
+// unlike balancing doesn't require the contact matrix to have total support.
+//
+// Values divided by such a factor are scaled such that their total is unchanged, so
+// that normalized values are on the scale of raw ones. Every entity must be a contig of
+// the reference.
+func (l *Links) Normalize(opts NormalizeOptions) (Links, error) {
+
+	if err := opts.Check(); err != nil {
+		return Links{}, err
+	}
+	method, _ := opts.method()
+
+	switch method {
+	case BalanceICE, BalanceKR:
+		opts.Balance.Method = method
+		balanced, _, err := l.Balance(opts.Balance)
+		return balanced, err
+
+	case NormalizeLength, NormalizeLengthProduct:
+		refs := l.refs
+		if len(opts.Ref) > 0 {
+			var err error
+			if refs, err = LoadRefDict(opts.Ref); err != nil {
+				return Links{}, err
+			}
+		}
+		if refs == nil {
+			return Links{}, fmt.Errorf("Couldn't normalize links by contig length, which requires a reference whose lengths are either recorded in the links or given")
+		}
+		sizes := map[string]float64{}
+		for _, name := range refs.Names() {
+			length, _ := refs.Length(name)
+			sizes[name] = float64(length)
+		}
+		if method == NormalizeLength {
+			for name, size := range sizes {
+				sizes[name] = math.Sqrt(size)
+			}
+		}
+		return l.divideBy(sizes, "length")
+
+	case NormalizeSites:
+		digest, err := DigestFasta(opts.Ref, opts.Enzyme)
+		if err != nil {
+			return Links{}, err
+		}
+		sizes := map[string]float64{}
+		for name := range digest.cuts {
+			sizes[name] = float64(digest.NumFragments(name))
+		}
+		return l.divideBy(sizes, "restriction fragment count")
+	}
+
+	return Links{}, fmt.Errorf("Unknown normalization method %s, expected one of %s", opts.Method, strings.Join(NormalizeMethods, ", "))
+
+}
+
+// method returns the normalization method of the options, matched to one of
+// NormalizeMethods regardless of case.
+func (opts NormalizeOptions) method() (string, error) {
+	for _, m := range NormalizeMethods {
+		if strings.ToLower(m) == strings.ToLower(opts.Method) {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("Unknown normalization method %s, expected one of %s", opts.Method, strings.Join(NormalizeMethods, ", "))
+}
+
+// Check returns an error if the normalization method is unknown or lacks the options it
+// requires, such that they can be checked before links are built or loaded. Methods
+// requiring contig lengths may still fail if the links record no reference and none is
+// given, see Normalize.
+func (opts NormalizeOptions) Check() error {
+	method, err := opts.method()
+	if err != nil {
+		return err
+	}
+	if method == NormalizeSites && (len(opts.Ref) == 0 || len(opts.Enzyme.Sites) == 0) {
+		return fmt.Errorf("Couldn't normalize links by restriction sites, which requires a reference FASTA and an enzyme")
+	}
+	return nil
+}
+
+// divideBy divides the value of each pair of entities by the product of their sizes,
+// which must be positive, scaling the quotients such that their total is unchanged. The
+// description of the sizes is used in errors.
+func (l *Links) divideBy(sizes map[string]float64, description string) (Links, error) {
+
+	for _, id := range l.sortedIDs() {
+		name := l.idKeyRev[id]
+		if size, ok := sizes[name]; !ok {
+			return Links{}, fmt.Errorf("Couldn't normalize links by %s, which isn't known for %s", description, name)
+		} else if size <= 0 {
+			return Links{}, fmt.Errorf("Couldn't normalize links by %s, which is zero for %s", description, name)
+		}
+	}
+
+	normalized := l.copyEntities()
+	total, quotients := 0.0, 0.0
+	for id1, sub := range l.data {
+		for id2, v := range sub {
+			name1, ok1 := l.idKeyRev[id1]
+			name2, ok2 := l.idKeyRev[id2]
+			if !ok1 || !ok2 {
+				continue
+			}
+			q := v / (sizes[name1] * sizes[name2])
+			normalized.Set(id1, id2, q)
+			total, quotients = total+v, quotients+q
+		}
+	}
+
+	if quotients != 0 {
+		scale := total / quotients
+		for _, sub := range normalized.data {
+			for id2 := range sub {
+				sub[id2] *= scale
+			}
+		}
+	}
+
+	return normalized, nil
+
+}
@@ -0,0 +1,88 @@
+package util
+
+import (
+	"math"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+
+	dir := "/tmp/lxy/test/normalize/"
+	os.RemoveAll(dir)
+
+	// Contigs of 100, 400 and 25 bp, with 1, 3 and 0 DpnII sites.
+	writeTestFile(t, dir+"ref.fa", ">a\n"+strings.Repeat("ACGT", 20)+"GATC"+strings.Repeat("ACGT", 4)+"\n>b\n"+
+		strings.Repeat("GATCACGT", 3)+strings.Repeat("ACGT", 94)+"\n>c\n"+strings.Repeat("A", 25)+"\n")
+	writeTestFile(t, dir+"ref.fa.fai", "a\t100\t3\t100\t101\nb\t400\t107\t400\t401\nc\t25\t511\t25\t26\n")
+	links := NewLinks()
+	links.Set(links.ID("a"), links.ID("b"), 40)
+	links.Set(links.ID("b"), links.ID("c"), 10)
+	links.Set(links.ID("a"), links.ID("a"), 50)
+	total := 100.0
+
+	dpnII, _ := ParseEnzyme("DpnII")
+	tests := []struct {
+		method  string
+		factors map[string]float64
+	}{
+		{NormalizeLength, map[string]float64{"a": 10, "b": 20, "c": 5}},
+		{NormalizeLengthProduct, map[string]float64{"a": 100, "b": 400, "c": 25}},
+		{NormalizeSites, map[string]float64{"a": 2, "b": 4, "c": 1}},
+	}
+	for _, test := range tests {
+		normalized, err := links.Normalize(NormalizeOptions{Method: test.method, Ref: dir + "ref.fa", Enzyme: dpnII})
+		if err != nil {
+			t.Errorf("Normalize(%s): %s", test.method, err)
+			continue
+		}
+
+		// The values are divided by the factors of their contigs, keeping their total.
+		f := test.factors
+		expected := map[[2]string]float64{
+			{"a", "b"}: 40 / (f["a"] * f["b"]),
+			{"b", "c"}: 10 / (f["b"] * f["c"]),
+			{"a", "a"}: 50 / (f["a"] * f["a"]),
+		}
+		quotients := 0.0
+		for _, q := range expected {
+			quotients += q
+		}
+		for pair, q := range expected {
+			v, _ := normalized.Get(normalized.ID(pair[0]), normalized.ID(pair[1]))
+			if q *= total / quotients; math.Abs(v-q) > 1e-9 {
+				t.Errorf("Normalize(%s) yielded %f for %s %s, expected %f", test.method, v, pair[0], pair[1], q)
+			}
+		}
+	}
+
+	// Lengths are otherwise taken from the reference recorded in the links.
+	if _, err := links.Normalize(NormalizeOptions{Method: NormalizeLength}); err == nil {
+		t.Errorf("Expected an error normalizing by length with no reference")
+	}
+	refs, _ := RefDictFromFai(dir + "ref.fa.fai")
+	links.SetReference(refs)
+	if normalized, err := links.Normalize(NormalizeOptions{Method: NormalizeLengthProduct}); err != nil {
+		t.Error(err)
+	} else if v, _ := normalized.Get(links.ID("a"), links.ID("a")); math.Abs(v-total*0.005/0.007) > 1e-9 {
+		t.Errorf("Normalize(%s) yielded %f for a a with the recorded reference", NormalizeLengthProduct, v)
+	}
+
+	links.ID("d")
+	if _, err := links.Normalize(NormalizeOptions{Method: NormalizeLength}); err == nil {
+		t.Errorf("Expected an error normalizing a contig of unknown length")
+	}
+	if _, err := links.Normalize(NormalizeOptions{Method: "coverage"}); err == nil {
+		t.Errorf("Expected an error for an unknown normalization method")
+	}
+
+	// Options are checked without links, before any are built.
+	if err := (NormalizeOptions{Method: "Sites", Ref: dir + "ref.fa"}).Check(); err == nil {
+		t.Errorf("Expected an error checking normalization by sites without an enzyme")
+	}
+	if err := (NormalizeOptions{Method: "LENGTH"}).Check(); err != nil {
+		t.Errorf("Check() of normalization by length yielded %s", err)
+	}
+
+}
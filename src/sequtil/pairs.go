@@ -0,0 +1,497 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// pairsMagic begins the first header line of every 4DN .pairs file.
+const pairsMagic = "## pairs format"
+
+// pairsColumns are the mandatory leading columns of a .pairs file.
+var pairsColumns = []string{"readID", "chr1", "pos1", "chr2", "pos2", "strand1", "strand2"}
+
+// PairsHeader holds the header fields of a 4DN .pairs file.
+type PairsHeader struct {
+
+	// The sort order and shape of the file, e.g. "chr1-chr2-pos1-pos2" and
+	// "upper triangle", empty if not given
+	Sorted string
+	Shape  string
+
+	// The name of the assembly to which the reads were aligned, empty if not given
+	GenomeAssembly string
+
+	// The names of the chromosomes in the order of their #chromsize lines, and their
+	// lengths
+	Chroms     []string
+	ChromSizes map[string]int
+
+	// The names of all columns, the first seven of which are always those of
+	// pairsColumns
+	Columns []string
+
+	// Any other header lines, e.g. #samheader: lines, stored verbatim
+	Other []string
+}
+
+// NewPairsHeader returns a PairsHeader with the mandatory columns followed by the
+// specified optional columns, e.g. "mapq1" and "mapq2".
+func NewPairsHeader(extraColumns ...string) PairsHeader {
+	return PairsHeader{ChromSizes: map[string]int{}, Columns: append(append([]string{}, pairsColumns...), extraColumns...)}
+}
+
+// AddChrom adds a #chromsize line to the header.
+func (h *PairsHeader) AddChrom(name string, length int) {
+	if _, ok := h.ChromSizes[name]; !ok {
+		h.Chroms = append(h.Chroms, name)
+	}
+	h.ChromSizes[name] = length
+}
+
+// column returns the index among the optional values of a Pair of the column with the
+// specified name, or -1 if the file has no such column.
+func (h PairsHeader) column(name string) int {
+	for i, c := range h.Columns[len(pairsColumns):] {
+		if c == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// String renders the header in the form in which it begins a .pairs file, with the
+// #columns line last as the specification requires.
+func (h PairsHeader) String() string {
+	lines := []string{pairsMagic + " v1.0"}
+	if len(h.Sorted) > 0 {
+		lines = append(lines, "#sorted: "+h.Sorted)
+	}
+	if len(h.Shape) > 0 {
+		lines = append(lines, "#shape: "+h.Shape)
+	}
+	if len(h.GenomeAssembly) > 0 {
+		lines = append(lines, "#genome_assembly: "+h.GenomeAssembly)
+	}
+	for _, chrom := range h.Chroms {
+		lines = append(lines, fmt.Sprintf("#chromsize: %s %d", chrom, h.ChromSizes[chrom]))
+	}
+	lines = append(lines, h.Other...)
+	lines = append(lines, "#columns: "+strings.Join(h.Columns, " "))
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// parseLine parses a single line of a .pairs header into the header.
+func (h *PairsHeader) parseLine(line string) error {
+
+	field := func(prefix string) (string, bool) {
+		if strings.HasPrefix(line, prefix) {
+			return strings.TrimSpace(line[len(prefix):]), true
+		}
+		return "", false
+	}
+
+	if strings.HasPrefix(line, pairsMagic) {
+		return nil
+	} else if v, ok := field("#sorted:"); ok {
+		h.Sorted = v
+	} else if v, ok := field("#shape:"); ok {
+		h.Shape = v
+	} else if v, ok := field("#genome_assembly:"); ok {
+		h.GenomeAssembly = v
+	} else if v, ok := field("#chromsize:"); ok {
+		arr := strings.Fields(v)
+		if len(arr) != 2 {
+			return fmt.Errorf("Malformed chromsize line in pairs header: %s", line)
+		}
+		length, err := strconv.Atoi(arr[1])
+		if err != nil {
+			return fmt.Errorf("Malformed chromsize line in pairs header: %s", line)
+		}
+		h.AddChrom(arr[0], length)
+	} else if v, ok := field("#columns:"); ok {
+		h.Columns = strings.Fields(v)
+		if len(h.Columns) < len(pairsColumns) {
+			return fmt.Errorf("Pairs header gives fewer than the %d mandatory columns: %s", len(pairsColumns), line)
+		}
+	} else {
+		h.Other = append(h.Other, line)
+	}
+
+	return nil
+
+}
+
+// Pair is a single contact, i.e. read pair, of a .pairs file. Positions are 1-based and
+// strands are '+' or '-'. Unmapped ends have the chromosome "!" and position 0.
+type Pair struct {
+	ReadID  string
+	Chrom1  string
+	Pos1    int
+	Chrom2  string
+	Pos2    int
+	Strand1 byte
+	Strand2 byte
+
+	// The values of the optional columns, in the order given by the header
+	Extra []string
+}
+
+// String renders a Pair as a tab-delimited line of a .pairs file.
+func (p Pair) String() string {
+	arr := []string{p.ReadID, p.Chrom1, strconv.Itoa(p.Pos1), p.Chrom2, strconv.Itoa(p.Pos2), string(p.Strand1), string(p.Strand2)}
+	return strings.Join(append(arr, p.Extra...), "\t")
+}
+
+// parsePairsLine parses a tab-delimited line of a .pairs file with the specified number
+// of columns.
+func parsePairsLine(line string, columns int) (Pair, error) {
+
+	arr := strings.Split(line, "\t")
+	if len(arr) != columns {
+		return Pair{}, fmt.Errorf("Pairs line has %d columns where the header gives %d: %s", len(arr), columns, line)
+	}
+
+	pos1, e1 := strconv.Atoi(arr[2])
+	pos2, e2 := strconv.Atoi(arr[4])
+	if e1 != nil || e2 != nil || len(arr[5]) != 1 || len(arr[6]) != 1 {
+		return Pair{}, fmt.Errorf("Malformed pairs line: %s", line)
+	}
+
+	return Pair{arr[0], arr[1], pos1, arr[3], pos2, arr[5][0], arr[6][0], arr[7:]}, nil
+
+}
+
+// PairsReader reads Pairs from a (possibly compressed) .pairs file in the manner of a
+// bufio.Scanner.
+type PairsReader struct {
+	in      io.Closer
+	s       *bufio.Scanner
+	header  PairsHeader
+	line    string
+	pending bool
+}
+
+// OpenPairs opens a .pairs file for reading, parsing its header.
+func OpenPairs(path string) (*PairsReader, error) {
+
+	in, err := openInput(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pr := &PairsReader{in: in, s: bufio.NewScanner(in), header: NewPairsHeader()}
+	pr.s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	first := true
+	for pr.s.Scan() {
+		line := pr.s.Text()
+		if first && !strings.HasPrefix(line, pairsMagic) {
+			in.Close()
+			return nil, fmt.Errorf("Input file (%s) is not in .pairs format", path)
+		}
+		first = false
+		if len(line) > 0 && line[0] != '#' {
+			pr.line = line
+			pr.pending = true
+			break
+		}
+		if err := pr.header.parseLine(line); err != nil {
+			in.Close()
+			return nil, err
+		}
+	}
+
+	return pr, nil
+
+}
+
+// Scan advances to the next pair.
+func (pr *PairsReader) Scan() bool {
+	if pr.pending {
+		pr.pending = false
+		return true
+	}
+	for pr.s.Scan() {
+		pr.line = pr.s.Text()
+		if len(pr.line) > 0 && pr.line[0] != '#' {
+			return true
+		}
+	}
+	return false
+}
+
+// Pair parses the current line of the file.
+func (pr *PairsReader) Pair() (Pair, error) {
+	return parsePairsLine(pr.line, len(pr.header.Columns))
+}
+
+// Header returns the header of the file.
+func (pr *PairsReader) Header() PairsHeader {
+	return pr.header
+}
+
+// Err returns the first read error encountered by Scan.
+func (pr *PairsReader) Err() error {
+	return pr.s.Err()
+}
+
+// Close closes the underlying file.
+func (pr *PairsReader) Close() error {
+	return pr.in.Close()
+}
+
+// PairsWriter writes Pairs to a .pairs file.
+type PairsWriter struct {
+	out     io.WriteCloser
+	w       *bufio.Writer
+	columns int
+}
+
+// CreatePairs creates a .pairs file with the provided header, BGZF compressed if the path
+// ends in ".gz" as is conventional for the format.
+func CreatePairs(path string, h PairsHeader) (*PairsWriter, error) {
+
+	out, err := CreateOutput(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pw := &PairsWriter{out, bufio.NewWriter(out), len(h.Columns)}
+	if _, err := pw.w.WriteString(h.String()); err != nil {
+		out.Close()
+		return nil, err
+	}
+
+	return pw, nil
+
+}
+
+// Write writes a single pair, which must have a value for each optional column.
+func (pw *PairsWriter) Write(p Pair) error {
+	if len(pairsColumns)+len(p.Extra) != pw.columns {
+		return fmt.Errorf("Pair %s has %d optional values where the header gives %d", p.ReadID, len(p.Extra), pw.columns-len(pairsColumns))
+	}
+	_, err := pw.w.WriteString(p.String() + "\n")
+	return err
+}
+
+// Close flushes buffered pairs and closes the file.
+func (pw *PairsWriter) Close() error {
+	if err := pw.w.Flush(); err != nil {
+		pw.out.Close()
+		return err
+	}
+	return pw.out.Close()
+}
+
+// IsPairsFile determines whether the file at a path is a (possibly compressed) .pairs
+// file from the first line of its content.
+func IsPairsFile(path string) bool {
+	in, err := openInput(path)
+	if err != nil {
+		return false
+	}
+	defer in.Close()
+	magic, _ := in.Peek(len(pairsMagic))
+	return string(magic) == pairsMagic
+}
+
+// pairEndAlignment constructs an Alignment for one end of a pair. The CIGAR and sequence
+// are taken from the optional columns, e.g. cigar1 and seq1, if present, and otherwise
+// the end is represented by a single aligned base at its 5' position.
+func pairEndAlignment(h PairsHeader, p Pair, qname, chrom string, pos int, strand byte, end string) Alignment {
+
+	value := func(name string) (string, bool) {
+		if i := h.column(name + end); i >= 0 {
+			return p.Extra[i], true
+		}
+		return "", false
+	}
+
+	flag := 0x1
+	if strand == '-' {
+		flag |= 0x10
+	}
+	if end == "1" {
+		flag |= 0x40
+	} else {
+		flag |= 0x80
+	}
+
+	mapq := 255
+	if v, ok := value("mapq"); ok {
+		mapq, _ = strconv.Atoi(v)
+	}
+
+	cigar := CIGAR{CIGARCode{1, "M"}}
+	if v, ok := value("cigar"); ok {
+		if c, err := parseCIGAR(v); err == nil && len(c) > 0 {
+			cigar = c
+		}
+	}
+
+	seq := "*"
+	if v, ok := value("seq"); ok {
+		seq = v
+	}
+
+	a := Alignment{qname, newSAMFlag(flag), chrom, pos, mapq, cigar, "*", 0, 0, seq, "*", nil}
+	if chrom == "!" {
+		a.rname = "*"
+		a.flag = newSAMFlag(flag | 0x4)
+	} else if strand == '-' {
+		// The position of a .pairs end is that of its 5' end
+		a.pos = pos - cigar.refLength() + 1
+	}
+
+	return a
+
+}
+
+// Alignments returns the two ends of a pair as Alignments, such that pairs can be passed
+// through PairFilters and the link builders.
+func (p Pair) Alignments(h PairsHeader) (Alignment, Alignment) {
+	return pairEndAlignment(h, p, p.ReadID, p.Chrom1, p.Pos1, p.Strand1, "1"),
+		pairEndAlignment(h, p, p.ReadID, p.Chrom2, p.Pos2, p.Strand2, "2")
+}
+
+// pairsAlignmentReader is an AlignmentReader yielding the two ends of each pair of a
+// .pairs file as consecutive alignment records, such that the link builders can read
+// .pairs files in place of SAM or BAM.
+type pairsAlignmentReader struct {
+	pr     *PairsReader
+	ends   [2]Alignment
+	err    error
+	count  int
+	second bool
+}
+
+// Scan advances to the next end of a pair.
+func (r *pairsAlignmentReader) Scan() bool {
+	if !r.second && r.count > 0 {
+		r.second = true
+		return true
+	}
+	if !r.pr.Scan() {
+		return false
+	}
+	r.count += 1
+	r.second = false
+	p, err := r.pr.Pair()
+	r.err = err
+	if err == nil {
+		// Pairs without a read ID must still be told apart from their neighbours.
+		if p.ReadID == "." {
+			p.ReadID = "pair" + strconv.Itoa(r.count)
+		}
+		r.ends[0], r.ends[1] = p.Alignments(r.pr.header)
+	}
+	return true
+}
+
+// Alignment returns the current end of the current pair.
+func (r *pairsAlignmentReader) Alignment() (Alignment, error) {
+	if r.err != nil {
+		return Alignment{}, r.err
+	}
+	a := r.ends[0]
+	if r.second {
+		a = r.ends[1]
+	}
+	if a.rname == "*" {
+		return a, ErrUnaligned
+	}
+	return a, nil
+}
+
+// Header returns a SAM header with an @SQ line for each #chromsize line of the file.
+func (r *pairsAlignmentReader) Header() string {
+	header := ""
+	for _, chrom := range r.pr.header.Chroms {
+		header += fmt.Sprintf("@SQ\tSN:%s\tLN:%d\n", chrom, r.pr.header.ChromSizes[chrom])
+	}
+	return header
+}
+
+// Err returns the first read error encountered by Scan.
+func (r *pairsAlignmentReader) Err() error {
+	return r.pr.Err()
+}
+
+// Close closes the underlying .pairs file.
+func (r *pairsAlignmentReader) Close() error {
+	return r.pr.Close()
+}
+
+// SamToPairs converts the read pairs of an alignments file into a .pairs file, writing
+// the 5' position and strand of each end along with its mapping quality, and its CIGAR
+// and sequence if withSeq is true. Chimeric reads are resolved to the segment containing
+// their 5' end, see pairHits, and only pairs passing the filter, which may be nil, are
+// written, ordered so that the first end precedes the
+// second in the order of the reference sequences of the SAM header.
+func SamToPairs(samPath, pairsPath string, filter *PairFilterChain, withSeq bool) error {
+
+	in, err := openGroupedAlignments(samPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	columns := []string{"mapq1", "mapq2"}
+	if withSeq {
+		columns = append(columns, "cigar1", "cigar2", "seq1", "seq2")
+	}
+	h := NewPairsHeader(columns...)
+	h.Shape = "upper triangle"
+	refs, lengths := bamRefs(in.Header())
+	order := map[string]int{}
+	for i, ref := range refs {
+		h.AddChrom(ref, lengths[i])
+		order[ref] = i
+	}
+
+	out, err := CreatePairs(pairsPath, h)
+	if err != nil {
+		return err
+	}
+
+	strand := func(a Alignment) byte {
+		if a.flag.reversecomp {
+			return '-'
+		}
+		return '+'
+	}
+
+	pairs := newPairScanner(in)
+	for pairs.Scan() {
+		a1, a2 := pairs.Pair()
+		if !filter.Accept(a1, a2) {
+			continue
+		}
+		p1, p2 := fivePrime(a1), fivePrime(a2)
+		if order[a2.rname] < order[a1.rname] || (a1.rname == a2.rname && p2 < p1) {
+			a1, a2 = a2, a1
+			p1, p2 = p2, p1
+		}
+		extra := []string{strconv.Itoa(a1.mapq), strconv.Itoa(a2.mapq)}
+		if withSeq {
+			extra = append(extra, a1.cigar.String(), a2.cigar.String(), a1.seq, a2.seq)
+		}
+		if err := out.Write(Pair{a1.qname, a1.rname, p1, a2.rname, p2, strand(a1), strand(a2), extra}); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err := in.Err(); err != nil {
+		out.Close()
+		return err
+	}
+	fmt.Print(pairs.Summary())
+
+	return out.Close()
+
+}
@@ -0,0 +1,194 @@
+package util
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPairsIO(t *testing.T) {
+
+	path := "/tmp/lxy/test/testpairs.pairs.gz"
+	MkdirForFile(path)
+
+	h := NewPairsHeader("mapq1", "mapq2")
+	h.Shape = "upper triangle"
+	h.GenomeAssembly = "toy"
+	h.AddChrom("chr1", 1000)
+	h.AddChrom("chr2", 500)
+	h.Other = []string{"#samheader: @SQ\tSN:chr1\tLN:1000"}
+	pairs := []Pair{
+		Pair{"read1", "chr1", 10, "chr1", 200, '+', '-', []string{"60", "60"}},
+		Pair{"read2", "chr1", 300, "chr2", 20, '-', '+', []string{"60", "3"}},
+		Pair{".", "chr2", 1, "!", 0, '+', '-', []string{"60", "0"}},
+	}
+
+	out, err := CreatePairs(path, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range pairs {
+		if err := out.Write(p); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := out.Write(Pair{"read3", "chr1", 1, "chr1", 2, '+', '+', nil}); err == nil {
+		t.Errorf("PairsWriter.Write did not return an error for a pair missing optional columns")
+	}
+	out.Close()
+
+	if !IsPairsFile(path) {
+		t.Errorf("IsPairsFile(%s) did not detect a compressed .pairs file", path)
+	}
+
+	in, err := OpenPairs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	if !reflect.DeepEqual(in.Header(), h) {
+		t.Errorf("OpenPairs(%s) read header %v, expected %v", path, in.Header(), h)
+	}
+	read := []Pair{}
+	for in.Scan() {
+		p, err := in.Pair()
+		if err != nil {
+			t.Error(err)
+		}
+		read = append(read, p)
+	}
+	if !reflect.DeepEqual(read, pairs) {
+		t.Errorf("pairs read back from %s do not match those written: %v", path, read)
+	}
+
+	os.Remove(path)
+
+}
+
+func TestPairAlignments(t *testing.T) {
+
+	h := NewPairsHeader("mapq1", "mapq2", "cigar1", "cigar2", "seq1", "seq2")
+	p := Pair{"read1", "chr1", 10, "chr1", 205, '+', '-', []string{"60", "20", "3M", "2S4M", "ATG", "GGACGT"}}
+	a1, a2 := p.Alignments(h)
+
+	key1 := Alignment{"read1", newSAMFlag(65), "chr1", 10, 60, CIGAR{CIGARCode{3, "M"}}, "*", 0, 0, "ATG", "*", nil}
+	key2 := Alignment{"read1", newSAMFlag(145), "chr1", 202, 20, CIGAR{CIGARCode{2, "S"}, CIGARCode{4, "M"}}, "*", 0, 0, "GGACGT", "*", nil}
+	if !reflect.DeepEqual(a1, key1) || !reflect.DeepEqual(a2, key2) {
+		t.Errorf("Pair.Alignments() yielded\n%s\n%s\nexpected\n%s\n%s", a1, a2, key1, key2)
+	}
+	if fivePrime(a2) != 205 {
+		t.Errorf("Pair.Alignments() yielded a reverse strand alignment with 5' end %d, expected 205", fivePrime(a2))
+	}
+
+}
+
+func TestCheckPairsSequences(t *testing.T) {
+
+	// Variants are linked only from .pairs files giving the sequence of each end.
+	dir := "/tmp/lxy/test/pairsseq/"
+	MkdirForFile(dir)
+	tests := []struct {
+		columns []string
+		extra   []string
+		withSeq bool
+	}{
+		{[]string{"mapq1", "mapq2"}, []string{"60", "60"}, false},
+		{[]string{"cigar1", "seq1"}, []string{"3M", "ATG"}, false},
+		{[]string{"cigar1", "cigar2", "seq1", "seq2"}, []string{"3M", "3M", "ATG", "GGA"}, true},
+	}
+	for _, test := range tests {
+		path := dir + "test.pairs"
+		out, err := CreatePairs(path, NewPairsHeader(test.columns...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		out.Write(Pair{"read1", "chr1", 10, "chr1", 200, '+', '-', test.extra})
+		if err := out.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := checkPairsSequences(path); (err == nil) != test.withSeq {
+			t.Errorf("checkPairsSequences() of .pairs with columns %v yielded %v", test.columns, err)
+		}
+		if !test.withSeq && BlockLinksFromSam(path, dir+"test.links", NewVariants(), nil, 1) == nil {
+			t.Errorf("BlockLinksFromSam() of .pairs with columns %v didn't return an error", test.columns)
+		}
+	}
+	os.RemoveAll(dir)
+
+}
+
+func TestSamToPairs(t *testing.T) {
+
+	samPath := "/tmp/lxy/test/testsam2pairs.sam"
+	pairsPath := "/tmp/lxy/test/testsam2pairs.pairs"
+	linksPath := "/tmp/lxy/test/testsam2pairs.links"
+	MkdirForFile(samPath)
+
+	sam := []string{
+		"@SQ\tSN:chr1\tLN:1000",
+		"@SQ\tSN:chr2\tLN:500",
+		"read-1\t81\tchr2\t20\t60\t3M\tchr1\t10\t0\tATG\tIII",
+		"read-1\t161\tchr1\t10\t30\t3M\tchr2\t20\t0\tGCG\tIII",
+		"read-2\t65\tchr1\t100\t60\t3M\t=\t400\t0\tATG\tIII",
+		"read-2\t145\tchr1\t400\t60\t3M\t=\t100\t0\tGCG\tIII",
+		"read-3\t65\tchr1\t100\t60\t3M\t=\t400\t0\tATG\tIII",
+		"read-3\t145\tchr1\t400\t60\t3M\t=\t100\t0\tGCG\tIII",
+		"read-4\t73\tchr1\t100\t60\t3M\t=\t100\t0\tATG\tIII",
+		"read-4\t133\t*\t0\t0\t*\tchr1\t100\t0\tGCG\tIII",
+	}
+	f, err := os.Create(samPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(strings.Join(sam, "\n") + "\n")
+	f.Close()
+
+	if err := SamToPairs(samPath, pairsPath, nil, false); err != nil {
+		t.Fatal(err)
+	}
+
+	in, err := OpenPairs(pairsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := []string{}
+	for in.Scan() {
+		p, _ := in.Pair()
+		read = append(read, p.String())
+	}
+	in.Close()
+
+	// Ends are ordered by reference and the position of reverse strand ends is their 5' end.
+	pairsKey := []string{
+		"read-1\tchr1\t10\tchr2\t22\t+\t-\t30\t60",
+		"read-2\tchr1\t100\tchr1\t402\t+\t-\t60\t60",
+		"read-3\tchr1\t100\tchr1\t402\t+\t-\t60\t60",
+	}
+	if !reflect.DeepEqual(read, pairsKey) {
+		t.Errorf("SamToPairs(%s, %s) wrote pairs:\n%s\nexpected:\n%s", samPath, pairsPath, strings.Join(read, "\n"), strings.Join(pairsKey, "\n"))
+	}
+	if in.Header().ChromSizes["chr2"] != 500 {
+		t.Errorf("SamToPairs(%s, %s) did not write chromsizes from the SAM header", samPath, pairsPath)
+	}
+
+	// Links built from .pairs input match those built from the SAM file, with the
+	// duplicate of read-2 removed in both cases.
+	for _, input := range []string{samPath, pairsPath} {
+		if err := ScaffoldLinksFromSam(input, linksPath, nil, NewPairFilterChain(NewDeduplicator(0)), 1); err != nil {
+			t.Fatal(err)
+		}
+		links, _ := LoadLinks(linksPath)
+		v11, _ := links.Get(links.ID("chr1"), links.ID("chr1"))
+		v12, _ := links.Get(links.ID("chr1"), links.ID("chr2"))
+		if v11 != 1 || v12 != 1 {
+			t.Errorf("ScaffoldLinksFromSam(%s) yielded chr1-chr1 %f and chr1-chr2 %f links, expected 1 and 1", input, v11, v12)
+		}
+	}
+
+	os.Remove(samPath)
+	os.Remove(pairsPath)
+	os.Remove(linksPath)
+
+}
@@ -0,0 +1,244 @@
+package util
+
+import (
+	"bufio"
+	"container/list"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultMaxOpenFiles is the default number of output files held open at once by
+// PartitionAlignments.
+const DefaultMaxOpenFiles = 200
+
+// addProgramLine returns a SAM header with a @PG line recording the command line of the
+// running process appended, chained to the last @PG line of the header by its PP field.
+// An @HD line is added if the header has none.
+func addProgramLine(header string) string {
+
+	header = setSortOrder(header, SortOrder(header))
+
+	ids := map[string]bool{}
+	last := ""
+	for _, line := range strings.Split(header, "\n") {
+		if !strings.HasPrefix(line, "@PG") {
+			continue
+		}
+		for _, field := range strings.Split(line, "\t")[1:] {
+			if strings.HasPrefix(field, "ID:") {
+				ids[field[3:]] = true
+				last = field[3:]
+			}
+		}
+	}
+
+	id := "lxy"
+	for i := 1; ids[id]; i++ {
+		id = fmt.Sprintf("lxy.%d", i)
+	}
+
+	pg := "@PG\tID:" + id + "\tPN:lxy"
+	if len(last) > 0 {
+		pg += "\tPP:" + last
+	}
+	pg += "\tCL:" + strings.Join(os.Args, " ")
+
+	return header + pg + "\n"
+
+}
+
+// ReadGroups reads an assignment of contigs to groups, such as the clusters of a
+// contig clustering, from a file of whitespace-delimited contig, group lines. Blank
+// lines and lines beginning with "#" are skipped.
+func ReadGroups(path string) (map[string]string, error) {
+
+	in, err := OpenInput(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	groups := map[string]string{}
+	s := bufio.NewScanner(in)
+	for line := 1; s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Couldn't parse line %d of groups file %s, expected a contig and a group", line, path)
+		}
+		groups[fields[0]] = fields[1]
+	}
+
+	return groups, s.Err()
+
+}
+
+// partitionOutput is an output file of PartitionAlignments held open in the pool.
+type partitionOutput struct {
+	group string
+	w     AlignmentWriter
+}
+
+// partitionPool holds the output files of PartitionAlignments open for writing, closing
+// the least recently written when more than max are open and reopening files for
+// appending when written to again.
+type partitionPool struct {
+	outstem string
+	ext     string
+	header  string
+	max     int
+	lru     *list.List
+	open    map[string]*list.Element
+	created map[string]bool
+}
+
+// path returns the output path of a group, with any path separators in the group name
+// replaced.
+func (p *partitionPool) path(group string) string {
+	return p.outstem + "." + strings.Replace(group, string(os.PathSeparator), "_", -1) + p.ext
+}
+
+// writer returns the output of a group, creating it with the header on the first
+// request and otherwise opening it for appending if it has since been closed.
+func (p *partitionPool) writer(group string) (AlignmentWriter, error) {
+
+	if e, ok := p.open[group]; ok {
+		p.lru.MoveToFront(e)
+		return e.Value.(*partitionOutput).w, nil
+	}
+
+	if p.lru.Len() >= p.max {
+		e := p.lru.Back()
+		out := p.lru.Remove(e).(*partitionOutput)
+		delete(p.open, out.group)
+		if err := out.w.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	var w AlignmentWriter
+	path := p.path(group)
+	if !p.created[group] {
+		cw, err := CreateAlignments(path, p.header)
+		if err != nil {
+			return nil, err
+		}
+		w = cw
+		p.created[group] = true
+	} else {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0666)
+		if err != nil {
+			return nil, err
+		}
+		if p.ext == ".bam" {
+			w = appendBAMWriter(f, p.header)
+		} else {
+			w = &samWriter{f, bufio.NewWriter(f)}
+		}
+	}
+
+	p.open[group] = p.lru.PushFront(&partitionOutput{group, w})
+	return w, nil
+
+}
+
+// Close closes every open output, returning the first error encountered.
+func (p *partitionPool) Close() error {
+	var err error
+	for e := p.lru.Front(); e != nil; e = e.Next() {
+		if ec := e.Value.(*partitionOutput).w.Close(); ec != nil && err == nil {
+			err = ec
+		}
+	}
+	p.lru.Init()
+	p.open = map[string]*list.Element{}
+	return err
+}
+
+// PartitionAlignmentsByContig takes a path of an alignments file and partitions
+// it into separate files with the alignments for each chromosome each in a separate
+// file. The output is written to the output directory with the specified output stem
+// with the chromosome name included, e.g. /path/to/file/outstem.chrNN.[sam/bam]
+//
+// See PartitionAlignments, which this calls with no grouping and the default limit on
+// open files.
+func PartitionAlignmentsByContig(inputPath, outstem string) error {
+	return PartitionAlignments(inputPath, outstem, nil, DefaultMaxOpenFiles)
+}
+
+// PartitionAlignments partitions an alignments file into a file for each group of
+// contigs, written to outstem.group.[sam/bam], with each record in the file of the group
+// of the contig to which it aligned. Groups map contig names to group names, see
+// ReadGroups, and if nil each contig is its own group. Records aligned to contigs
+// without a group are skipped, as are unaligned records.
+//
+// BAM input yields BAM output and SAM input yields SAM output, with each output
+// file carrying the header of the input, including all of its @SQ lines, along with a
+// @PG line for the partitioning. At most maxOpenFiles outputs are held open at once,
+// the least recently written being closed and later reopened for appending, such that
+// assemblies of any number of contigs can be partitioned.
+func PartitionAlignments(inputPath, outstem string, groups map[string]string, maxOpenFiles int) error {
+
+	// Open the input file for reading
+	in, err := OpenAlignments(inputPath)
+	if err != nil {
+		return fmt.Errorf("error: PartitionAlignments(%s, %s) failed at attempt to open input file", inputPath, outstem)
+	}
+	defer in.Close()
+
+	if maxOpenFiles < 1 {
+		maxOpenFiles = 1
+	}
+
+	// Write alignments in the same format in which they were read
+	ext := ".sam"
+	if _, ok := in.(*bamReader); ok {
+		ext = ".bam"
+	}
+
+	pool := &partitionPool{outstem, ext, addProgramLine(in.Header()), maxOpenFiles, list.New(), map[string]*list.Element{}, map[string]bool{}}
+
+	for in.Scan() {
+
+		alignment, e := in.Alignment()
+		if e == ErrUnaligned {
+			continue
+		} else if e != nil {
+			pool.Close()
+			return e
+		}
+
+		group := alignment.rname
+		if groups != nil {
+			g, ok := groups[alignment.rname]
+			if !ok {
+				continue
+			}
+			group = g
+		}
+
+		w, err := pool.writer(group)
+		if err != nil {
+			pool.Close()
+			return err
+		}
+		if err := w.Write(alignment); err != nil {
+			pool.Close()
+			return err
+		}
+
+	}
+
+	if err := in.Err(); err != nil {
+		pool.Close()
+		return err
+	}
+
+	// Return the first error closing the outputs, if any, or nil, signaling an error
+	// free run
+	return pool.Close()
+
+}
@@ -0,0 +1,97 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPartitionAlignments(t *testing.T) {
+
+	dir := "/tmp/lxy/test/partition"
+	os.RemoveAll(dir)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Records alternate between five contigs, such that with two open files every
+	// output is closed and reopened for appending.
+	header := "@HD\tVN:1.6\tSO:unknown\n"
+	for i := 0; i < 5; i++ {
+		header += fmt.Sprintf("@SQ\tSN:ctg%d\tLN:1000\n", i)
+	}
+	header += "@PG\tID:bwa\tPN:bwa\n"
+	records := []Alignment{}
+	for i := 0; i < 20; i++ {
+		a, _ := parseSAMLine(fmt.Sprintf("read-%d\t0\tctg%d\t%d\t60\t3M\t*\t0\t0\tATG\tIII", i, i%5, i+1))
+		records = append(records, a)
+	}
+	unaligned, _ := parseSAMLine("read-u\t4\t*\t0\t0\t*\t*\t0\t0\tATG\tIII")
+	records = append(records, unaligned)
+
+	groups := map[string]string{"ctg0": "a", "ctg1": "a", "ctg2": "b", "ctg3": "b"}
+
+	for _, ext := range []string{".sam", ".bam"} {
+
+		inPath := dir + "/input" + ext
+		w, err := CreateAlignments(inPath, header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, a := range records {
+			w.Write(a)
+		}
+		w.Close()
+
+		read := func(path string) (string, []string) {
+			in, err := OpenAlignments(path)
+			if err != nil {
+				t.Errorf("PartitionAlignments(%s) did not write %s", inPath, path)
+				return "", []string{}
+			}
+			defer in.Close()
+			qnames := []string{}
+			for in.Scan() {
+				a, _ := in.Alignment()
+				qnames = append(qnames, a.qname)
+			}
+			return in.Header(), qnames
+		}
+
+		if err := PartitionAlignments(inPath, dir+"/contig", nil, 2); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 5; i++ {
+			h, qnames := read(fmt.Sprintf("%s/contig.ctg%d%s", dir, i, ext))
+			key := []string{}
+			for j := i; j < 20; j += 5 {
+				key = append(key, fmt.Sprintf("read-%d", j))
+			}
+			if strings.Join(qnames, ",") != strings.Join(key, ",") {
+				t.Errorf("PartitionAlignments(%s) wrote records %v for ctg%d, expected %v", inPath, qnames, i, key)
+			}
+			if !strings.HasPrefix(h, header) || !strings.Contains(h, "@PG\tID:lxy\tPN:lxy\tPP:bwa") {
+				t.Errorf("PartitionAlignments(%s) wrote header:\n%s", inPath, h)
+			}
+		}
+		if _, err := os.Stat(dir + "/contig.*" + ext); err == nil {
+			t.Errorf("PartitionAlignments(%s) wrote unaligned records", inPath)
+		}
+
+		if err := PartitionAlignments(inPath, dir+"/group", groups, 1); err != nil {
+			t.Fatal(err)
+		}
+		_, a := read(dir + "/group.a" + ext)
+		_, b := read(dir + "/group.b" + ext)
+		if len(a) != 8 || len(b) != 8 || a[0] != "read-0" || a[1] != "read-1" || a[2] != "read-5" {
+			t.Errorf("PartitionAlignments(%s) by group wrote %v and %v", inPath, a, b)
+		}
+		if _, err := os.Stat(dir + "/group.ctg4" + ext); err == nil {
+			t.Errorf("PartitionAlignments(%s) by group wrote records of an ungrouped contig", inPath)
+		}
+
+	}
+
+}
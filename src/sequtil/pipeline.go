@@ -0,0 +1,117 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// pairBatchSize is the number of read pairs passed to a worker at once by
+// buildLinksFromSam, amortizing the cost of channel communication.
+const pairBatchSize = 256
+
+// pairTabulator tabulates the links evidenced by a single read pair into a Links
+// object. Tabulators are run concurrently, each with its own Links object, and so must
+// not modify any other shared state.
+type pairTabulator func(shard *Links, a1, a2 Alignment)
+
+// buildLinksFromSam constructs a Links object from the read pairs of an alignments file
+// as a pipeline: the calling goroutine reads the input, forms a read pair from each
+// group of records sharing a qname, resolving chimeric reads, see pairHits, and applies
+// the filter, which may be nil, to each pair in input order, and a pool of worker
+// goroutines tabulates the accepted pairs into their own shard of links. The shards
+// are merged once the input is exhausted, see mergeLinks.
+//
+// Filters are applied before pairs are distributed to the workers, such that stateful
+// filters like a Deduplicator see the pairs in input order, and as the merge does not
+// depend on the order in which pairs were tabulated the result is the same for any
+// number of threads.
+func buildLinksFromSam(samPath string, filter *PairFilterChain, threads int, tabulate pairTabulator) (Links, error) {
+
+	in, err := openGroupedAlignments(samPath)
+	if err != nil {
+		return Links{}, err
+	}
+	defer in.Close()
+
+	return buildLinks(in, filter, threads, tabulate)
+
+}
+
+// buildLinks is buildLinksFromSam for the alignments of a reader whose records are
+// grouped by read name, e.g. as streamed from an aligner, see AlignHiC. The reader is
+// not closed.
+func buildLinks(in AlignmentReader, filter *PairFilterChain, threads int, tabulate pairTabulator) (Links, error) {
+
+	if threads < 1 {
+		threads = 1
+	}
+
+	batches := make(chan [][2]Alignment, threads)
+	shards := make([]Links, threads)
+	var wg sync.WaitGroup
+	for i := 0; i < threads; i++ {
+		shards[i] = NewLinks()
+		wg.Add(1)
+		go func(shard *Links) {
+			defer wg.Done()
+			for batch := range batches {
+				for _, p := range batch {
+					tabulate(shard, p[0], p[1])
+				}
+			}
+		}(&shards[i])
+	}
+
+	batch := make([][2]Alignment, 0, pairBatchSize)
+	pairs := newPairScanner(in)
+	for pairs.Scan() {
+		a1, a2 := pairs.Pair()
+		if !filter.Accept(a1, a2) {
+			continue
+		}
+		batch = append(batch, [2]Alignment{a1, a2})
+		if len(batch) == pairBatchSize {
+			batches <- batch
+			batch = make([][2]Alignment, 0, pairBatchSize)
+		}
+	}
+	if len(batch) > 0 {
+		batches <- batch
+	}
+	close(batches)
+	wg.Wait()
+
+	fmt.Print(pairs.Summary())
+	return mergeLinks(shards), in.Err()
+
+}
+
+// mergeLinks merges a set of partial Links objects into one, see Links.AddMulti. The
+// entities of the merged object are assigned IDs in the sorted order of their names,
+// such that the result does not depend on how links were divided among the shards.
+func mergeLinks(shards []Links) Links {
+
+	names := map[string]bool{}
+	for _, shard := range shards {
+		for name := range shard.idKey {
+			names[name] = true
+		}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	links := NewLinks()
+	for _, name := range sorted {
+		links.ID(name)
+	}
+	for _, shard := range shards {
+		links.AddMulti(shard)
+	}
+
+	return links
+
+}
@@ -0,0 +1,66 @@
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestScaffoldLinksFromSamThreads(t *testing.T) {
+
+	samPath := "/tmp/lxy/test/testpipeline.sam"
+	MkdirForFile(samPath)
+
+	// Many more pairs than fit in a single batch, with duplicates spread throughout.
+	sam := []string{}
+	for i := 0; i < 5*pairBatchSize; i++ {
+		chr1 := fmt.Sprintf("chr%d", i%3)
+		chr2 := fmt.Sprintf("chr%d", i%4)
+		pos := 1 + i%(3*pairBatchSize)
+		sam = append(sam,
+			fmt.Sprintf("read-%d\t65\t%s\t%d\t60\t3M\t%s\t100\t0\tATG\tIII", i, chr1, pos, chr2),
+			fmt.Sprintf("read-%d\t129\t%s\t100\t60\t3M\t%s\t%d\t0\tGCG\tIII", i, chr2, chr1, pos))
+	}
+	f, err := os.Create(samPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("@HD\tVN:1.6\tSO:queryname\n" + strings.Join(sam, "\n") + "\n")
+	f.Close()
+
+	// The links written are the same for any number of threads.
+	written := []string{}
+	for _, threads := range []int{1, 2, 8} {
+		outPath := fmt.Sprintf("/tmp/lxy/test/testpipeline.%d.links", threads)
+		if err := ScaffoldLinksFromSam(samPath, outPath, nil, NewPairFilterChain(NewDeduplicator(0)), threads); err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadFile(outPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		written = append(written, string(b))
+		os.Remove(outPath)
+	}
+	for i := 1; i < len(written); i++ {
+		if written[i] != written[0] {
+			t.Errorf("ScaffoldLinksFromSam(%s) wrote different links with different numbers of threads:\n%s\n%s", samPath, written[0], written[i])
+		}
+	}
+
+	// Every pair, including the last, is counted once less the duplicates.
+	total := 0.0
+	for _, line := range strings.Split(strings.TrimSpace(written[0]), "\n")[1:] {
+		var v float64
+		fmt.Sscanf(strings.Fields(line)[2], "%f", &v)
+		total += v
+	}
+	if total != float64(3*pairBatchSize) {
+		t.Errorf("ScaffoldLinksFromSam(%s) counted %f pairs, expected %d", samPath, total, 3*pairBatchSize)
+	}
+
+	os.Remove(samPath)
+
+}
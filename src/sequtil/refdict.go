@@ -0,0 +1,211 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+)
+
+// RefDict is the sequence dictionary of a reference assembly, the names and lengths of
+// its contigs in the order of the reference, as given by the @SQ lines of a SAM header,
+// a FASTA index or the FASTA file itself.
+type RefDict struct {
+
+	// The path or other identifier of the reference, e.g. the UR or AS field of an @SQ
+	// line, which may be empty
+	Source string
+
+	names   []string
+	lengths map[string]int
+}
+
+// NewRefDict constructs an empty RefDict for the reference with the specified source.
+func NewRefDict(source string) *RefDict {
+	return &RefDict{Source: source, names: []string{}, lengths: map[string]int{}}
+}
+
+// Add adds a contig to the dictionary, or sets the length of a contig already present.
+func (d *RefDict) Add(name string, length int) {
+	if _, ok := d.lengths[name]; !ok {
+		d.names = append(d.names, name)
+	}
+	d.lengths[name] = length
+}
+
+// Names returns the names of the contigs in the order of the reference.
+func (d *RefDict) Names() []string {
+	return d.names
+}
+
+// Length returns the length of a contig, and false if there is no such contig.
+func (d *RefDict) Length(name string) (int, bool) {
+	l, ok := d.lengths[name]
+	return l, ok
+}
+
+// Size returns the number of contigs in the dictionary.
+func (d *RefDict) Size() int {
+	return len(d.names)
+}
+
+// TotalLength returns the summed length of the contigs.
+func (d *RefDict) TotalLength() int {
+	total := 0
+	for _, l := range d.lengths {
+		total += l
+	}
+	return total
+}
+
+// Check returns an error if a contig of another dictionary, such as that of the header
+// of a set of alignments, is missing from this one or differs in length, as when the
+// alignments are to a different reference.
+func (d *RefDict) Check(other *RefDict) error {
+	for _, name := range other.Names() {
+		l1, ok := d.Length(name)
+		l2, _ := other.Length(name)
+		if !ok {
+			return fmt.Errorf("Contig %s is missing from the reference %s", name, d.Source)
+		} else if l1 != l2 {
+			return fmt.Errorf("Contig %s has length %d in the reference %s, not %d", name, l1, d.Source, l2)
+		}
+	}
+	return nil
+}
+
+// Header returns the dictionary as the @SQ lines of a SAM header.
+func (d *RefDict) Header() string {
+	lines := make([]string, len(d.names))
+	for i, name := range d.names {
+		lines[i] = fmt.Sprintf("@SQ\tSN:%s\tLN:%d\n", name, d.lengths[name])
+	}
+	return strings.Join(lines, "")
+}
+
+// RefDictFromHeader builds a RefDict from the @SQ lines of a SAM header, taking its
+// source from the UR or, failing that, AS field of the first @SQ line with either.
+func RefDictFromHeader(header string) *RefDict {
+
+	d := NewRefDict("")
+	names, lengths := bamRefs(header)
+	for i, name := range names {
+		d.Add(name, lengths[i])
+	}
+
+	ur, as := "", ""
+	for _, line := range strings.Split(header, "\n") {
+		if !strings.HasPrefix(line, "@SQ") {
+			continue
+		}
+		for _, field := range strings.Split(line, "\t")[1:] {
+			if strings.HasPrefix(field, "UR:") && len(ur) == 0 {
+				ur = field[3:]
+			} else if strings.HasPrefix(field, "AS:") && len(as) == 0 {
+				as = field[3:]
+			}
+		}
+	}
+	d.Source = ur
+	if len(d.Source) == 0 {
+		d.Source = as
+	}
+
+	return d
+
+}
+
+// RefDictFromFai builds a RefDict from a FASTA index (.fai), its source being the path
+// of the indexed FASTA file.
+func RefDictFromFai(path string) (*RefDict, error) {
+
+	index, err := ReadFai(path)
+	if err != nil {
+		return nil, err
+	}
+
+	d := NewRefDict(strings.TrimSuffix(path, ".fai"))
+	for _, rec := range index {
+		d.Add(rec.Name, rec.Length)
+	}
+
+	return d, nil
+
+}
+
+// RefDictFromFasta builds a RefDict from a FASTA file, using its index if it can be
+// indexed, see OpenFasta, and otherwise, e.g. for compressed files, by reading the
+// sequences in full.
+func RefDictFromFasta(path string) (*RefDict, error) {
+
+	d := NewRefDict(path)
+	if fr, err := OpenFasta(path); err == nil {
+		defer fr.Close()
+		for _, rec := range fr.Index() {
+			d.Add(rec.Name, rec.Length)
+		}
+		return d, nil
+	}
+
+	in, err := OpenInput(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	name, length := "", 0
+	s := bufio.NewScanner(in)
+	s.Buffer(make([]byte, 64*1024), 1024*1024*1024)
+	for s.Scan() {
+		line := strings.TrimRight(s.Text(), "\r")
+		if strings.HasPrefix(line, ">") {
+			if len(name) > 0 {
+				d.Add(name, length)
+			}
+			fields := strings.Fields(line[1:])
+			if len(fields) == 0 {
+				return nil, fmt.Errorf("Couldn't read the contigs of %s, which has a sequence header with no name", path)
+			}
+			name, length = fields[0], 0
+		} else {
+			length += len(line)
+		}
+	}
+	if len(name) > 0 {
+		d.Add(name, length)
+	}
+
+	return d, s.Err()
+
+}
+
+// LoadRefDict builds a RefDict from a SAM or BAM file, a FASTA index or a FASTA file,
+// determining which of these it is from its content rather than its extension.
+func LoadRefDict(path string) (*RefDict, error) {
+
+	in, err := openInput(path)
+	if err != nil {
+		return nil, err
+	}
+	first, _ := in.Peek(1)
+	compressed := in.gz != nil
+	in.Close()
+
+	switch {
+	case len(first) > 0 && first[0] == '>':
+		return RefDictFromFasta(path)
+	case compressed || (len(first) > 0 && first[0] == '@'):
+		aln, err := OpenAlignments(path)
+		if err != nil {
+			return nil, err
+		}
+		defer aln.Close()
+		d := RefDictFromHeader(aln.Header())
+		if d.Size() == 0 {
+			return nil, fmt.Errorf("Couldn't read a sequence dictionary from %s, which has no @SQ lines", path)
+		}
+		return d, nil
+	}
+
+	return RefDictFromFai(path)
+
+}
@@ -0,0 +1,105 @@
+package util
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRefDict(t *testing.T) {
+
+	header := "@HD\tVN:1.6\n@SQ\tSN:chr1\tLN:23\tAS:hg38\n@SQ\tSN:chr2\tLN:10\tUR:file:/ref.fa\n@SQ\tSN:chr3\tLN:7\n"
+	d := RefDictFromHeader(header)
+	if d.Source != "file:/ref.fa" {
+		t.Errorf("Expected the source to be taken from UR, got %s", d.Source)
+	}
+	if !reflect.DeepEqual(d.Names(), []string{"chr1", "chr2", "chr3"}) || d.TotalLength() != 40 {
+		t.Errorf("Unexpected contigs %v of total length %d", d.Names(), d.TotalLength())
+	}
+	if l, ok := d.Length("chr2"); !ok || l != 10 {
+		t.Errorf("Expected chr2 of length 10, got %d", l)
+	}
+	if d.Header() != "@SQ\tSN:chr1\tLN:23\n@SQ\tSN:chr2\tLN:10\n@SQ\tSN:chr3\tLN:7\n" {
+		t.Errorf("Unexpected header %q", d.Header())
+	}
+
+	// The dictionary is the same from each kind of file.
+	dir := "/tmp/lxy/test/refdict/"
+	writeTestFile(t, dir+"ref.fa", testFasta)
+	gz, err := CreateOutput(dir + "ref.fa.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz.Write([]byte(testFasta))
+	gz.Close()
+	writeTestFile(t, dir+"ref.sam", header)
+	if _, err := OpenFasta(dir + "ref.fa"); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{dir + "ref.fa", dir + "ref.fa.gz", dir + "ref.fa.fai", dir + "ref.sam"} {
+		loaded, err := LoadRefDict(path)
+		if err != nil {
+			t.Errorf("Couldn't load a dictionary from %s: %s", path, err)
+			continue
+		}
+		if !reflect.DeepEqual(loaded.lengths, d.lengths) || !reflect.DeepEqual(loaded.Names(), d.Names()) {
+			t.Errorf("Expected the dictionary of %s to be %v, got %v", path, d.lengths, loaded.lengths)
+		}
+	}
+	writeTestFile(t, dir+"noname.fa", ">chr1\nACGT\n>\nACGT\n")
+	if _, err := LoadRefDict(dir + "noname.fa"); err == nil {
+		t.Errorf("Expected an error loading a dictionary from a FASTA header with no name")
+	}
+
+	if err := d.Check(RefDictFromHeader("@SQ\tSN:chr2\tLN:10\n")); err != nil {
+		t.Errorf("Expected a subset of the dictionary to check, got %s", err)
+	}
+	for _, other := range []string{"@SQ\tSN:chr2\tLN:11\n", "@SQ\tSN:chr4\tLN:10\n"} {
+		if err := d.Check(RefDictFromHeader(other)); err == nil {
+			t.Errorf("Expected an error checking %q", other)
+		}
+	}
+
+}
+
+func TestLinksReference(t *testing.T) {
+
+	samPath := "/tmp/lxy/test/testlinksref.sam"
+	outPath := "/tmp/lxy/test/testlinksref.links"
+	writeTestFile(t, samPath, "@SQ\tSN:chr1\tLN:1000\tUR:ref.fa\n@SQ\tSN:chr2\tLN:500\n"+strings.Join([]string{
+		"read1\t65\tchr1\t100\t60\t3M\tchr2\t100\t0\tATG\tIII",
+		"read1\t129\tchr2\t100\t60\t3M\tchr1\t100\t0\tGCG\tIII",
+	}, "\n")+"\n")
+
+	// The lengths of the contigs are recorded in the links and read back.
+	if err := ScaffoldLinksFromSam(samPath, outPath, nil, nil, 1); err != nil {
+		t.Fatal(err)
+	}
+	links, err := LoadLinks(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if links.Reference() == nil || links.Reference().Source != "ref.fa" {
+		t.Fatalf("Expected the reference to be recorded in the links")
+	}
+	if l, ok := links.Length(links.ID("chr2")); !ok || l != 500 {
+		t.Errorf("Expected chr2 to have length 500, got %d", l)
+	}
+	if v, _ := links.Get(links.ID("chr1"), links.ID("chr2")); v != 1 {
+		t.Errorf("Expected 1 chr1-chr2 link, got %f", v)
+	}
+
+	// Alignments to another reference are rejected.
+	in, err := OpenAlignments(samPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	other := NewRefDict("other.fa")
+	other.Add("chr1", 1000)
+	other.Add("chr2", 600)
+	if err := ScaffoldLinksFromAlignments(in, outPath, other, nil, 1); err == nil {
+		t.Errorf("Expected an error building links from alignments to another reference")
+	}
+
+}
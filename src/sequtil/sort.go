@@ -0,0 +1,368 @@
+package util
+
+import (
+	"container/heap"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultSortMemory is the default budget, in bytes, for the alignment records held in
+// memory at once by SortAlignmentsByName.
+const DefaultSortMemory = 768 * 1024 * 1024
+
+// groupCheckRecords is the number of records at the start of an alignments file whose
+// qnames are checked to determine whether an unlabelled file is grouped by read name.
+const groupCheckRecords = 100000
+
+// recordSize estimates the memory used by an Alignment held in memory.
+func recordSize(a Alignment) int {
+	return 256 + len(a.qname) + len(a.rname) + len(a.rnext) + len(a.seq) + len(a.qual) + 16*len(a.cigar) + 64*len(a.tags)
+}
+
+// SortOrder returns the sort order given by the SO field of the @HD line of a SAM
+// header, e.g. "queryname" or "coordinate", or "unknown" if none is given.
+func SortOrder(header string) string {
+	for _, line := range strings.Split(header, "\n") {
+		if !strings.HasPrefix(line, "@HD") {
+			continue
+		}
+		for _, field := range strings.Split(line, "\t")[1:] {
+			if strings.HasPrefix(field, "SO:") {
+				return field[3:]
+			}
+		}
+	}
+	return "unknown"
+}
+
+// setSortOrder returns a SAM header with the SO field of its @HD line set to the
+// specified sort order, adding an @HD line if there is none.
+func setSortOrder(header, order string) string {
+
+	lines := strings.SplitAfter(header, "\n")
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "@HD") {
+		return "@HD\tVN:1.6\tSO:" + order + "\n" + header
+	}
+
+	fields := strings.Split(strings.TrimRight(lines[0], "\n"), "\t")
+	set := false
+	for i, field := range fields {
+		if strings.HasPrefix(field, "SO:") {
+			fields[i] = "SO:" + order
+			set = true
+		}
+	}
+	if !set {
+		fields = append(fields, "SO:"+order)
+	}
+	lines[0] = strings.Join(fields, "\t") + "\n"
+
+	return strings.Join(lines, "")
+
+}
+
+// maxMergeChunks is the largest number of sorted chunks merged at once, and so of chunk
+// files open at once, by SortAlignmentsByName, which merges more in several passes.
+const maxMergeChunks = 64
+
+// sortChunk is a run of records sorted in memory and written to a temporary file,
+// being read back during the merge.
+type sortChunk struct {
+	in    AlignmentReader
+	cur   Alignment
+	index int
+}
+
+// chunkHeap orders the chunks being merged by the qname of their current record,
+// breaking ties by the order of the chunks so that the sort is stable.
+type chunkHeap []*sortChunk
+
+func (h chunkHeap) Len() int { return len(h) }
+func (h chunkHeap) Less(i, j int) bool {
+	if h[i].cur.qname != h[j].cur.qname {
+		return h[i].cur.qname < h[j].cur.qname
+	}
+	return h[i].index < h[j].index
+}
+func (h chunkHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *chunkHeap) Push(x interface{}) { *h = append(*h, x.(*sortChunk)) }
+func (h *chunkHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// next advances a chunk to its next record, returning false when it is exhausted.
+func (c *sortChunk) next() (bool, error) {
+	if !c.in.Scan() {
+		return false, c.in.Err()
+	}
+	a, err := c.in.Alignment()
+	if err != nil && err != ErrUnaligned {
+		return false, err
+	}
+	c.cur = a
+	return true, nil
+}
+
+// SortAlignmentsByName sorts the records of a SAM or BAM file by qname, such that the
+// records of each read pair are adjacent as the link builders require, writing SAM or
+// BAM according to the extension of the output path.
+//
+// Records are sorted in memory in chunks of at most maxMemory bytes, see
+// DefaultSortMemory, which are written to temporary files in tmpDir (the system
+// temporary directory if empty) and then merged. The sort is stable, records sharing
+// a qname remaining in the order in which they were read, and unaligned records are
+// retained.
+func SortAlignmentsByName(inPath, outPath string, maxMemory int, tmpDir string) error {
+
+	in, err := OpenAlignments(inPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	header := setSortOrder(in.Header(), "queryname")
+
+	chunkDir, err := ioutil.TempDir(tmpDir, "lxysort")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(chunkDir)
+
+	// Write sorted chunks of the input to temporary files.
+	chunkPaths := []string{}
+	records := []Alignment{}
+	size := 0
+	flush := func() error {
+		sort.SliceStable(records, func(i, j int) bool { return records[i].qname < records[j].qname })
+		path := filepath.Join(chunkDir, "chunk"+strconv.Itoa(len(chunkPaths))+".sam")
+		out, err := CreateAlignments(path, "")
+		if err != nil {
+			return err
+		}
+		for _, a := range records {
+			if err := out.Write(a); err != nil {
+				out.Close()
+				return err
+			}
+		}
+		chunkPaths = append(chunkPaths, path)
+		records = []Alignment{}
+		size = 0
+		return out.Close()
+	}
+
+	for in.Scan() {
+		a, err := in.Alignment()
+		if err != nil && err != ErrUnaligned {
+			return fmt.Errorf("Couldn't sort %s: %s", inPath, err)
+		}
+		records = append(records, a)
+		size += recordSize(a)
+		if size >= maxMemory {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := in.Err(); err != nil {
+		return err
+	}
+	if len(records) > 0 || len(chunkPaths) == 0 {
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+
+	// Merge the chunks in passes of at most maxMergeChunks, such that no more chunk files
+	// are open at once, and then into the output.
+	for pass := 0; len(chunkPaths) > maxMergeChunks; pass++ {
+		merged := []string{}
+		for i := 0; i < len(chunkPaths); i += maxMergeChunks {
+			j := i + maxMergeChunks
+			if j > len(chunkPaths) {
+				j = len(chunkPaths)
+			}
+			path := filepath.Join(chunkDir, "merge"+strconv.Itoa(pass)+"."+strconv.Itoa(len(merged))+".sam")
+			out, err := CreateAlignments(path, "")
+			if err != nil {
+				return err
+			}
+			if err := mergeChunks(chunkPaths[i:j], out); err != nil {
+				out.Close()
+				return err
+			}
+			if err := out.Close(); err != nil {
+				return err
+			}
+			for _, p := range chunkPaths[i:j] {
+				os.Remove(p)
+			}
+			merged = append(merged, path)
+		}
+		chunkPaths = merged
+	}
+
+	out, err := CreateAlignments(outPath, header)
+	if err != nil {
+		return err
+	}
+	if err := mergeChunks(chunkPaths, out); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+
+}
+
+// mergeChunks merges the records of sorted chunk files into an AlignmentWriter, which is
+// not closed, breaking ties by the order of the chunks so that the merge is stable. Each
+// chunk is closed as soon as it is exhausted.
+func mergeChunks(paths []string, out AlignmentWriter) error {
+
+	h := &chunkHeap{}
+	defer func() {
+		for _, c := range *h {
+			c.in.Close()
+		}
+	}()
+
+	for i, path := range paths {
+		cin, err := OpenAlignments(path)
+		if err != nil {
+			return err
+		}
+		c := &sortChunk{in: cin, index: i}
+		if ok, err := c.next(); err != nil || !ok {
+			cin.Close()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		heap.Push(h, c)
+	}
+
+	for h.Len() > 0 {
+		c := (*h)[0]
+		if err := out.Write(c.cur); err != nil {
+			return err
+		}
+		ok, err := c.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+			c.in.Close()
+		}
+	}
+
+	return nil
+
+}
+
+// GroupedByName determines whether the records of an alignments file are grouped by
+// read name, as they are when sorted by qname or as output by an aligner. Files with a
+// header giving their sort order are taken at their word, otherwise the first records
+// of the file are checked for a qname recurring after the records of another read.
+func GroupedByName(path string) (bool, error) {
+
+	in, err := OpenAlignments(path)
+	if err != nil {
+		return false, err
+	}
+	defer in.Close()
+
+	switch SortOrder(in.Header()) {
+	case "queryname":
+		return true, nil
+	case "coordinate":
+		return false, nil
+	}
+
+	seen := map[string]bool{}
+	currentID := ""
+	for i := 0; i < groupCheckRecords && in.Scan(); i++ {
+		a, err := in.Alignment()
+		if err != nil && err != ErrUnaligned {
+			continue
+		}
+		if a.qname != currentID {
+			if seen[a.qname] {
+				return false, nil
+			}
+			seen[a.qname] = true
+			currentID = a.qname
+		}
+	}
+
+	return true, in.Err()
+
+}
+
+// tempAlignmentReader is an AlignmentReader over a file in a temporary directory which
+// is removed when the reader is closed.
+type tempAlignmentReader struct {
+	AlignmentReader
+	path string
+}
+
+// Close closes the reader and removes the temporary directory.
+func (t *tempAlignmentReader) Close() error {
+	err := t.AlignmentReader.Close()
+	os.RemoveAll(t.path)
+	return err
+}
+
+// openGroupedAlignments opens an alignments file for reading by one of the link
+// builders, which require the records of each read pair to be adjacent. Input which is
+// not grouped by read name, see GroupedByName, is first sorted by qname into a
+// temporary file. A .pairs file may be given in place of SAM or BAM, each of its pairs
+// being read as two alignment records, see Pair.Alignments.
+func openGroupedAlignments(path string) (AlignmentReader, error) {
+
+	if IsPairsFile(path) {
+		pr, err := OpenPairs(path)
+		if err != nil {
+			return nil, err
+		}
+		return &pairsAlignmentReader{pr: pr}, nil
+	}
+
+	grouped, err := GroupedByName(path)
+	if err != nil {
+		return nil, err
+	}
+	if grouped {
+		return OpenAlignments(path)
+	}
+
+	fmt.Printf("%s is not grouped by read name, sorting by qname...\n", path)
+	dir, err := ioutil.TempDir("", "lxysorted")
+	if err != nil {
+		return nil, err
+	}
+	sorted := filepath.Join(dir, "sorted.sam")
+
+	if err := SortAlignmentsByName(path, sorted, DefaultSortMemory, ""); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("Couldn't sort %s by qname: %s", path, err)
+	}
+
+	in, err := OpenAlignments(sorted)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &tempAlignmentReader{in, dir}, nil
+
+}
@@ -0,0 +1,141 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSortAlignmentsByName(t *testing.T) {
+
+	inPath := "/tmp/lxy/test/testsort.sam"
+	MkdirForFile(inPath)
+
+	header := "@HD\tVN:1.6\tSO:coordinate\n@SQ\tSN:chr1\tLN:1000\n@SQ\tSN:chr2\tLN:1000\n"
+	sam := []string{
+		"read-c\t65\tchr1\t10\t60\t3M\tchr2\t500\t0\tATG\tIII",
+		"read-a\t65\tchr1\t20\t60\t3M\t=\t300\t0\tATG\tIII",
+		"read-b\t73\tchr1\t30\t60\t3M\t=\t30\t0\tATG\tIII",
+		"read-b\t133\t*\t0\t0\t*\tchr1\t30\t0\tGCG\tIII",
+		"read-a\t145\tchr1\t300\t60\t3M\t=\t20\t0\tGCG\tIII",
+		"read-c\t145\tchr2\t500\t60\t3M\tchr1\t10\t0\tGCG\tIII",
+		"read-d\t0\tchr2\t600\t60\t3M\t*\t0\t0\tATG\tIII",
+		"read-a\t2113\tchr2\t700\t60\t3M\tchr1\t300\t0\tGCG\tIII\tSA:Z:chr1,300,-,3M,60,0;",
+	}
+	f, err := os.Create(inPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(header + strings.Join(sam, "\n") + "\n")
+	f.Close()
+
+	if grouped, _ := GroupedByName(inPath); grouped {
+		t.Errorf("GroupedByName(%s) reported a coordinate sorted file as grouped", inPath)
+	}
+
+	sortedKey := []string{sam[1], sam[4], sam[7], sam[2], sam[3], sam[0], sam[5], sam[6]}
+
+	// A memory budget of a single byte writes each record to its own chunk.
+	for _, outPath := range []string{"/tmp/lxy/test/testsort.sorted.sam", "/tmp/lxy/test/testsort.sorted.bam"} {
+		for _, mem := range []int{1, DefaultSortMemory} {
+
+			if err := SortAlignmentsByName(inPath, outPath, mem, ""); err != nil {
+				t.Fatal(err)
+			}
+
+			in, err := OpenAlignments(outPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if SortOrder(in.Header()) != "queryname" {
+				t.Errorf("SortAlignmentsByName(%s, %s) wrote header with sort order %s", inPath, outPath, SortOrder(in.Header()))
+			}
+			sorted := []string{}
+			for in.Scan() {
+				a, e := in.Alignment()
+				if e != nil && e != ErrUnaligned {
+					t.Error(e)
+				}
+				sorted = append(sorted, a.String())
+			}
+			in.Close()
+
+			if strings.Join(sorted, "\n") != strings.Join(sortedKey, "\n") {
+				t.Errorf("SortAlignmentsByName(%s, %s, %d) yielded records:\n%s\nexpected:\n%s", inPath, outPath, mem, strings.Join(sorted, "\n"), strings.Join(sortedKey, "\n"))
+			}
+
+			if grouped, _ := GroupedByName(outPath); !grouped {
+				t.Errorf("GroupedByName(%s) reported a qname sorted file as not grouped", outPath)
+			}
+
+			os.Remove(outPath)
+
+		}
+	}
+
+	// The link builders sort input that is not grouped by read name, such that the
+	// records of read-c, the only pair of exactly two aligned reads, are adjacent.
+	linksPath := "/tmp/lxy/test/testsort.links"
+	if err := ScaffoldLinksFromSam(inPath, linksPath, nil, nil, 1); err != nil {
+		t.Fatal(err)
+	}
+	links, _ := LoadLinks(linksPath)
+	v12, _ := links.Get(links.ID("chr1"), links.ID("chr2"))
+	if v12 != 1 {
+		t.Errorf("ScaffoldLinksFromSam(%s) of unsorted input yielded %f chr1-chr2 links, expected 1", inPath, v12)
+	}
+
+	// Input of more chunks than are merged at once is merged in several passes, the
+	// records of each read remaining in the order in which they were read.
+	n := maxMergeChunks*maxMergeChunks + 1
+	lines := []string{}
+	for i := 0; i < n; i++ {
+		lines = append(lines, fmt.Sprintf("read-%d\t0\tchr1\t%d\t60\t3M\t*\t0\t0\tATG\tIII", (n-i)%7, i+1))
+	}
+	writeTestFile(t, inPath, header+strings.Join(lines, "\n")+"\n")
+	outPath := "/tmp/lxy/test/testsort.passes.sam"
+	if err := SortAlignmentsByName(inPath, outPath, 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	in, err := OpenAlignments(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, last := 0, Alignment{}
+	for in.Scan() {
+		a, _ := in.Alignment()
+		if a.qname < last.qname || (a.qname == last.qname && a.pos < last.pos) {
+			t.Fatalf("SortAlignmentsByName(%s) of %d chunks yielded %s after %s", inPath, n, a, last)
+		}
+		count, last = count+1, a
+	}
+	in.Close()
+	if count != n {
+		t.Errorf("SortAlignmentsByName(%s) of %d chunks yielded %d records", inPath, n, count)
+	}
+
+	os.Remove(inPath)
+	os.Remove(outPath)
+	os.Remove(linksPath)
+
+}
+
+func TestSetSortOrder(t *testing.T) {
+
+	tests := []struct {
+		header string
+		key    string
+	}{
+		{"", "@HD\tVN:1.6\tSO:queryname\n"},
+		{"@SQ\tSN:chr1\tLN:10\n", "@HD\tVN:1.6\tSO:queryname\n@SQ\tSN:chr1\tLN:10\n"},
+		{"@HD\tVN:1.0\tSO:coordinate\n@SQ\tSN:chr1\tLN:10\n", "@HD\tVN:1.0\tSO:queryname\n@SQ\tSN:chr1\tLN:10\n"},
+		{"@HD\tVN:1.0\n", "@HD\tVN:1.0\tSO:queryname\n"},
+	}
+	for _, test := range tests {
+		if h := setSortOrder(test.header, "queryname"); h != test.key {
+			t.Errorf("setSortOrder(%q) yielded %q, expected %q", test.header, h, test.key)
+		}
+	}
+
+}
@@ -0,0 +1,222 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// samFlagNames gives a name for each bit of the SAM flag, in order of the bits.
+var samFlagNames = []string{"paired", "proper_pair", "unmapped", "mate_unmapped", "reverse", "mate_reverse", "read1", "read2", "secondary", "qc_fail", "duplicate", "supplementary"}
+
+// DistanceBin is a bin of the log-binned histogram of the distances between the 5' ends
+// of the reads of cis pairs, counting the pairs with distances in [Min, Max).
+type DistanceBin struct {
+	Min   int `json:"min"`
+	Max   int `json:"max"`
+	Count int `json:"count"`
+}
+
+// AlignmentStats summarizes the read pairs of a Hi-C library as aligned to an assembly,
+// see AlignmentStatsFromSam, for the purpose of assessing a library before it is used
+// for scaffolding or phasing.
+//
+// A pair is each group of records sharing a qname, mapped if a pair of aligned reads
+// can be formed from it, see pairHits, and unique if it is mapped and not a duplicate
+// of a previous pair, see Deduplicator. The cis and trans counts, insert distances and
+// contig contact totals are of the unique pairs, while the MAPQ histogram is of all
+// aligned records and the flag counts of all records.
+type AlignmentStats struct {
+	Records        int            `json:"records"`
+	TotalPairs     int            `json:"total_pairs"`
+	MappedPairs    int            `json:"mapped_pairs"`
+	UniquePairs    int            `json:"unique_pairs"`
+	CisPairs       int            `json:"cis_pairs"`
+	TransPairs     int            `json:"trans_pairs"`
+	CisTransRatio  float64        `json:"cis_trans_ratio"`
+	MAPQ           map[int]int    `json:"mapq"`
+	Distances      []DistanceBin  `json:"insert_distance"`
+	ContigContacts map[string]int `json:"contig_contacts"`
+	Flags          map[string]int `json:"flags"`
+
+	dedup *Deduplicator
+}
+
+// NewAlignmentStats constructs an empty AlignmentStats.
+func NewAlignmentStats() *AlignmentStats {
+	return &AlignmentStats{
+		MAPQ:           map[int]int{},
+		Distances:      []DistanceBin{},
+		ContigContacts: map[string]int{},
+		Flags:          map[string]int{},
+		dedup:          NewDeduplicator(0),
+	}
+}
+
+// addDistance counts a cis pair in the histogram bin of its insert distance, the bins
+// doubling in width such that bin i holds distances in [2^i, 2^(i+1)) and bin 0 also
+// holds a distance of zero.
+func (s *AlignmentStats) addDistance(d int) {
+	bin := 0
+	for d>>uint(bin+1) > 0 {
+		bin += 1
+	}
+	for len(s.Distances) <= bin {
+		i := len(s.Distances)
+		min := 1 << uint(i)
+		if i == 0 {
+			min = 0
+		}
+		s.Distances = append(s.Distances, DistanceBin{min, 1 << uint(i+1), 0})
+	}
+	s.Distances[bin].Count += 1
+}
+
+// add tabulates a group of records sharing a qname.
+func (s *AlignmentStats) add(group []Alignment) {
+
+	if len(group) == 0 {
+		return
+	}
+
+	s.TotalPairs += 1
+	aligned := []Alignment{}
+	for _, a := range group {
+		s.Records += 1
+		for bit, name := range samFlagNames {
+			if a.flag.code&(1<<uint(bit)) != 0 {
+				s.Flags[name] += 1
+			}
+		}
+		if !a.flag.unmapped && a.rname != "*" {
+			s.MAPQ[a.mapq] += 1
+			aligned = append(aligned, a)
+		}
+	}
+
+	a1, a2, ok := pairHits(aligned)
+	if !ok {
+		return
+	}
+	s.MappedPairs += 1
+
+	if !s.dedup.Accept(a1, a2) {
+		return
+	}
+	s.UniquePairs += 1
+	s.ContigContacts[a1.rname] += 1
+	s.ContigContacts[a2.rname] += 1
+
+	if a1.rname == a2.rname {
+		s.CisPairs += 1
+		s.addDistance(abs(fivePrime(a1) - fivePrime(a2)))
+	} else {
+		s.TransPairs += 1
+	}
+
+	if s.TransPairs > 0 {
+		s.CisTransRatio = float64(s.CisPairs) / float64(s.TransPairs)
+	}
+
+}
+
+// AlignmentStatsFromSam tabulates the statistics of the read pairs of an alignments
+// file, see AlignmentStats. Input not grouped by read name is first sorted by qname,
+// see openGroupedAlignments. The cis/trans ratio is zero if there are no trans pairs.
+func AlignmentStatsFromSam(samPath string) (*AlignmentStats, error) {
+
+	in, err := openGroupedAlignments(samPath)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	s := NewAlignmentStats()
+	currentID := ""
+	group := []Alignment{}
+	for in.Scan() {
+
+		// Unaligned records are counted, though records which could not be parsed are not.
+		a, e := in.Alignment()
+		if e != nil && e != ErrUnaligned {
+			continue
+		}
+
+		if a.qname != currentID {
+			s.add(group)
+			currentID = a.qname
+			group = []Alignment{}
+		}
+
+		group = append(group, a)
+
+	}
+	s.add(group)
+
+	return s, in.Err()
+
+}
+
+// WriteTSV writes the statistics as tab-delimited section, key, value lines, e.g.
+// "pairs<TAB>unique<TAB>1000", with the keys of each section in sorted order.
+func (s *AlignmentStats) WriteTSV(out io.Writer) error {
+
+	lines := [][]interface{}{
+		{"records", "total", s.Records},
+		{"pairs", "total", s.TotalPairs},
+		{"pairs", "mapped", s.MappedPairs},
+		{"pairs", "unique", s.UniquePairs},
+		{"pairs", "cis", s.CisPairs},
+		{"pairs", "trans", s.TransPairs},
+		{"pairs", "cis_trans_ratio", s.CisTransRatio},
+	}
+
+	mapqs := []int{}
+	for q := range s.MAPQ {
+		mapqs = append(mapqs, q)
+	}
+	sort.Ints(mapqs)
+	for _, q := range mapqs {
+		lines = append(lines, []interface{}{"mapq", q, s.MAPQ[q]})
+	}
+
+	for _, b := range s.Distances {
+		lines = append(lines, []interface{}{"insert_distance", fmt.Sprintf("%d-%d", b.Min, b.Max), b.Count})
+	}
+
+	contigs := []string{}
+	for c := range s.ContigContacts {
+		contigs = append(contigs, c)
+	}
+	sort.Strings(contigs)
+	for _, c := range contigs {
+		lines = append(lines, []interface{}{"contig_contacts", c, s.ContigContacts[c]})
+	}
+
+	for _, name := range samFlagNames {
+		lines = append(lines, []interface{}{"flags", name, s.Flags[name]})
+	}
+
+	if _, err := fmt.Fprintf(out, "section\tkey\tvalue\n"); err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintf(out, "%v\t%v\t%v\n", line...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+// WriteJSON writes the statistics as an indented JSON object.
+func (s *AlignmentStats) WriteJSON(out io.Writer) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(append(b, '\n'))
+	return err
+}
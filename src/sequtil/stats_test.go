@@ -0,0 +1,89 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAlignmentStatsFromSam(t *testing.T) {
+
+	samPath := "/tmp/lxy/test/teststats.sam"
+	MkdirForFile(samPath)
+
+	sam := []string{
+		"read-1\t65\tchr1\t100\t60\t10M\tchr1\t200\t0\tAACGTACGTT\tIIIIIIIIII",
+		"read-1\t145\tchr1\t200\t60\t10M\tchr1\t100\t0\tAACGTACGTT\tIIIIIIIIII",
+		"read-2\t65\tchr1\t100\t30\t10M\tchr1\t200\t0\tAACGTACGTT\tIIIIIIIIII",
+		"read-2\t145\tchr1\t200\t30\t10M\tchr1\t100\t0\tAACGTACGTT\tIIIIIIIIII",
+		"read-3\t65\tchr1\t100\t60\t10M\tchr2\t50\t0\tAACGTACGTT\tIIIIIIIIII",
+		"read-3\t129\tchr2\t50\t60\t10M\tchr1\t100\t0\tAACGTACGTT\tIIIIIIIIII",
+		"read-4\t73\tchr1\t500\t60\t10M\t=\t500\t0\tAACGTACGTT\tIIIIIIIIII",
+		"read-4\t133\t*\t0\t0\t*\tchr1\t500\t0\tAACGTACGTT\tIIIIIIIIII",
+		"read-5\t65\tchr1\t1000\t60\t10M\tchr1\t1000\t0\tAACGTACGTT\tIIIIIIIIII",
+		"read-5\t129\tchr1\t1000\t60\t10M\tchr1\t1000\t0\tAACGTACGTT\tIIIIIIIIII",
+	}
+	f, err := os.Create(samPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(strings.Join(sam, "\n") + "\n")
+	f.Close()
+	defer os.Remove(samPath)
+
+	s, err := AlignmentStatsFromSam(samPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// read-2 duplicates read-1 and read-4 has an unmapped mate. The 5' end of the
+	// reverse read of read-1 is 209, 109 bases from that of its mate.
+	counts := []struct {
+		name       string
+		value, key int
+	}{
+		{"records", s.Records, 10},
+		{"total pairs", s.TotalPairs, 5},
+		{"mapped pairs", s.MappedPairs, 4},
+		{"unique pairs", s.UniquePairs, 3},
+		{"cis pairs", s.CisPairs, 2},
+		{"trans pairs", s.TransPairs, 1},
+		{"MAPQ 60", s.MAPQ[60], 7},
+		{"MAPQ 30", s.MAPQ[30], 2},
+		{"chr1 contacts", s.ContigContacts["chr1"], 5},
+		{"chr2 contacts", s.ContigContacts["chr2"], 1},
+		{"unmapped flags", s.Flags["unmapped"], 1},
+		{"read1 flags", s.Flags["read1"], 5},
+		{"distance bins", len(s.Distances), 7},
+		{"distance 0-2", s.Distances[0].Count, 1},
+		{"distance 64-128", s.Distances[6].Count, 1},
+	}
+	for _, c := range counts {
+		if c.value != c.key {
+			t.Errorf("AlignmentStatsFromSam(%s) counted %d %s, expected %d", samPath, c.value, c.name, c.key)
+		}
+	}
+	if s.CisTransRatio != 2 {
+		t.Errorf("AlignmentStatsFromSam(%s) yielded cis/trans ratio %f, expected 2", samPath, s.CisTransRatio)
+	}
+
+	var tsv bytes.Buffer
+	s.WriteTSV(&tsv)
+	for _, line := range []string{"pairs\tunique\t3\n", "insert_distance\t64-128\t1\n", "flags\tmate_unmapped\t1\n"} {
+		if !strings.Contains(tsv.String(), line) {
+			t.Errorf("AlignmentStats.WriteTSV() did not write %q:\n%s", line, tsv.String())
+		}
+	}
+
+	var js bytes.Buffer
+	if err := s.WriteJSON(&js); err != nil {
+		t.Fatal(err)
+	}
+	read := AlignmentStats{}
+	if err := json.Unmarshal(js.Bytes(), &read); err != nil || read.UniquePairs != 3 || read.ContigContacts["chr2"] != 1 {
+		t.Errorf("AlignmentStats.WriteJSON() wrote:\n%s", js.String())
+	}
+
+}
@@ -0,0 +1,223 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SAMTag is a single typed optional field of a SAM record, e.g. NM:i:1 or
+// SA:Z:10,52681560,+,100M100S,60,1;
+//
+// type value
+// A 	printable character, stored as a string of length one
+// i 	signed integer, stored as an int
+// f 	single-precision float, stored as a float64
+// Z 	printable string, stored as a string
+// H 	hex-formatted byte array, stored as a string
+// B 	numeric array, stored as []int or []float64, element type given by SubType
+type SAMTag struct {
+	Tag     string
+	Type    byte
+	SubType byte
+	Value   interface{}
+}
+
+// SAMTags is the ordered set of optional fields of an Alignment, ordered as they
+// appeared in the record so that they can be written back out unchanged.
+type SAMTags []SAMTag
+
+// Get returns the optional field with the specified two character tag.
+func (tags SAMTags) Get(tag string) (SAMTag, bool) {
+	for _, t := range tags {
+		if t.Tag == tag {
+			return t, true
+		}
+	}
+	return SAMTag{}, false
+}
+
+// Set adds an optional field to the set, replacing any existing field with the same tag.
+func (tags *SAMTags) Set(t SAMTag) {
+	for i, existing := range *tags {
+		if existing.Tag == t.Tag {
+			(*tags)[i] = t
+			return
+		}
+	}
+	*tags = append(*tags, t)
+}
+
+// String renders an optional field in the TAG:TYPE:VALUE format of a SAM record.
+func (t SAMTag) String() string {
+	switch v := t.Value.(type) {
+	case int:
+		return fmt.Sprintf("%s:%c:%d", t.Tag, t.Type, v)
+	case float64:
+		return fmt.Sprintf("%s:%c:%s", t.Tag, t.Type, strconv.FormatFloat(v, 'g', -1, 32))
+	case []int:
+		arr := make([]string, len(v))
+		for i, e := range v {
+			arr[i] = strconv.Itoa(e)
+		}
+		return fmt.Sprintf("%s:%c:%c", t.Tag, t.Type, t.SubType) + strings.Join(append([]string{""}, arr...), ",")
+	case []float64:
+		arr := make([]string, len(v))
+		for i, e := range v {
+			arr[i] = strconv.FormatFloat(e, 'g', -1, 32)
+		}
+		return fmt.Sprintf("%s:%c:%c", t.Tag, t.Type, t.SubType) + strings.Join(append([]string{""}, arr...), ",")
+	default:
+		return fmt.Sprintf("%s:%c:%v", t.Tag, t.Type, v)
+	}
+}
+
+// String renders a set of optional fields as the tab-delimited trailing columns
+// of a SAM record.
+func (tags SAMTags) String() string {
+	arr := make([]string, len(tags))
+	for i, t := range tags {
+		arr[i] = t.String()
+	}
+	return strings.Join(arr, "\t")
+}
+
+// parseSAMTag parses a single optional field in TAG:TYPE:VALUE format.
+func parseSAMTag(field string) (SAMTag, error) {
+
+	arr := strings.SplitN(field, ":", 3)
+	if len(arr) != 3 || len(arr[0]) != 2 || len(arr[1]) != 1 {
+		return SAMTag{}, fmt.Errorf("Malformed optional field in SAM record: %s", field)
+	}
+
+	t := SAMTag{Tag: arr[0], Type: arr[1][0]}
+	switch t.Type {
+	case 'A':
+		if len(arr[2]) != 1 {
+			return SAMTag{}, fmt.Errorf("Malformed character optional field in SAM record: %s", field)
+		}
+		t.Value = arr[2]
+	case 'i':
+		val, err := strconv.Atoi(arr[2])
+		if err != nil {
+			return SAMTag{}, fmt.Errorf("Malformed integer optional field in SAM record: %s", field)
+		}
+		t.Value = val
+	case 'f':
+		val, err := strconv.ParseFloat(arr[2], 64)
+		if err != nil {
+			return SAMTag{}, fmt.Errorf("Malformed float optional field in SAM record: %s", field)
+		}
+		t.Value = val
+	case 'Z', 'H':
+		t.Value = arr[2]
+	case 'B':
+		elements := strings.Split(arr[2], ",")
+		if len(elements[0]) != 1 {
+			return SAMTag{}, fmt.Errorf("Malformed array optional field in SAM record: %s", field)
+		}
+		t.SubType = elements[0][0]
+		elements = elements[1:]
+		if t.SubType == 'f' {
+			vals := make([]float64, len(elements))
+			for i, e := range elements {
+				val, err := strconv.ParseFloat(e, 64)
+				if err != nil {
+					return SAMTag{}, fmt.Errorf("Malformed array optional field in SAM record: %s", field)
+				}
+				vals[i] = val
+			}
+			t.Value = vals
+		} else if strings.IndexByte("cCsSiI", t.SubType) >= 0 {
+			vals := make([]int, len(elements))
+			for i, e := range elements {
+				val, err := strconv.Atoi(e)
+				if err != nil {
+					return SAMTag{}, fmt.Errorf("Malformed array optional field in SAM record: %s", field)
+				}
+				vals[i] = val
+			}
+			t.Value = vals
+		} else {
+			return SAMTag{}, fmt.Errorf("Unsupported array type in SAM optional field: %s", field)
+		}
+	default:
+		return SAMTag{}, fmt.Errorf("Unsupported type in SAM optional field: %s", field)
+	}
+
+	return t, nil
+
+}
+
+// parseSAMTags parses the optional fields following the eleven mandatory columns of a
+// SAM record.
+func parseSAMTags(fields []string) (SAMTags, error) {
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	tags := make(SAMTags, len(fields))
+	for i, field := range fields {
+		t, err := parseSAMTag(field)
+		if err != nil {
+			return nil, err
+		}
+		tags[i] = t
+	}
+	return tags, nil
+}
+
+// Tag returns the optional field of an alignment with the specified tag, e.g. "NM".
+func (a Alignment) Tag(tag string) (SAMTag, bool) {
+	return a.tags.Get(tag)
+}
+
+// IntTag returns the value of an integer optional field, e.g. NM, AS or XS.
+func (a Alignment) IntTag(tag string) (int, bool) {
+	t, ok := a.tags.Get(tag)
+	if !ok {
+		return 0, false
+	}
+	val, ok := t.Value.(int)
+	return val, ok
+}
+
+// FloatTag returns the value of a float optional field.
+func (a Alignment) FloatTag(tag string) (float64, bool) {
+	t, ok := a.tags.Get(tag)
+	if !ok {
+		return 0, false
+	}
+	val, ok := t.Value.(float64)
+	return val, ok
+}
+
+// StringTag returns the value of a character, string or hex optional field, e.g. MD,
+// SA, XA, MC or BX.
+func (a Alignment) StringTag(tag string) (string, bool) {
+	t, ok := a.tags.Get(tag)
+	if !ok {
+		return "", false
+	}
+	val, ok := t.Value.(string)
+	return val, ok
+}
+
+// IntArrayTag returns the value of an integer array (B) optional field.
+func (a Alignment) IntArrayTag(tag string) ([]int, bool) {
+	t, ok := a.tags.Get(tag)
+	if !ok {
+		return nil, false
+	}
+	val, ok := t.Value.([]int)
+	return val, ok
+}
+
+// FloatArrayTag returns the value of a float array (B) optional field.
+func (a Alignment) FloatArrayTag(tag string) ([]float64, bool) {
+	t, ok := a.tags.Get(tag)
+	if !ok {
+		return nil, false
+	}
+	val, ok := t.Value.([]float64)
+	return val, ok
+}
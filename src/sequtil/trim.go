@@ -0,0 +1,127 @@
+package util
+
+import (
+	"fmt"
+	"io"
+)
+
+// Junctions returns the sequences formed by the ligation of the ends of restriction
+// fragments cut by an enzyme, after the 5' overhangs left by the cut are filled in, e.g.
+// GATCGATC for DpnII and AAGCTAGCTT for HindIII. Each is given as a RestrictionSite
+// whose Cut is the end of the first fragment within the junction, the offset at which
+// reads spanning the junction are trimmed. Enzymes with several sites yield a junction
+// for each ordered pair of sites.
+func (e Enzyme) Junctions() []RestrictionSite {
+
+	junctions := []RestrictionSite{}
+	seen := map[string]bool{}
+	for _, s1 := range e.Sites {
+		for _, s2 := range e.Sites {
+			end := len(s1.Motif) - s1.Cut
+			junction := s1.Motif[:end] + s2.Motif[s2.Cut:]
+			if !seen[junction] {
+				seen[junction] = true
+				junctions = append(junctions, RestrictionSite{junction, end})
+			}
+		}
+	}
+
+	return junctions
+
+}
+
+// TrimStats tabulates the reads trimmed at ligation junctions, see TrimFastq.
+type TrimStats struct {
+	Reads        int
+	Trimmed      int
+	BasesRemoved int
+
+	// The number of reads trimmed at each junction
+	Junctions map[string]int
+}
+
+// NewTrimStats constructs an empty TrimStats.
+func NewTrimStats() *TrimStats {
+	return &TrimStats{Junctions: map[string]int{}}
+}
+
+// Write writes a tab-delimited report of the number of reads trimmed in total and at
+// each of the junctions of an enzyme.
+func (s *TrimStats) Write(out io.Writer, e Enzyme) error {
+
+	fraction := 0.0
+	if s.Reads > 0 {
+		fraction = float64(s.Trimmed) / float64(s.Reads)
+	}
+
+	if _, err := fmt.Fprintf(out, "reads\t%d\ntrimmed\t%d\nfraction_trimmed\t%f\nbases_removed\t%d\n", s.Reads, s.Trimmed, fraction, s.BasesRemoved); err != nil {
+		return err
+	}
+	for _, j := range e.Junctions() {
+		if _, err := fmt.Fprintf(out, "junction_%s\t%d\n", j.Motif, s.Junctions[j.Motif]); err != nil {
+			return err
+		}
+	}
+	return nil
+
+}
+
+// TrimJunction clips a read at the first ligation junction it contains, keeping the
+// sequence up to the end of the first fragment, such that the bases following the
+// junction, which derive from a different fragment, do not interfere with alignment.
+// The junction at which the read was trimmed is returned, or an empty string if the
+// read contains none.
+func TrimJunction(r FastqRecord, junctions []RestrictionSite) (FastqRecord, string) {
+
+	for i := 0; i < len(r.Seq); i++ {
+		for _, j := range junctions {
+			if j.matches(r.Seq, i) {
+				return FastqRecord{r.Header, r.Seq[:i+j.Cut], r.Qual[:i+j.Cut]}, j.Motif
+			}
+		}
+	}
+
+	return r, ""
+
+}
+
+// TrimFastq clips each read of a FASTQ file at the first ligation junction of the
+// enzyme that it contains, see TrimJunction, tabulating the reads trimmed in stats.
+// Reads are trimmed independently, such that interleaved files remain interleaved,
+// and every read is written, including those trimmed to nothing.
+func TrimFastq(inPath, outPath string, e Enzyme, stats *TrimStats) error {
+
+	in, err := OpenFastq(inPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := CreateFastq(outPath)
+	if err != nil {
+		return err
+	}
+
+	junctions := e.Junctions()
+	for in.Scan() {
+		r := in.Record()
+		trimmed, junction := TrimJunction(r, junctions)
+		stats.Reads += 1
+		if len(junction) > 0 {
+			stats.Trimmed += 1
+			stats.BasesRemoved += len(r.Seq) - len(trimmed.Seq)
+			stats.Junctions[junction] += 1
+		}
+		if err := out.Write(trimmed); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err := in.Err(); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+
+}
@@ -3,6 +3,7 @@ package util
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -214,9 +215,8 @@ func ReadVariants(path string) (Variants, int, error) {
 
 	vars := NewVariants()
 
-	in, err := os.Open(path)
+	in, err := OpenInput(path)
 	if err != nil {
-		fmt.Errorf("Couldn't open VCF file %s\n", path)
 		return vars, 0, err
 	}
 	defer in.Close()
@@ -263,16 +263,16 @@ func ReadVariants(path string) (Variants, int, error) {
 }
 
 // TODO: write variants in appropriate sorted order
-// WriteVariants writes a variant object to a VCF file on disk
+// WriteVariants writes a variant object to a VCF file on disk, BGZF compressing it if
+// the path ends in ".gz".
 func WriteVariants(v Variants, path string) error {
 
 	MkdirForFile(path)
 
-	out, err := os.Create(path)
+	out, err := CreateOutput(path)
 	if err != nil {
-		fmt.Errorf("Couldn't open VCF file %s\n", path)
+		return fmt.Errorf("Couldn't open VCF file %s: %s", path, err)
 	}
-	defer out.Close()
 
 	// Construct the header string, including the name of each sample if there is this
 	// information in the header field of the variants object
@@ -285,37 +285,41 @@ func WriteVariants(v Variants, path string) error {
 	}
 
 	// Write the header line to the output
-	out.WriteString(header + "\n")
+	io.WriteString(out, header+"\n")
 
 	// Print VCF body
 	for _, value := range v.data {
 		for _, value2 := range value {
-			out.WriteString(value2.String() + "\n")
+			io.WriteString(out, value2.String()+"\n")
 		}
 	}
 
-	return err
+	return out.Close()
 
 }
 
 // SimPhasedBlocks take a path to a set of sequence variants with known phase and simulates
 // blocks of known phase of a specified block and gap size.
-func SimPhasedBlocks(varPath, outPath string, size, gap int) error {
+func SimPhasedBlocks(varPath, outPath string, size, gap int) (err error) {
 
 	// Note: Assumes the input is sorted.
 	// TODO: Add a check for whether a provided input is indeed sorted.
 
 	// Open the output file for writing
-	out, err := os.Create(outPath)
+	out, err := CreateOutput(outPath)
 	if err != nil {
-		fmt.Errorf("Couldn't create output file %s to which to write variant block simulation\n", outPath)
+		return fmt.Errorf("Couldn't create output file %s to which to write variant block simulation: %s", outPath, err)
 	}
-	defer out.Close()
+	defer func() {
+		if e := out.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
 
 	// Open the input file and instantiate a bufio reader
-	in, err2 := os.Open(varPath)
+	in, err2 := OpenInput(varPath)
 	if err2 != nil {
-		fmt.Errorf("Couldn't open input variants file for variant block simulation\n", outPath)
+		return err2
 	}
 	defer in.Close()
 
@@ -348,12 +352,12 @@ func SimPhasedBlocks(varPath, outPath string, size, gap int) error {
 			for _, sample := range samples {
 				header = header + "\t" + sample
 			}
-			out.WriteString(header + "\n")
+			io.WriteString(out, header+"\n")
 			wroteHeader = true
 		} else if string(line[0]) != "#" {
 
 			if !wroteHeader {
-				out.WriteString(header + "\n")
+				io.WriteString(out, header+"\n")
 				wroteHeader = true
 			}
 
@@ -386,7 +390,7 @@ func SimPhasedBlocks(varPath, outPath string, size, gap int) error {
 					block := fmt.Sprintf("BLOCK:b%d", blockNum)
 					//variant.Info = variant.Info + ";" + block
 					variant.Info["BLOCK"] = string(block)
-					out.WriteString(variant.String() + "\n")
+					io.WriteString(out, variant.String()+"\n")
 					break
 				}
 
@@ -415,7 +419,7 @@ func PartitionVariantsByContig(inputPath, outstem string) error {
 	//header := "test"
 
 	// Open the input file for reading
-	infh, err := os.Open(inputPath)
+	infh, err := OpenInput(inputPath)
 	if err != nil {
 		return fmt.Errorf("error: PartitionVariantsByContig(%s, %s) failed at attempt to open input file", inputPath, outstem)
 	}